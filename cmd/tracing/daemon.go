@@ -16,22 +16,35 @@ import (
 	"io"
 	"math"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"runtime/pprof"
+	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-xray-daemon/pkg/admin"
+	"github.com/aws/aws-xray-daemon/pkg/breaker"
 	"github.com/aws/aws-xray-daemon/pkg/bufferpool"
 	"github.com/aws/aws-xray-daemon/pkg/cfg"
 	"github.com/aws/aws-xray-daemon/pkg/cli"
 	"github.com/aws/aws-xray-daemon/pkg/conn"
+	"github.com/aws/aws-xray-daemon/pkg/deadletter"
+	"github.com/aws/aws-xray-daemon/pkg/exporter"
+	"github.com/aws/aws-xray-daemon/pkg/filter"
+	"github.com/aws/aws-xray-daemon/pkg/gateway"
 	"github.com/aws/aws-xray-daemon/pkg/logger"
+	"github.com/aws/aws-xray-daemon/pkg/overflow"
 	"github.com/aws/aws-xray-daemon/pkg/processor"
 	"github.com/aws/aws-xray-daemon/pkg/profiler"
 	"github.com/aws/aws-xray-daemon/pkg/proxy"
 	"github.com/aws/aws-xray-daemon/pkg/ringbuffer"
+	"github.com/aws/aws-xray-daemon/pkg/routing"
 	"github.com/aws/aws-xray-daemon/pkg/socketconn"
 	"github.com/aws/aws-xray-daemon/pkg/socketconn/udp"
+	"github.com/aws/aws-xray-daemon/pkg/spool"
 	"github.com/aws/aws-xray-daemon/pkg/telemetry"
 	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
 	"github.com/aws/aws-xray-daemon/pkg/util"
@@ -48,6 +61,16 @@ const protocolSeparator = "\n"
 // Log Rotation Size is 50 MB
 const logRotationSize int64 = 50 * 1024 * 1024
 
+// Size in MB of the segments of an S3 object, and its age in seconds, after which it is uploaded, unless configured.
+const defaultMaxObjectSizeMB = 64
+const defaultFlushIntervalSecond = 300
+
+// Time the processor and telemetry are given to finish at shutdown once uploads are cancelled.
+const drainGracePeriod = 5 * time.Second
+
+// Share of the buffer memory limit in-progress segments held for coalescing can use, in percent.
+const coalesceMemoryPercent = 10
+
 var udpAddress string
 var tcpAddress string
 
@@ -65,6 +88,7 @@ var version bool
 var logLevel string
 var regionFlag string
 var proxyAddress string
+var dryRun bool
 
 // Daemon reads trace segments from X-Ray daemon address and
 // send to X-Ray service.
@@ -75,6 +99,15 @@ type Daemon struct {
 	// Ring buffer, used to stored segments received.
 	std *ringbuffer.RingBuffer
 
+	// Ring buffer, used to store error, fault, throttle and header flagged segments received.
+	pri *ringbuffer.RingBuffer
+
+	// Per-service queues used instead of std when fair queuing is enabled, nil otherwise.
+	fair *ringbuffer.FairQueue
+
+	// Key identifying the service of a segment in fair queues: name or tenant.
+	fairKey string
+
 	// Counter for segments read by daemon.
 	count uint64
 
@@ -89,6 +122,28 @@ type Daemon struct {
 
 	// HTTP Proxy server
 	server *proxy.Server
+
+	// Filter used to drop segments matching configured rules.
+	filter *filter.Filter
+
+	// Router picking the destination of segments, nil if no routing rule is configured.
+	router *routing.Router
+
+	// HTTP admin server, nil if not configured.
+	admin *admin.Server
+
+	// Write-ahead spool of segments which cannot be delivered, nil if not configured.
+	wal *spool.Spool
+
+	// HTTP server receiving segments forwarded by other daemons, nil if not configured.
+	gateway *gateway.Server
+
+	// Context of uploads and telemetry, cancelled once the drain timeout expires at shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Time queued segments are sent for at shutdown, before uploads are cancelled.
+	drainTimeout time.Duration
 }
 
 func init() {
@@ -124,6 +179,7 @@ func initCli(configFile string) (*cli.Flag, *cfg.Config) {
 		defaultRegion                    = cnfg.Region
 		defaultResourceARN               = cnfg.ResourceARN
 		defaultProxyAddress              = cnfg.ProxyAddress
+		defaultDryRun                    = cnfg.DryRun.Enabled
 	)
 	socketConnection = "UDP"
 	regionFlag = defaultRegion
@@ -138,6 +194,7 @@ func initCli(configFile string) (*cli.Flag, *cfg.Config) {
 	flag.StringVarF(&logFile, "log-file", "f", defaultLogPath, "Output logs to the specified file path.")
 	flag.StringVarF(&logLevel, "log-level", "l", defaultLogLevel, "Log level, from most verbose to least: dev, debug, info, warn, error, prod (default).")
 	flag.StringVarF(&proxyAddress, "proxy-address", "p", defaultProxyAddress, "Proxy address through which to upload segments.")
+	flag.BoolVarF(&dryRun, "dry-run", "d", *defaultDryRun, "Process segments without uploading them to X-Ray, needing no credentials or region.")
 	flag.BoolVarF(&version, "version", "v", false, "Show AWS X-Ray daemon version.")
 	return flag, cnfg
 }
@@ -187,40 +244,175 @@ func initDaemon(config *cfg.Config) *Daemon {
 	}
 	log.Infof("%v segment buffers allocated", buffers)
 	bufferPool := bufferpool.Init(buffers, receiveBufferSize)
-	std := ringbuffer.New(buffers, bufferPool)
+	if config.DeadLetter.Directory != "" && config.Spool.Directory != "" && nested(config.DeadLetter.Directory, config.Spool.Directory) {
+		log.Errorf("DeadLetter directory %v and Spool directory %v must not be the same or within one another, as each removes the files of the other",
+			config.DeadLetter.Directory, config.Spool.Directory)
+		os.Exit(1)
+	}
+	wal := getWriteAheadSpool(config)
+	overflowConfig := getOverflowConfig(config, wal)
+	log.Infof("Using %v overflow policy", overflowConfig.Policy)
+	std := ringbuffer.NewWithOverflow(buffers, bufferPool, overflowConfig)
+	pri := ringbuffer.NewPriority(buffers, bufferPool, std)
+	var fair *ringbuffer.FairQueue
+	if *config.FairQueue.Enabled {
+		if config.FairQueue.Key != "name" && config.FairQueue.Key != "tenant" {
+			log.Errorf("Unknown fair queue key %q, expected name or tenant", config.FairQueue.Key)
+			os.Exit(1)
+		}
+		log.Infof("Using fair queuing of segments by %v", config.FairQueue.Key)
+		fair = ringbuffer.NewFairQueueWithOverflow(buffers, bufferPool, config.FairQueue.Weights, config.FairQueue.MinSharePercent, overflowConfig)
+	}
 	if config.Endpoint != "" {
 		log.Debugf("Using Endpoint read from Config file: %s", config.Endpoint)
 	}
-	ctx := context.Background()
-	awsConfig, err := conn.GetAWSConfig(ctx, &conn.Conn{}, config, roleArn, regionFlag, noMetadata)
-	if err != nil {
-		log.Errorf("Unable to get AWS config: %v", err)
-		os.Exit(1)
-	}
-	log.Infof("Using region: %s", awsConfig.Region)
+	ctx, cancel := context.WithCancel(context.Background())
+	var awsConfig aws.Config
+	var primary exporter.Exporter
+	var failover *conn.Failover
+	forwarding := len(config.Forward.Gateways) > 0
+	if dryRun {
+		primary = getDryRunExporter(config)
+		log.Debugf("ARN of the AWS resource running the daemon: %v", resourceARN)
+		telemetry.InitLocal(ctx, resourceARN)
+	} else if forwarding {
+		primary = getForwardExporter(config, parameterConfig)
+		log.Debugf("ARN of the AWS resource running the daemon: %v", resourceARN)
+		telemetry.InitLocal(ctx, resourceARN)
+	} else {
+		awsConfig, err = conn.GetAWSConfig(ctx, &conn.Conn{}, config, roleArn, regionFlag, noMetadata)
+		if err != nil {
+			log.Errorf("Unable to get AWS config: %v", err)
+			os.Exit(1)
+		}
+		log.Infof("Using region: %s", awsConfig.Region)
+		var x conn.XRay
+		if failover = getFailover(ctx, config, awsConfig); failover != nil {
+			x = failover
+		} else {
+			x = conn.NewXRay(awsConfig)
+		}
+		if x == nil {
+			log.Error("X-Ray client returned nil")
+			os.Exit(1)
+		}
+		primary = exporter.NewXRay(x)
 
-	log.Debugf("ARN of the AWS resource running the daemon: %v", resourceARN)
-	telemetry.Init(ctx, awsConfig, resourceARN, noMetadata)
+		log.Debugf("ARN of the AWS resource running the daemon: %v", resourceARN)
+		telemetry.Init(ctx, awsConfig, resourceARN, noMetadata)
+	}
 
+	parameterConfig.Processor.BatchSize = util.GetMinIntValue(config.Batching.MaxCount, cfg.MaxBatchCount)
+	parameterConfig.Processor.BatchMaxBytes = util.GetMinIntValue(config.Batching.MaxBytes, cfg.MaxBatchBytes)
+	parameterConfig.Processor.BatchMaxAgeMillisecond = config.Batching.MaxAgeMillisecond
+	parameterConfig.Processor.TraceAffinity = *config.Batching.TraceAffinity
+	if *config.Coalescing.Enabled {
+		parameterConfig.Processor.CoalesceWindowMillisecond = config.Coalescing.WindowMillisecond
+		parameterConfig.Processor.CoalesceTTLSecond = config.Coalescing.TTLSecond
+		parameterConfig.Processor.CoalesceMaxBytes = memoryLimit * 1024 * 1024 * coalesceMemoryPercent / 100
+	}
+	parameterConfig.Processor.RequestsPerSecond = config.RateLimit.RequestsPerSecond
+	parameterConfig.Processor.SegmentsPerSecond = config.RateLimit.SegmentsPerSecond
+	if *config.AdaptiveConcurrency.Enabled {
+		parameterConfig.Processor.MinConcurrency = config.AdaptiveConcurrency.Min
+		parameterConfig.Processor.MaxConcurrency = config.AdaptiveConcurrency.Max
+		parameterConfig.Processor.LatencyThresholdMillisecond = config.AdaptiveConcurrency.LatencyThresholdMillisecond
+	}
 	// If calculated number of buffer is lower than our default, use calculated one. Otherwise, use default value.
 	parameterConfig.Processor.BatchSize = util.GetMinIntValue(parameterConfig.Processor.BatchSize, buffers)
 
 	config.Socket.TCPAddress = tcpAddress // assign final tcp address either through config file or cmd line
-	// Create proxy http server
-	server, err := proxy.NewServer(config, awsConfig)
+	// Create proxy http server, which forwards requests to X-Ray, or to the gateways when forwarding to them.
+	// It is not started in dry run.
+	var server *proxy.Server
+	if forwarding {
+		server, err = proxy.NewForwardingServer(config, config.Forward.Gateways, config.Forward.Token)
+	} else if !dryRun {
+		server, err = proxy.NewServerWithFailover(config, awsConfig, failover)
+	}
 	if err != nil {
 		log.Errorf("Unable to start http proxy server: %v", err)
 		os.Exit(1)
 	}
 
+	segmentFilter, err := filter.New(config.Filter.Rules, *config.Filter.DryRun)
+	if err != nil {
+		log.Errorf("Unable to create segment filter: %v", err)
+		os.Exit(1)
+	}
+	if segmentFilter.Enabled() {
+		log.Infof("Using %v segment filter rule(s), dry run: %v", len(config.Filter.Rules), *config.Filter.DryRun)
+	}
+
+	var walSink processor.Spool
+	if wal != nil {
+		walSink = wal
+	}
+	uploadBreaker, spoolOnOpen := getCircuitBreaker(config, wal)
+	if config.DeadLetter.Directory != "" {
+		if err := deadletter.Init(config.DeadLetter.Directory, int64(config.DeadLetter.SizeLimitMB)*1024*1024); err != nil {
+			log.Errorf("Unable to use dead-letter directory: %v", err)
+			os.Exit(1)
+		}
+		log.Infof("Writing dropped and rejected segments to %v", config.DeadLetter.Directory)
+	}
+	var adminServer *admin.Server
+	if config.Admin.Address != "" {
+		adminServer = admin.NewServer(config.Admin.Address)
+		adminServer.Handle("/counters", telemetry.T)
+		if deadletter.D != nil {
+			adminServer.Handle("/deadletter", deadletter.D)
+		}
+	}
+
+	sinks := getSinks(ctx, config, awsConfig, parameterConfig)
+	routes, router := getRoutes(ctx, config, awsConfig, parameterConfig)
+	segmentProcessor := processor.New(ctx, primary, processorCount, std, pri, bufferPool, parameterConfig, processor.Options{
+		Fair:        fair,
+		Overflow:    overflowConfig,
+		Spool:       walSink,
+		Breaker:     uploadBreaker,
+		SpoolOnOpen: spoolOnOpen,
+		Sinks:       sinks,
+		Routes:      routes,
+		Wrap:        getWrap(forwarding),
+	})
+
 	daemon := &Daemon{
-		done:      make(chan bool),
-		std:       std,
-		pool:      bufferPool,
-		count:     0,
-		sock:      sock,
-		server:    server,
-		processor: processor.New(awsConfig, processorCount, std, bufferPool, parameterConfig),
+		done:         make(chan bool),
+		std:          std,
+		pri:          pri,
+		fair:         fair,
+		fairKey:      config.FairQueue.Key,
+		pool:         bufferPool,
+		count:        0,
+		sock:         sock,
+		server:       server,
+		filter:       segmentFilter,
+		router:       router,
+		admin:        adminServer,
+		wal:          wal,
+		processor:    segmentProcessor,
+		ctx:          ctx,
+		cancel:       cancel,
+		drainTimeout: time.Second * time.Duration(config.Shutdown.DrainTimeoutSecond),
+	}
+	if config.Gateway.Address != "" {
+		if config.Gateway.Token == "" {
+			log.Warn("Gateway server accepts segments without authorization, set a gateway token to authorize forwarding daemons")
+		}
+		daemon.gateway, err = gateway.NewServer(gateway.Config{
+			Address:         config.Gateway.Address,
+			Token:           config.Gateway.Token,
+			TLSCertFile:     config.Gateway.TLSCertFile,
+			TLSKeyFile:      config.Gateway.TLSKeyFile,
+			MaxRequestBytes: int64(config.Gateway.MaxRequestSizeMB) * 1024 * 1024,
+			Proxy:           getGatewayProxy(server, forwarding),
+		}, daemon.receiveForwarded)
+		if err != nil {
+			log.Errorf("Unable to start gateway server: %v", err)
+			os.Exit(1)
+		}
 	}
 
 	return daemon
@@ -228,7 +420,15 @@ func initDaemon(config *cfg.Config) *Daemon {
 
 func runDaemon(daemon *Daemon) {
 	// Start http server for proxying requests to xray
-	go daemon.server.Serve()
+	if daemon.server != nil {
+		go daemon.server.Serve()
+	}
+	if daemon.admin != nil {
+		go daemon.admin.Serve()
+	}
+	if daemon.gateway != nil {
+		go daemon.gateway.Serve()
+	}
 
 	for i := 0; i < receiverCount; i++ {
 		go daemon.poll()
@@ -241,39 +441,112 @@ func (d *Daemon) close() {
 	}
 	// Signal routines to finish
 	// This will push telemetry and customer segments in parallel
+	d.pri.Close()
 	d.std.Close()
-	telemetry.T.Quit <- true
+	if d.fair != nil {
+		d.fair.Close()
+	}
+	// Sent asynchronously, as telemetry may be blocked sending records.
+	go func() {
+		telemetry.T.Quit <- true
+	}()
 
-	<-d.processor.Done
-	<-telemetry.T.Done
+	deadline := time.NewTimer(d.drainTimeout)
+	processorDone := wait(d.processor.Done, deadline.C)
+	telemetryDone := processorDone && wait(telemetry.T.Done, deadline.C)
+	deadline.Stop()
+	if !telemetryDone {
+		log.Warnf("Segments not sent within drain timeout of %v. Cancelling uploads", d.drainTimeout)
+		d.cancel()
+		grace := time.After(drainGracePeriod)
+		if !processorDone && !wait(d.processor.Done, grace) {
+			log.Errorf("Processor not done %v after uploads were cancelled. Abandoning segments left", drainGracePeriod)
+		} else if !wait(telemetry.T.Done, grace) {
+			log.Errorf("Telemetry not done %v after uploads were cancelled", drainGracePeriod)
+		}
+	}
+	d.cancel()
+
+	if deadletter.D != nil {
+		if err := deadletter.D.Close(); err != nil {
+			log.Errorf("%v", err)
+		}
+	}
+	// Segments left in the spool are replayed by the next run.
+	var inSpool int
+	var spoolDropped int64
+	if d.wal != nil {
+		if err := d.wal.Close(); err != nil {
+			log.Errorf("%v", err)
+		}
+		n, err := d.wal.Len()
+		if err != nil {
+			log.Errorf("%v", err)
+		}
+		inSpool = n
+		spoolDropped = d.wal.DroppedCount()
+	}
 
 	profiler.MemSnapShot(&memProfile)
-	log.Debugf("Trace segment: received: %d, truncated: %d, processed: %d", atomic.LoadUint64(&d.count), d.std.TruncatedCount(), d.processor.ProcessedCount())
+	truncated := d.std.TruncatedCount()
+	if d.fair != nil {
+		truncated += d.fair.TruncatedCount()
+	}
+	log.Debugf("Trace segment: received: %d, truncated: %d, processed: %d", atomic.LoadUint64(&d.count), truncated, d.processor.ProcessedCount())
+	if d.filter.Enabled() {
+		log.Debugf("Trace segment filter: matched: %d, dropped: %d", d.filter.MatchedCount(), d.filter.DroppedCount())
+	}
+	totals := telemetry.T.Totals()
+	filtered := d.filter.DroppedCount()
+	exportersLost := d.processor.ExporterLostCount()
+	log.Infof("Trace segments since start: received: %d, sent: %d, rejected: %d, written to spool: %d, left in spool: %d", totals.Received,
+		totals.Sent, totals.Rejected, processor.SpooledCount()+ringbuffer.SpilledCount(), inSpool)
+	log.Infof("Trace segments dropped since start: %d (buffers and queues: %d, spool limits: %d, filter: %d, exporters: %d)",
+		totals.Spillover+spoolDropped+int64(filtered)+exportersLost, totals.Spillover, spoolDropped, filtered, exportersLost)
 	log.Debugf("Shutdown finished. Current epoch in nanoseconds: %v", time.Now().UnixNano())
 }
 
+// wait returns true once done is signalled, false if deadline fires first.
+func wait(done <-chan bool, deadline <-chan time.Time) bool {
+	select {
+	case <-done:
+		return true
+	case <-deadline:
+		return false
+	}
+}
+
 func (d *Daemon) stop() {
 	d.sock.Close()
-	d.server.Close()
+	if d.admin != nil {
+		d.admin.Close()
+	}
+	if d.gateway != nil {
+		d.gateway.Close()
+	}
+	if d.server != nil {
+		// Requests in flight are proxied until the drain timeout expires.
+		d.server.Drain(d.ctx)
+	}
 }
 
-// Returns number of bytes read from socket connection.
-func (d *Daemon) read(buf *[]byte) int {
+// Returns number of bytes read from socket connection, and the address they were sent from.
+func (d *Daemon) read(buf *[]byte) (int, net.Addr) {
 	bufVal := *buf
-	rlen, err := d.sock.Read(bufVal)
+	rlen, source, err := d.sock.ReadFrom(bufVal)
 	switch err := err.(type) {
 	case net.Error:
 		if !err.Temporary() {
 			d.done <- true
-			return -1
+			return -1, nil
 		}
 		log.Errorf("daemon: net: err: %v", err)
-		return 0
+		return 0, nil
 	case error:
 		log.Errorf("daemon: socket: err: %v", err)
-		return 0
+		return 0, nil
 	}
-	return rlen
+	return rlen, source
 }
 
 func (d *Daemon) poll() {
@@ -282,14 +555,14 @@ func (d *Daemon) poll() {
 	splitBuf := make([][]byte, 2)
 
 	for {
-		bufPointer := d.pool.Get()
+		bufPointer := d.getBuffer()
 		fallbackPointerUsed := false
 		if bufPointer == nil {
 			log.Debug("Pool does not have any buffer.")
 			bufPointer = &fallBackBuffer
 			fallbackPointerUsed = true
 		}
-		rlen := d.read(bufPointer)
+		rlen, source := d.read(bufPointer)
 		if rlen > 0 {
 			telemetry.T.SegmentReceived(1)
 		}
@@ -301,6 +574,9 @@ func (d *Daemon) poll() {
 		}
 		if fallbackPointerUsed {
 			log.Warn("Segment dropped. Consider increasing memory limit")
+			if rlen > 0 {
+				deadletter.D.Record(deadletter.ReasonNoBuffer, "", fallBackBuffer[:rlen])
+			}
 			telemetry.T.SegmentSpillover(1)
 			continue
 		} else if rlen == -1 {
@@ -313,6 +589,7 @@ func (d *Daemon) poll() {
 		slices := util.SplitHeaderBody(&bufMessage, &separator, &splitBuf)
 		if len(slices[1]) == 0 {
 			log.Warnf("Missing header or segment: %s", string(slices[0]))
+			deadletter.D.Record(deadletter.ReasonMissingSegment, "", bufMessage)
 			d.pool.Return(bufPointer)
 			telemetry.T.SegmentRejected(1)
 			continue
@@ -327,6 +604,7 @@ func (d *Daemon) poll() {
 		case true:
 		default:
 			log.Warnf("Invalid header: %s", string(header))
+			deadletter.D.Record(deadletter.ReasonInvalidHeader, "", bufMessage)
 			d.pool.Return(bufPointer)
 			telemetry.T.SegmentRejected(1)
 			continue
@@ -336,12 +614,490 @@ func (d *Daemon) poll() {
 			Raw:     &payload,
 			PoolBuf: bufPointer,
 		}
+		d.dispatch(headerInfo, source, ts)
+	}
+}
+
+// getBuffer returns a buffer of the pool, taken from a service above its share of the fair queue
+// if the pool is empty, nil if none is available.
+func (d *Daemon) getBuffer() *[]byte {
+	bufPointer := d.pool.Get()
+	if bufPointer == nil && d.fair != nil && d.fair.Reclaim() {
+		bufPointer = d.pool.Get()
+	}
+	return bufPointer
+}
+
+// dispatch filters segment ts, received from address source, picks its destination and sends it
+// to the ring buffer or fair queue it belongs to.
+func (d *Daemon) dispatch(headerInfo tracesegment.Header, source net.Addr, ts *tracesegment.TraceSegment) {
+	if d.filter.Drop(ts) {
+		d.pool.Return(ts.PoolBuf)
+		return
+	}
+	ts.Route = d.router.Route(headerInfo, source, ts)
+	ts.Header = headerInfo
 
-		atomic.AddUint64(&d.count, 1)
+	atomic.AddUint64(&d.count, 1)
+	if isPriority(headerInfo, ts) {
+		d.pri.Send(ts)
+	} else if d.fair != nil {
+		d.fair.Send(d.serviceKey(headerInfo, ts), ts)
+	} else {
 		d.std.Send(ts)
 	}
 }
 
+// receiveForwarded dispatches segment document doc, forwarded by another daemon with the header it
+// received doc with, like segments read from the socket. Returns false if doc was dropped.
+func (d *Daemon) receiveForwarded(header tracesegment.Header, doc []byte) bool {
+	telemetry.T.SegmentReceived(1)
+	bufPointer := d.getBuffer()
+	if bufPointer == nil {
+		log.Warn("Forwarded segment dropped. Consider increasing memory limit")
+		deadletter.D.Record(deadletter.ReasonNoBuffer, "", doc)
+		telemetry.T.SegmentSpillover(1)
+		return false
+	}
+	buf := *bufPointer
+	if len(doc) > len(buf) {
+		log.Warnf("Forwarded segment of %d bytes exceeds the segment buffer of %d bytes", len(doc), len(buf))
+		deadletter.D.Record(deadletter.ReasonTooLarge, "", doc)
+		d.pool.Return(bufPointer)
+		telemetry.T.SegmentRejected(1)
+		return false
+	}
+	payload := buf[:copy(buf, doc)]
+	d.dispatch(header, nil, &tracesegment.TraceSegment{
+		Raw:     &payload,
+		PoolBuf: bufPointer,
+	})
+	return true
+}
+
+// serviceKey returns the key of the fair queue segment ts is sent to.
+func (d *Daemon) serviceKey(header tracesegment.Header, ts *tracesegment.TraceSegment) string {
+	if d.fairKey == "tenant" {
+		return header.Tenant
+	}
+	doc, err := ts.Document()
+	if err != nil {
+		return ""
+	}
+	return doc.Name
+}
+
+// getWrap returns how segments are sent to the primary exporter: in gateway envelopes carrying their
+// header when forwarding, so gateways route and prioritize them as received, as is otherwise.
+func getWrap(forwarding bool) func(ts *tracesegment.TraceSegment) string {
+	if !forwarding {
+		return nil
+	}
+	return gateway.Wrap
+}
+
+// isPriority returns true if segment ts is flagged by its header or marked with error, fault or throttle.
+func isPriority(header tracesegment.Header, ts *tracesegment.TraceSegment) bool {
+	return header.Priority || ts.HasErrorFlag()
+}
+
+// getWriteAheadSpool returns the spool of segments which cannot be delivered, nil if not configured.
+func getWriteAheadSpool(config *cfg.Config) *spool.Spool {
+	if config.Spool.Directory == "" {
+		return nil
+	}
+	s, err := spool.NewWithLimits(config.Spool.Directory, int64(config.Spool.SizeLimitMB)*1024*1024,
+		time.Minute*time.Duration(config.Spool.TTLMinute))
+	if err != nil {
+		log.Errorf("Unable to use spool directory: %v", err)
+		os.Exit(1)
+	}
+	log.Infof("Spooling undelivered segments to %v", config.Spool.Directory)
+	return s
+}
+
+// getCircuitBreaker returns the circuit breaker around X-Ray uploads, nil if disabled,
+// and whether batches are spooled while it is open.
+func getCircuitBreaker(config *cfg.Config, wal *spool.Spool) (*breaker.Breaker, bool) {
+	if !*config.CircuitBreaker.Enabled {
+		return nil, false
+	}
+	spoolOnOpen := false
+	switch config.CircuitBreaker.Fallback {
+	case "drop":
+	case "spool":
+		if wal == nil {
+			log.Error("Circuit breaker spool fallback requires a Spool directory")
+			os.Exit(1)
+		}
+		spoolOnOpen = true
+	default:
+		log.Errorf("Unknown circuit breaker fallback %q, expected drop or spool", config.CircuitBreaker.Fallback)
+		os.Exit(1)
+	}
+	log.Infof("Using circuit breaker with %v fallback", config.CircuitBreaker.Fallback)
+	return breaker.New("xray", getBreakerConfig(config)), spoolOnOpen
+}
+
+// getBreakerConfig returns the config of the circuit breakers from that of the daemon.
+func getBreakerConfig(config *cfg.Config) breaker.Config {
+	return breaker.Config{
+		ConsecutiveFailures: config.CircuitBreaker.ConsecutiveFailures,
+		ErrorRatePercent:    config.CircuitBreaker.ErrorRatePercent,
+		MinRequests:         config.CircuitBreaker.MinRequests,
+		Window:              time.Second * time.Duration(config.CircuitBreaker.WindowSecond),
+		OpenTimeout:         time.Second * time.Duration(config.CircuitBreaker.OpenTimeoutSecond),
+	}
+}
+
+// getFailover returns the failover from the X-Ray endpoint of awsConfig to the configured secondary endpoints,
+// nil if none is configured. Secondary endpoints use the role of the daemon.
+func getFailover(ctx context.Context, config *cfg.Config, awsConfig aws.Config) *conn.Failover {
+	if len(config.Failover.Endpoints) == 0 {
+		return nil
+	}
+	configs := []aws.Config{awsConfig}
+	for _, e := range config.Failover.Endpoints {
+		if e.Region == "" && e.Endpoint == "" {
+			log.Error("Failover endpoints need a Region or an Endpoint")
+			os.Exit(1)
+		}
+		region := e.Region
+		if region == "" {
+			region = awsConfig.Region
+		}
+		c, err := conn.GetAWSConfig(ctx, &conn.Conn{}, config, roleArn, region, noMetadata)
+		if err != nil {
+			log.Errorf("Unable to get AWS config of failover endpoint: %v", err)
+			os.Exit(1)
+		}
+		// The Endpoint of the daemon only applies to the primary endpoint.
+		c.BaseEndpoint = nil
+		if e.Endpoint != "" {
+			c.BaseEndpoint = aws.String(e.Endpoint)
+		}
+		configs = append(configs, c)
+	}
+	log.Infof("Failing over to %v secondary X-Ray endpoint(s) after %v seconds of failures", len(config.Failover.Endpoints),
+		config.Failover.FailoverAfterSecond)
+	return conn.NewFailover(configs, conn.FailoverConfig{
+		FailoverAfter: time.Second * time.Duration(config.Failover.FailoverAfterSecond),
+		ProbeInterval: time.Second * time.Duration(config.Failover.ProbeIntervalSecond),
+	})
+}
+
+// getDryRunExporter returns the exporter standing in for X-Ray in dry run.
+func getDryRunExporter(config *cfg.Config) exporter.Exporter {
+	switch config.DryRun.Output {
+	case "stdout":
+		log.Info("Dry run: printing segment batches to stdout instead of sending them to X-Ray")
+		return exporter.NewWriter(os.Stdout)
+	case "count":
+		log.Info("Dry run: counting segment batches instead of sending them to X-Ray")
+		return exporter.Discard{}
+	default:
+		log.Errorf("Unknown dry run output %q, expected stdout or count", config.DryRun.Output)
+		os.Exit(1)
+	}
+	return nil
+}
+
+// getGatewayProxy returns the handler of the X-Ray API calls proxied by forwarding daemons to the gateway server,
+// that of the proxy server if it signs requests for X-Ray, nil otherwise.
+func getGatewayProxy(server *proxy.Server, forwarding bool) http.Handler {
+	if server == nil || forwarding {
+		return nil
+	}
+	return server.Handler
+}
+
+// getForwardExporter returns the exporter forwarding batches to the configured gateways instead of X-Ray.
+func getForwardExporter(config *cfg.Config, parameterConfig *cfg.ParameterConfig) exporter.Exporter {
+	timeout := config.Forward.TimeoutSecond
+	if timeout == 0 {
+		timeout = parameterConfig.Processor.RequestTimeout
+	}
+	f, err := exporter.NewForward(exporter.ForwardConfig{
+		Gateways:          config.Forward.Gateways,
+		Token:             config.Forward.Token,
+		ConsistentHashing: *config.Forward.ConsistentHashing,
+		Timeout:           time.Second * time.Duration(timeout),
+	})
+	if err != nil {
+		log.Errorf("Unable to forward segments to gateways: %v", err)
+		os.Exit(1)
+	}
+	log.Infof("Forwarding segment batches to gateways %v instead of sending them to X-Ray", config.Forward.Gateways)
+	return f
+}
+
+// getSinks returns the exporters configured to receive the segments of the default destination in
+// addition to X-Ray.
+func getSinks(ctx context.Context, config *cfg.Config, awsConfig aws.Config, parameterConfig *cfg.ParameterConfig) []processor.SinkConfig {
+	sinks := make([]processor.SinkConfig, 0, len(config.Exporters))
+	for _, e := range config.Exporters {
+		if sink, ok := getSink(ctx, config, awsConfig, parameterConfig, e); ok {
+			sinks = append(sinks, sink)
+		}
+	}
+	return sinks
+}
+
+// getRoutes returns the destinations segments are routed to, along with the router picking them,
+// nil if no routing rule is configured or in dry run, where every segment goes to the dry run output.
+func getRoutes(ctx context.Context, config *cfg.Config, awsConfig aws.Config, parameterConfig *cfg.ParameterConfig) ([]processor.SinkConfig, *routing.Router) {
+	if len(config.Routing.Rules) == 0 {
+		return nil, nil
+	}
+	if dryRun {
+		log.Info("Dry run: ignoring routing rules")
+		return nil, nil
+	}
+	routes := make([]processor.SinkConfig, 0, len(config.Routing.Destinations))
+	names := make([]string, 0, len(config.Routing.Destinations))
+	for _, e := range config.Routing.Destinations {
+		if e.Type == "" {
+			e.Type = "xray"
+		}
+		route, _ := getSink(ctx, config, awsConfig, parameterConfig, e)
+		route.Routed = true
+		routes = append(routes, route)
+		names = append(names, route.Name)
+	}
+	router, err := routing.New(config.Routing.Rules, names)
+	if err != nil {
+		log.Errorf("Unable to create segment router: %v", err)
+		os.Exit(1)
+	}
+	log.Infof("Using %v routing rule(s) to %v destination(s)", len(config.Routing.Rules), len(routes))
+	return routes, router
+}
+
+// getSink returns the sink exporting to e, false if e is skipped in dry run.
+// Exporters to AWS services use the region and role of the daemon unless configured otherwise.
+func getSink(ctx context.Context, config *cfg.Config, awsConfig aws.Config, parameterConfig *cfg.ParameterConfig, e cfg.ExporterConfig) (processor.SinkConfig, bool) {
+	if e.Name == "" {
+		e.Name = e.Type
+	}
+	var exp exporter.Exporter
+	var exportBreaker *breaker.Breaker
+	shareBudget := false
+	switch e.Type {
+	case "xray":
+		if dryRun {
+			log.Infof("Skipping X-Ray exporter %v in dry run", e.Name)
+			return processor.SinkConfig{}, false
+		}
+		c := getExporterAWSConfig(ctx, config, awsConfig, e)
+		if e.Endpoint != "" {
+			c.BaseEndpoint = aws.String(e.Endpoint)
+		}
+		exp = exporter.NewXRay(conn.NewXRay(c))
+		// Other X-Ray destinations are retried like the default one, within the same budget.
+		if e.MaxRetries == nil {
+			e.MaxRetries = &parameterConfig.Processor.MaxRetries
+		}
+		shareBudget = true
+	case "file":
+		f, err := exporter.NewFile(exporter.FileConfig{
+			Directory:      e.Directory,
+			MaxFileBytes:   int64(e.MaxFileSizeMB) * 1024 * 1024,
+			RotateInterval: time.Minute * time.Duration(e.RotateIntervalMinute),
+			Gzip:           e.Gzip,
+			MaxFiles:       e.MaxFiles,
+		})
+		if err != nil {
+			log.Errorf("Unable to create exporter %v: %v", e.Name, err)
+			os.Exit(1)
+		}
+		exp = f
+	case "otlp":
+		timeout := e.TimeoutSecond
+		if timeout == 0 {
+			timeout = parameterConfig.Processor.RequestTimeout
+		}
+		o, err := exporter.NewOTLP(exporter.OTLPConfig{
+			Endpoint: e.Endpoint,
+			Headers:  e.Headers,
+			Timeout:  time.Second * time.Duration(timeout),
+		})
+		if err != nil {
+			log.Errorf("Unable to create exporter %v: %v", e.Name, err)
+			os.Exit(1)
+		}
+		exp = o
+	case "cloudwatchlogs":
+		if dryRun {
+			log.Infof("Skipping CloudWatch Logs exporter %v in dry run", e.Name)
+			return processor.SinkConfig{}, false
+		}
+		c := getExporterAWSConfig(ctx, config, awsConfig, e)
+		stream := e.LogStream
+		if stream == "" {
+			stream, _ = os.Hostname()
+		}
+		l, err := exporter.NewCloudWatchLogs(exporter.CloudWatchLogsConfig{
+			LogGroup:    e.LogGroup,
+			LogStream:   stream,
+			Region:      c.Region,
+			Endpoint:    e.Endpoint,
+			Credentials: c.Credentials,
+			HTTPClient:  c.HTTPClient,
+		})
+		if err != nil {
+			log.Errorf("Unable to create exporter %v: %v", e.Name, err)
+			os.Exit(1)
+		}
+		exp = l
+	case "s3":
+		if dryRun {
+			log.Infof("Skipping S3 exporter %v in dry run", e.Name)
+			return processor.SinkConfig{}, false
+		}
+		c := getExporterAWSConfig(ctx, config, awsConfig, e)
+		size := e.MaxObjectSizeMB
+		if size == 0 {
+			size = defaultMaxObjectSizeMB
+		}
+		interval := e.FlushIntervalSecond
+		if interval == 0 {
+			interval = defaultFlushIntervalSecond
+		}
+		// Objects waiting to be uploaded are spooled next to the segments of the spool.
+		dir := e.Directory
+		if dir == "" && config.Spool.Directory != "" {
+			dir = filepath.Join(config.Spool.Directory, "s3-"+e.Name)
+		}
+		host, _ := os.Hostname()
+		s, err := exporter.NewS3(exporter.S3Config{
+			Bucket:         e.Bucket,
+			Prefix:         e.Prefix,
+			Name:           host,
+			Region:         c.Region,
+			Endpoint:       e.Endpoint,
+			MaxObjectBytes: int64(size) * 1024 * 1024,
+			FlushInterval:  time.Second * time.Duration(interval),
+			Timeout:        time.Second * time.Duration(e.TimeoutSecond),
+			SpoolDirectory: dir,
+			MaxSpoolBytes:  int64(config.Spool.SizeLimitMB) * 1024 * 1024,
+			Credentials:    c.Credentials,
+			HTTPClient:     c.HTTPClient,
+		})
+		if err != nil {
+			log.Errorf("Unable to create exporter %v: %v", e.Name, err)
+			os.Exit(1)
+		}
+		exp = s
+	case "webhook":
+		timeout := e.TimeoutSecond
+		if timeout == 0 {
+			timeout = parameterConfig.Processor.RequestTimeout
+		}
+		w, err := exporter.NewWebhook(exporter.WebhookConfig{
+			URL:         e.Endpoint,
+			Headers:     e.Headers,
+			BearerToken: e.BearerToken,
+			Username:    e.Username,
+			Password:    e.Password,
+			Gzip:        e.Gzip,
+			Timeout:     time.Second * time.Duration(timeout),
+		})
+		if err != nil {
+			log.Errorf("Unable to create exporter %v: %v", e.Name, err)
+			os.Exit(1)
+		}
+		exp = w
+		// Webhooks are retried like X-Ray uploads, within the same budget.
+		if e.MaxRetries == nil {
+			e.MaxRetries = &parameterConfig.Processor.MaxRetries
+		}
+		shareBudget = true
+		if *config.CircuitBreaker.Enabled {
+			exportBreaker = breaker.New("export."+e.Name, getBreakerConfig(config))
+		}
+	default:
+		log.Errorf("Unknown type %q of exporter %v, expected xray, file, otlp, cloudwatchlogs, s3 or webhook", e.Type, e.Name)
+		os.Exit(1)
+	}
+	queueSize := e.QueueSize
+	if queueSize == 0 {
+		queueSize = parameterConfig.Processor.BatchProcessorQueueSize
+	}
+	maxRetries := 0
+	if e.MaxRetries != nil {
+		maxRetries = *e.MaxRetries
+	}
+	return processor.SinkConfig{
+		Name:             e.Name,
+		Exporter:         exp,
+		QueueSize:        queueSize,
+		Concurrency:      e.Concurrency,
+		MaxRetries:       maxRetries,
+		ShareRetryBudget: shareBudget,
+		Breaker:          exportBreaker,
+	}, true
+}
+
+// getExporterAWSConfig returns the AWS config of exporter e, with the region and role of the daemon unless configured otherwise.
+func getExporterAWSConfig(ctx context.Context, config *cfg.Config, awsConfig aws.Config, e cfg.ExporterConfig) aws.Config {
+	region := e.Region
+	if region == "" {
+		region = awsConfig.Region
+	}
+	role := e.RoleARN
+	if role == "" {
+		role = roleArn
+	}
+	c, err := conn.GetAWSConfig(ctx, &conn.Conn{}, config, role, region, noMetadata)
+	if err != nil {
+		log.Errorf("Unable to get AWS config of exporter %v: %v", e.Name, err)
+		os.Exit(1)
+	}
+	return c
+}
+
+// getOverflowConfig returns the overflow policy for ring buffers and the batch queue. The spill policy
+// writes to the write-ahead spool wal, so spilled segments are bounded and replayed. A spill directory
+// other than that of wal is rejected.
+func getOverflowConfig(config *cfg.Config, wal *spool.Spool) overflow.Config {
+	policy, err := overflow.ParsePolicy(config.Overflow.Policy)
+	if err != nil {
+		log.Errorf("%v", err)
+		os.Exit(1)
+	}
+	o := overflow.Config{
+		Policy:       policy,
+		BlockTimeout: time.Millisecond * time.Duration(config.Overflow.BlockTimeoutMillisecond),
+	}
+	if policy != overflow.Spill {
+		return o
+	}
+	if wal == nil {
+		log.Error("Spill overflow policy requires a Spool directory")
+		os.Exit(1)
+	}
+	if config.Overflow.SpillDirectory != "" && filepath.Clean(config.Overflow.SpillDirectory) != filepath.Clean(config.Spool.Directory) {
+		log.Errorf("Spill directory %v must be the Spool directory %v, or empty, so spilled segments are replayed",
+			config.Overflow.SpillDirectory, config.Spool.Directory)
+		os.Exit(1)
+	}
+	o.Spiller = wal
+	return o
+}
+
+// nested returns true if directories a and b are the same, or one holds the other.
+func nested(a, b string) bool {
+	if abs, err := filepath.Abs(a); err == nil {
+		a = abs
+	}
+	if abs, err := filepath.Abs(b); err == nil {
+		b = abs
+	}
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	sep := string(filepath.Separator)
+	return a == b || strings.HasPrefix(a, strings.TrimSuffix(b, sep)+sep) || strings.HasPrefix(b, strings.TrimSuffix(a, sep)+sep)
+}
+
 func evaluateBufferMemory(cliBufferMemory int) int {
 	var bufferMemoryMB int
 	if cliBufferMemory > 0 {
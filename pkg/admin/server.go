@@ -0,0 +1,55 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+// Package admin provides an http server exposing the state of the daemon to operators.
+package admin
+
+import (
+	"net/http"
+
+	log "github.com/cihub/seelog"
+)
+
+// Server represents the admin HTTP server.
+type Server struct {
+	*http.Server
+	mux *http.ServeMux
+}
+
+// NewServer returns an admin server listening on the given address.
+func NewServer(address string) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		Server: &http.Server{
+			Addr:    address,
+			Handler: mux,
+		},
+		mux: mux,
+	}
+}
+
+// Handle registers handler for the given path.
+func (s *Server) Handle(path string, handler http.Handler) {
+	s.mux.Handle(path, handler)
+}
+
+// Serve starts server.
+func (s *Server) Serve() {
+	log.Infof("Starting admin http server on %s", s.Addr)
+	if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Errorf("admin http server failed to listen: %v", err)
+	}
+}
+
+// Close stops server.
+func (s *Server) Close() {
+	if err := s.Server.Close(); err != nil {
+		log.Errorf("unable to close the admin server: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+// Package breaker provides a circuit breaker stopping calls to a failing backend.
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	log "github.com/cihub/seelog"
+)
+
+// State of a circuit breaker.
+type State int
+
+const (
+	// Closed lets every call through.
+	Closed State = iota
+
+	// Open rejects every call until the open timeout elapses.
+	Open
+
+	// HalfOpen lets a single probe call through, which closes or opens the breaker again.
+	HalfOpen
+)
+
+var stateNames = map[State]string{
+	Closed:   "closed",
+	Open:     "open",
+	HalfOpen: "half-open",
+}
+
+// String returns the name of state s.
+func (s State) String() string {
+	return stateNames[s]
+}
+
+// Config describes when a circuit breaker opens and for how long.
+type Config struct {
+	// Number of consecutive failures opening the breaker, 0 to ignore.
+	ConsecutiveFailures int
+
+	// Percentage of failed calls in the window opening the breaker, 0 to ignore.
+	ErrorRatePercent int
+
+	// Minimum number of calls in the window before the error rate is evaluated.
+	MinRequests int
+
+	// Duration of the window the error rate is computed over.
+	Window time.Duration
+
+	// Time the breaker stays open before letting a probe call through.
+	OpenTimeout time.Duration
+}
+
+// Breaker is a circuit breaker, safe for concurrent use.
+type Breaker struct {
+	// Name used in logs and telemetry counters.
+	name string
+
+	config Config
+
+	lock sync.Mutex
+
+	state State
+
+	// Number of consecutive failed calls.
+	consecutive int
+
+	// Number of calls and failed calls in the current window.
+	requests int
+	failures int
+
+	// Start of the current window.
+	windowStart time.Time
+
+	// Time the breaker last opened.
+	openedAt time.Time
+
+	// Boolean, set to true while the half-open probe call is in flight.
+	probing bool
+
+	// Returns current time, replaced in tests.
+	now func() time.Time
+}
+
+// New returns a closed Breaker with the given name and config.
+func New(name string, c Config) *Breaker {
+	return &Breaker{
+		name:        name,
+		config:      c,
+		windowStart: time.Now(),
+		now:         time.Now,
+	}
+}
+
+// Allow returns true if a call may go through.
+func (b *Breaker) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.config.OpenTimeout {
+			return false
+		}
+		b.transition(HalfOpen)
+		b.probing = true
+		return true
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Success records a successful call.
+func (b *Breaker) Success() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.record(false)
+	b.consecutive = 0
+	if b.state == HalfOpen {
+		b.probing = false
+		b.transition(Closed)
+	}
+}
+
+// Failure records a failed call.
+func (b *Breaker) Failure() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.record(true)
+	b.consecutive++
+	switch b.state {
+	case HalfOpen:
+		b.probing = false
+		b.transition(Open)
+	case Closed:
+		if b.tripped() {
+			b.transition(Open)
+		}
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+// record counts a call in the current window. Must be called with the lock held.
+func (b *Breaker) record(failed bool) {
+	if now := b.now(); now.Sub(b.windowStart) >= b.config.Window {
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+}
+
+// tripped returns true if failures should open the breaker. Must be called with the lock held.
+func (b *Breaker) tripped() bool {
+	if b.config.ConsecutiveFailures > 0 && b.consecutive >= b.config.ConsecutiveFailures {
+		return true
+	}
+	return b.config.ErrorRatePercent > 0 && b.requests >= b.config.MinRequests &&
+		b.failures*100 >= b.requests*b.config.ErrorRatePercent
+}
+
+// transition moves the breaker to state s. Must be called with the lock held.
+func (b *Breaker) transition(s State) {
+	log.Infof("Circuit breaker %v is %v, was %v", b.name, s, b.state)
+	telemetry.T.Count(b.name+".breaker."+s.String(), 1)
+	b.state = s
+	switch s {
+	case Open:
+		b.openedAt = b.now()
+	case Closed:
+		b.consecutive = 0
+		b.windowStart = b.now()
+		b.requests = 0
+		b.failures = 0
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/aws/aws-xray-daemon/pkg/util/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	telemetry.T = telemetry.GetTestTelemetry()
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func getTestBreaker(c Config) (*Breaker, *testClock) {
+	test.LogSetup()
+	clock := &testClock{now: time.Unix(1500000000, 0)}
+	b := New("test", c)
+	b.now = clock.Now
+	b.windowStart = clock.now
+	return b, clock
+}
+
+func TestBreakerOpensOnConsecutiveFailures(t *testing.T) {
+	b, _ := getTestBreaker(Config{ConsecutiveFailures: 3, OpenTimeout: time.Second})
+
+	b.Failure()
+	b.Failure()
+	b.Success()
+	b.Failure()
+	b.Failure()
+	assert.Equal(t, Closed, b.State(), "A success resets consecutive failures")
+
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+	assert.False(t, b.Allow())
+}
+
+func TestBreakerOpensOnErrorRate(t *testing.T) {
+	b, _ := getTestBreaker(Config{ErrorRatePercent: 50, MinRequests: 4, Window: time.Minute})
+
+	b.Failure()
+	b.Success()
+	b.Failure()
+	assert.Equal(t, Closed, b.State(), "Error rate is not evaluated below minimum requests")
+
+	b.Success()
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+}
+
+func TestBreakerErrorRateWindow(t *testing.T) {
+	b, clock := getTestBreaker(Config{ErrorRatePercent: 50, MinRequests: 2, Window: time.Minute})
+
+	b.Failure()
+	clock.now = clock.now.Add(time.Minute)
+	b.Success()
+	b.Success()
+	b.Failure()
+
+	assert.Equal(t, Closed, b.State(), "Failures of previous windows are not counted")
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	b, clock := getTestBreaker(Config{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+	b.Failure()
+
+	clock.now = clock.now.Add(time.Second)
+	assert.True(t, b.Allow(), "A probe goes through once the open timeout elapsed")
+	assert.Equal(t, HalfOpen, b.State())
+	assert.False(t, b.Allow(), "A single probe goes through")
+
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+	assert.False(t, b.Allow())
+
+	clock.now = clock.now.Add(time.Second)
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, Closed, b.State())
+	assert.True(t, b.Allow())
+}
+
+func TestBreakerStateCounters(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	b, clock := getTestBreaker(Config{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+
+	b.Failure()
+	clock.now = clock.now.Add(time.Second)
+	b.Allow()
+	b.Success()
+
+	assert.EqualValues(t, 1, telemetry.T.Counter("test.breaker.open"))
+	assert.EqualValues(t, 1, telemetry.T.Counter("test.breaker.half-open"))
+	assert.EqualValues(t, 1, telemetry.T.Counter("test.breaker.closed"))
+}
@@ -79,6 +79,14 @@ type Config struct {
 	// Local mode to skip EC2 instance metadata check.
 	LocalMode *bool `yaml:"LocalMode"`
 
+	// Process segments without uploading them to X-Ray, needing no credentials or region.
+	DryRun struct {
+		// Enabled, if true, never calls X-Ray, the TCP proxy is not started.
+		Enabled *bool `yaml:"Enabled"`
+		// Output of batches: stdout prints their segments, count only counts them.
+		Output string `yaml:"Output"`
+	} `yaml:"DryRun"`
+
 	// Amazon Resource Name (ARN) of the AWS resource running the daemon.
 	ResourceARN string `yaml:"ResourceARN"`
 
@@ -91,10 +99,261 @@ type Config struct {
 	// Upload segments to AWS X-Ray through a proxy.
 	ProxyAddress string `yaml:"ProxyAddress"`
 
+	// Rules to drop segments before they are batched.
+	Filter struct {
+		// DryRun, if true, only counts segments matching the rules without dropping them.
+		DryRun *bool `yaml:"DryRun"`
+		// Segments matching any of the rules are dropped.
+		Rules []FilterRule `yaml:"Rules"`
+	} `yaml:"Filter"`
+
+	// Destinations every batch is sent to in addition to AWS X-Ray, each with its own queue and retries.
+	// Segments routed to another destination are not sent to them.
+	Exporters []ExporterConfig `yaml:"Exporters"`
+
+	// Behavior when the segment buffer or the batch queue is full.
+	Overflow struct {
+		// Policy applied when full: drop-oldest (default), drop-newest, block or spill.
+		Policy string `yaml:"Policy"`
+		// Maximum time in milliseconds the block policy waits for room before dropping segments.
+		BlockTimeoutMillisecond int `yaml:"BlockTimeoutMillisecond"`
+		// Directory where the spill policy writes segments that do not fit in memory, which must be the Spool
+		// directory so they are replayed. The Spool directory if empty.
+		SpillDirectory string `yaml:"SpillDirectory"`
+	} `yaml:"Overflow"`
+
+	// Write-ahead spool of segments which cannot be delivered, replayed once X-Ray is reachable.
+	Spool struct {
+		// Directory holding spooled segments. Empty disables the spool.
+		Directory string `yaml:"Directory"`
+		// Maximum size in MB of spooled segments, the oldest being removed to make room.
+		SizeLimitMB int `yaml:"SizeLimitMB"`
+		// Age in minutes after which spooled segments are removed without being replayed.
+		TTLMinute int `yaml:"TTLMinute"`
+	} `yaml:"Spool"`
+
+	// Number of uploads in flight adapting to throttling and latency, starting at Concurrency.
+	AdaptiveConcurrency struct {
+		// Enabled, if true, increases uploads in flight while latency is healthy, and cuts them back
+		// on throttling, 5xx responses or timeouts.
+		Enabled *bool `yaml:"Enabled"`
+		// Bounds of the number of uploads in flight.
+		Min int `yaml:"Min"`
+		Max int `yaml:"Max"`
+		// Latency in milliseconds below which successful uploads increase the number of uploads in flight.
+		LatencyThresholdMillisecond int `yaml:"LatencyThresholdMillisecond"`
+	} `yaml:"AdaptiveConcurrency"`
+
+	// Client-side limits of the uploads to X-Ray, shared by every upload of the daemon.
+	RateLimit struct {
+		// Maximum number of upload requests per second, 0 for no limit.
+		RequestsPerSecond int `yaml:"RequestsPerSecond"`
+		// Maximum number of segments uploaded per second, 0 for no limit.
+		SegmentsPerSecond int `yaml:"SegmentsPerSecond"`
+	} `yaml:"RateLimit"`
+
+	// Conditions sending a batch of segments to X-Ray, whichever is met first.
+	Batching struct {
+		// Maximum number of segments in a batch, at most 50.
+		MaxCount int `yaml:"MaxCount"`
+		// Maximum size in bytes of the upload request of a batch, at most 5 MB.
+		MaxBytes int `yaml:"MaxBytes"`
+		// Maximum time in milliseconds a segment waits in a batch before it is sent.
+		MaxAgeMillisecond int `yaml:"MaxAgeMillisecond"`
+		// TraceAffinity, if true, holds segments until MaxAgeMillisecond and sends those of the same trace in the same batch.
+		TraceAffinity *bool `yaml:"TraceAffinity"`
+	} `yaml:"Batching"`
+
+	// Circuit breaker stopping uploads to X-Ray while it keeps failing.
+	CircuitBreaker struct {
+		// Enabled, if true, stops uploads once failures reach the thresholds below.
+		Enabled *bool `yaml:"Enabled"`
+		// Number of consecutive failed uploads opening the breaker.
+		ConsecutiveFailures int `yaml:"ConsecutiveFailures"`
+		// Percentage of failed uploads in the window opening the breaker.
+		ErrorRatePercent int `yaml:"ErrorRatePercent"`
+		// Minimum number of uploads in the window before the error rate is evaluated.
+		MinRequests int `yaml:"MinRequests"`
+		// Duration in seconds of the window the error rate is computed over.
+		WindowSecond int `yaml:"WindowSecond"`
+		// Time in seconds the breaker stays open before probing X-Ray again.
+		OpenTimeoutSecond int `yaml:"OpenTimeoutSecond"`
+		// What happens to batches while the breaker is open: drop (default) or spool.
+		Fallback string `yaml:"Fallback"`
+	} `yaml:"CircuitBreaker"`
+
+	// Segment documents dropped or rejected by the daemon, recorded with the reason.
+	DeadLetter struct {
+		// Directory where dead-lettered segment documents are written. Empty disables the dead-letter store.
+		Directory string `yaml:"Directory"`
+		// Maximum size in MB of dead-letter files, the oldest being removed to make room.
+		SizeLimitMB int `yaml:"SizeLimitMB"`
+	} `yaml:"DeadLetter"`
+
+	// HTTP server exposing the state of the daemon to operators.
+	Admin struct {
+		// Address and port the admin server listens on. Empty disables the admin server.
+		Address string `yaml:"Address"`
+	} `yaml:"Admin"`
+
+	// Per-service queues sharing the segment buffer.
+	FairQueue struct {
+		// Enabled, if true, queues segments per service and drains them by weighted round-robin.
+		Enabled *bool `yaml:"Enabled"`
+		// Key identifying the service of a segment: name (default) or tenant, read from the segment header.
+		Key string `yaml:"Key"`
+		// Percentage of the buffer guaranteed to every service with queued segments.
+		MinSharePercent int `yaml:"MinSharePercent"`
+		// Number of segments a service sends in its round-robin turn, services not listed send one.
+		Weights map[string]int `yaml:"Weights"`
+	} `yaml:"FairQueue"`
+
+	// Forwarding of batches to gateway daemons, which upload them to X-Ray, so this daemon needs no AWS credentials.
+	Forward struct {
+		// Base URLs of the gateways. Empty uploads batches to X-Ray.
+		Gateways []string `yaml:"Gateways"`
+		// Token sent to the gateways as bearer authorization.
+		Token string `yaml:"Token"`
+		// ConsistentHashing, if true, sends all segments of a trace to the same gateway, chosen by trace id.
+		ConsistentHashing *bool `yaml:"ConsistentHashing"`
+		// Timeout in seconds of the requests to the gateways, that of X-Ray uploads if 0.
+		TimeoutSecond int `yaml:"TimeoutSecond"`
+	} `yaml:"Forward"`
+
+	// HTTP server receiving batches forwarded by other daemons, and the requests of their TCP proxy.
+	Gateway struct {
+		// Address and port the gateway server listens on. Empty disables the gateway server.
+		Address string `yaml:"Address"`
+		// Token forwarding daemons must send as bearer authorization.
+		Token string `yaml:"Token"`
+		// Certificate and key files of TLS, plain HTTP if empty.
+		TLSCertFile string `yaml:"TLSCertFile"`
+		TLSKeyFile  string `yaml:"TLSKeyFile"`
+		// Maximum size in MB of a forwarded batch, after decompression.
+		MaxRequestSizeMB int `yaml:"MaxRequestSizeMB"`
+	} `yaml:"Gateway"`
+
+	// Rules sending segments to destinations other than the default X-Ray destination of the daemon.
+	Routing struct {
+		// Rules evaluated in order, the first matching rule gives the destination of a segment.
+		// Segments matching no rule are sent to the default destination.
+		Rules []RoutingRule `yaml:"Rules"`
+		// Destinations segments are routed to, each with its own queue and retries. Type is xray if empty.
+		Destinations []ExporterConfig `yaml:"Destinations"`
+	} `yaml:"Routing"`
+
+	// Secondary X-Ray endpoints uploads and proxied requests switch to while the primary endpoint fails.
+	Failover struct {
+		// Secondary regions or endpoints, in the order they are failed over to. Empty disables failover.
+		Endpoints []FailoverEndpoint `yaml:"Endpoints"`
+		// Time in seconds the active endpoint fails continuously before switching to the next one.
+		FailoverAfterSecond int `yaml:"FailoverAfterSecond"`
+		// Interval in seconds between uploads sent to the primary endpoint as probes while failed over.
+		ProbeIntervalSecond int `yaml:"ProbeIntervalSecond"`
+	} `yaml:"Failover"`
+
+	// Coalescing of the in-progress and completed versions of long-running segments into one upload.
+	Coalescing struct {
+		// Enabled, if true, holds in-progress segments for a window, sending only the completed version if it arrives in time.
+		Enabled *bool `yaml:"Enabled"`
+		// Time in milliseconds an in-progress segment is held before it is sent.
+		WindowMillisecond int `yaml:"WindowMillisecond"`
+		// Time in seconds after which a segment still in progress is closed with a fault, also closed at shutdown.
+		TTLSecond int `yaml:"TTLSecond"`
+	} `yaml:"Coalescing"`
+
+	// Behavior on SIGINT or SIGTERM.
+	Shutdown struct {
+		// Time in seconds queued segments are sent for before uploads are cancelled and segments left are spooled or dropped.
+		DrainTimeoutSecond int `yaml:"DrainTimeoutSecond"`
+	} `yaml:"Shutdown"`
+
 	// Daemon configuration file format version.
 	Version int `yaml:"Version"`
 }
 
+// FilterRule matches a field of a segment document against a pattern.
+type FilterRule struct {
+	// Field of the segment document: name, origin, http.request.url, http.request.method or annotations.<key>.
+	Field string `yaml:"Field"`
+	// Pattern to match the field value against.
+	Pattern string `yaml:"Pattern"`
+	// Syntax of the pattern: glob (default) or regex.
+	Match string `yaml:"Match"`
+}
+
+// RoutingRule sends segments whose field matches a pattern to a destination.
+type RoutingRule struct {
+	// Field of the segment document, as for filter rules, or tenant, read from the segment header,
+	// or source, the IP address the segment was received from.
+	Field string `yaml:"Field"`
+	// Pattern to match the field value against.
+	Pattern string `yaml:"Pattern"`
+	// Syntax of the pattern: glob (default) or regex.
+	Match string `yaml:"Match"`
+	// Name of the destination matching segments are sent to.
+	Destination string `yaml:"Destination"`
+}
+
+// FailoverEndpoint is a secondary X-Ray endpoint.
+type FailoverEndpoint struct {
+	// Region of the endpoint, that of the daemon if empty.
+	Region string `yaml:"Region"`
+	// URL of the endpoint, the X-Ray endpoint of Region if empty.
+	Endpoint string `yaml:"Endpoint"`
+}
+
+// ExporterConfig describes a destination segment batches are exported to.
+type ExporterConfig struct {
+	// Name of the exporter in logs and telemetry, its type if empty.
+	Name string `yaml:"Name"`
+	// Type of the exporter: xray, file, otlp, cloudwatchlogs, s3 or webhook.
+	Type string `yaml:"Type"`
+	// Region, role and endpoint of the xray, cloudwatchlogs and s3 exporters, those of the daemon if empty.
+	// Endpoint is the URL of the OTLP/HTTP traces endpoint of the otlp exporter, and the URL the webhook exporter posts to.
+	Region   string `yaml:"Region"`
+	RoleARN  string `yaml:"RoleARN"`
+	Endpoint string `yaml:"Endpoint"`
+	// Log group the cloudwatchlogs exporter writes span records to, which must exist.
+	LogGroup string `yaml:"LogGroup"`
+	// Log stream the cloudwatchlogs exporter writes span records to, the host name if empty.
+	LogStream string `yaml:"LogStream"`
+	// Bucket and key prefix of the objects uploaded by the s3 exporter.
+	Bucket string `yaml:"Bucket"`
+	Prefix string `yaml:"Prefix"`
+	// Size in MB of the segments of an object after which the s3 exporter uploads it, 64 if 0.
+	MaxObjectSizeMB int `yaml:"MaxObjectSizeMB"`
+	// Age in seconds of an object after which the s3 exporter uploads it, 300 if 0.
+	FlushIntervalSecond int `yaml:"FlushIntervalSecond"`
+	// Headers added to the requests of the otlp and webhook exporters.
+	Headers map[string]string `yaml:"Headers"`
+	// Bearer token, or user name and password, authorizing the requests of the webhook exporter.
+	BearerToken string `yaml:"BearerToken"`
+	Username    string `yaml:"Username"`
+	Password    string `yaml:"Password"`
+	// Timeout in seconds of the requests of the otlp and webhook exporters, that of X-Ray uploads if 0,
+	// and of the uploads of the s3 exporter, 60 if 0.
+	TimeoutSecond int `yaml:"TimeoutSecond"`
+	// Directory the file exporter writes newline delimited JSON files to, and the s3 exporter writes objects
+	// waiting to be uploaded to, a directory of the spool if empty.
+	Directory string `yaml:"Directory"`
+	// Size in MB after which the file exporter starts a new file, 0 for no limit.
+	MaxFileSizeMB int `yaml:"MaxFileSizeMB"`
+	// Age in minutes after which the file exporter starts a new file, 0 for no limit.
+	RotateIntervalMinute int `yaml:"RotateIntervalMinute"`
+	// Gzip, if true, compresses the files of the file exporter, or the requests of the webhook exporter.
+	Gzip bool `yaml:"Gzip"`
+	// Number of files kept by the file exporter, the oldest being removed first, 0 keeps every file.
+	MaxFiles int `yaml:"MaxFiles"`
+	// Number of batches queued for the exporter, the oldest batch is dropped when full.
+	QueueSize int `yaml:"QueueSize"`
+	// Number of batches exported concurrently.
+	Concurrency int `yaml:"Concurrency"`
+	// Maximum number of retries of a failed batch, 0 disables retries. X-Ray and webhook exporters
+	// default to the MaxRetries of the Processor, other exporters to 0.
+	MaxRetries *int `yaml:"MaxRetries"`
+}
+
 // DefaultConfig returns default configuration for X-Ray daemon.
 func DefaultConfig() *Config {
 	return &Config{
@@ -133,10 +392,172 @@ func DefaultConfig() *Config {
 		RoleARN:      "",
 		NoVerifySSL:  util.Bool(false),
 		ProxyAddress: "",
-		Version:      1,
+		DryRun: struct {
+			Enabled *bool  `yaml:"Enabled"`
+			Output  string `yaml:"Output"`
+		}{
+			Enabled: util.Bool(false),
+			Output:  "stdout",
+		},
+		Filter: struct {
+			DryRun *bool        `yaml:"DryRun"`
+			Rules  []FilterRule `yaml:"Rules"`
+		}{
+			DryRun: util.Bool(false),
+			Rules:  []FilterRule{},
+		},
+		Exporters: []ExporterConfig{},
+		Overflow: struct {
+			Policy                  string `yaml:"Policy"`
+			BlockTimeoutMillisecond int    `yaml:"BlockTimeoutMillisecond"`
+			SpillDirectory          string `yaml:"SpillDirectory"`
+		}{
+			Policy:                  "drop-oldest",
+			BlockTimeoutMillisecond: 100,
+			SpillDirectory:          "",
+		},
+		Spool: struct {
+			Directory   string `yaml:"Directory"`
+			SizeLimitMB int    `yaml:"SizeLimitMB"`
+			TTLMinute   int    `yaml:"TTLMinute"`
+		}{
+			Directory:   "",
+			SizeLimitMB: 100,
+			TTLMinute:   60,
+		},
+		AdaptiveConcurrency: struct {
+			Enabled                     *bool `yaml:"Enabled"`
+			Min                         int   `yaml:"Min"`
+			Max                         int   `yaml:"Max"`
+			LatencyThresholdMillisecond int   `yaml:"LatencyThresholdMillisecond"`
+		}{
+			Enabled:                     util.Bool(false),
+			Min:                         1,
+			Max:                         32,
+			LatencyThresholdMillisecond: 1000,
+		},
+		RateLimit: struct {
+			RequestsPerSecond int `yaml:"RequestsPerSecond"`
+			SegmentsPerSecond int `yaml:"SegmentsPerSecond"`
+		}{
+			RequestsPerSecond: 0,
+			SegmentsPerSecond: 0,
+		},
+		Batching: struct {
+			MaxCount          int   `yaml:"MaxCount"`
+			MaxBytes          int   `yaml:"MaxBytes"`
+			MaxAgeMillisecond int   `yaml:"MaxAgeMillisecond"`
+			TraceAffinity     *bool `yaml:"TraceAffinity"`
+		}{
+			MaxCount:          MaxBatchCount,
+			MaxBytes:          MaxBatchBytes,
+			MaxAgeMillisecond: 1000,
+			TraceAffinity:     util.Bool(false),
+		},
+		CircuitBreaker: struct {
+			Enabled             *bool  `yaml:"Enabled"`
+			ConsecutiveFailures int    `yaml:"ConsecutiveFailures"`
+			ErrorRatePercent    int    `yaml:"ErrorRatePercent"`
+			MinRequests         int    `yaml:"MinRequests"`
+			WindowSecond        int    `yaml:"WindowSecond"`
+			OpenTimeoutSecond   int    `yaml:"OpenTimeoutSecond"`
+			Fallback            string `yaml:"Fallback"`
+		}{
+			Enabled:             util.Bool(false),
+			ConsecutiveFailures: 5,
+			ErrorRatePercent:    50,
+			MinRequests:         20,
+			WindowSecond:        60,
+			OpenTimeoutSecond:   30,
+			Fallback:            "drop",
+		},
+		DeadLetter: struct {
+			Directory   string `yaml:"Directory"`
+			SizeLimitMB int    `yaml:"SizeLimitMB"`
+		}{
+			Directory:   "",
+			SizeLimitMB: 10,
+		},
+		Admin: struct {
+			Address string `yaml:"Address"`
+		}{
+			Address: "",
+		},
+		FairQueue: struct {
+			Enabled         *bool          `yaml:"Enabled"`
+			Key             string         `yaml:"Key"`
+			MinSharePercent int            `yaml:"MinSharePercent"`
+			Weights         map[string]int `yaml:"Weights"`
+		}{
+			Enabled:         util.Bool(false),
+			Key:             "name",
+			MinSharePercent: 10,
+			Weights:         map[string]int{},
+		},
+		Forward: struct {
+			Gateways          []string `yaml:"Gateways"`
+			Token             string   `yaml:"Token"`
+			ConsistentHashing *bool    `yaml:"ConsistentHashing"`
+			TimeoutSecond     int      `yaml:"TimeoutSecond"`
+		}{
+			Gateways:          []string{},
+			Token:             "",
+			ConsistentHashing: util.Bool(false),
+			TimeoutSecond:     0,
+		},
+		Gateway: struct {
+			Address          string `yaml:"Address"`
+			Token            string `yaml:"Token"`
+			TLSCertFile      string `yaml:"TLSCertFile"`
+			TLSKeyFile       string `yaml:"TLSKeyFile"`
+			MaxRequestSizeMB int    `yaml:"MaxRequestSizeMB"`
+		}{
+			Address:          "",
+			Token:            "",
+			TLSCertFile:      "",
+			TLSKeyFile:       "",
+			MaxRequestSizeMB: 16,
+		},
+		Routing: struct {
+			Rules        []RoutingRule    `yaml:"Rules"`
+			Destinations []ExporterConfig `yaml:"Destinations"`
+		}{
+			Rules:        []RoutingRule{},
+			Destinations: []ExporterConfig{},
+		},
+		Failover: struct {
+			Endpoints           []FailoverEndpoint `yaml:"Endpoints"`
+			FailoverAfterSecond int                `yaml:"FailoverAfterSecond"`
+			ProbeIntervalSecond int                `yaml:"ProbeIntervalSecond"`
+		}{
+			Endpoints:           []FailoverEndpoint{},
+			FailoverAfterSecond: 60,
+			ProbeIntervalSecond: 30,
+		},
+		Coalescing: struct {
+			Enabled           *bool `yaml:"Enabled"`
+			WindowMillisecond int   `yaml:"WindowMillisecond"`
+			TTLSecond         int   `yaml:"TTLSecond"`
+		}{
+			Enabled:           util.Bool(false),
+			WindowMillisecond: 5000,
+			TTLSecond:         300,
+		},
+		Shutdown: struct {
+			DrainTimeoutSecond int `yaml:"DrainTimeoutSecond"`
+		}{
+			DrainTimeoutSecond: 20,
+		},
+		Version: 1,
 	}
 }
 
+// MaxBatchBytes is the size limit in bytes of a PutTraceSegments request.
+const MaxBatchBytes = 5 * 1024 * 1024
+
+// MaxBatchCount is the maximum number of segment documents in a PutTraceSegments request.
+const MaxBatchCount = 50
+
 // ParameterConfig is a configuration used by daemon.
 type ParameterConfig struct {
 	SegmentChannel struct {
@@ -166,6 +587,49 @@ type ParameterConfig struct {
 		// Used to set Http client timeout in seconds.
 		RequestTimeout          int
 		BatchProcessorQueueSize int
+
+		// Maximum number of retries of a batch failed with a retryable error.
+		MaxRetries int
+
+		// Upper bound in milliseconds of the first retry backoff, doubled on every retry.
+		RetryBaseDelayMillisecond int
+
+		// Upper bound in milliseconds of any retry backoff.
+		RetryMaxDelayMillisecond int
+
+		// Retries allowed per hundred successful requests.
+		RetryBudgetPercent int
+
+		// Interval in seconds between attempts to replay spooled segments.
+		SpoolReplayIntervalSecond int
+
+		// Maximum size in bytes of the PutTraceSegments request of a batch.
+		BatchMaxBytes int
+
+		// Maximum time in milliseconds the first segment of a batch waits before the batch is sent.
+		BatchMaxAgeMillisecond int
+
+		// Bounds of the adaptive number of uploads in flight, MaxConcurrency 0 disables adaptation.
+		MinConcurrency int
+		MaxConcurrency int
+
+		// Latency in milliseconds below which successful uploads increase the number of uploads in flight.
+		LatencyThresholdMillisecond int
+
+		// Maximum upload requests and segments per second, 0 for no limit.
+		RequestsPerSecond int
+		SegmentsPerSecond int
+
+		// Sends segments of the same trace held in the batching window in the same batch.
+		TraceAffinity bool
+
+		// Time in milliseconds in-progress segments are held, 0 disables coalescing, and time in seconds
+		// after which segments still in progress are closed.
+		CoalesceWindowMillisecond int
+		CoalesceTTLSecond         int
+
+		// Maximum size in bytes of the in-progress segments held.
+		CoalesceMaxBytes int
 	}
 }
 
@@ -183,17 +647,40 @@ var ParameterConfigValue = &ParameterConfig{
 	},
 	ReceiverRoutines: 2,
 	Processor: struct {
-		BatchSize               int
-		IdleTimeoutMillisecond  int
-		MaxIdleConnPerHost      int
-		RequestTimeout          int
-		BatchProcessorQueueSize int
+		BatchSize                   int
+		IdleTimeoutMillisecond      int
+		MaxIdleConnPerHost          int
+		RequestTimeout              int
+		BatchProcessorQueueSize     int
+		MaxRetries                  int
+		RetryBaseDelayMillisecond   int
+		RetryMaxDelayMillisecond    int
+		RetryBudgetPercent          int
+		SpoolReplayIntervalSecond   int
+		BatchMaxBytes               int
+		BatchMaxAgeMillisecond      int
+		MinConcurrency              int
+		MaxConcurrency              int
+		LatencyThresholdMillisecond int
+		RequestsPerSecond           int
+		SegmentsPerSecond           int
+		TraceAffinity               bool
+		CoalesceWindowMillisecond   int
+		CoalesceTTLSecond           int
+		CoalesceMaxBytes            int
 	}{
-		BatchSize:               50,
-		IdleTimeoutMillisecond:  1000,
-		MaxIdleConnPerHost:      8,
-		RequestTimeout:          2,
-		BatchProcessorQueueSize: 20,
+		BatchSize:                 50,
+		IdleTimeoutMillisecond:    1000,
+		MaxIdleConnPerHost:        8,
+		RequestTimeout:            2,
+		BatchProcessorQueueSize:   20,
+		MaxRetries:                3,
+		RetryBaseDelayMillisecond: 100,
+		RetryMaxDelayMillisecond:  2000,
+		RetryBudgetPercent:        10,
+		SpoolReplayIntervalSecond: 30,
+		BatchMaxBytes:             MaxBatchBytes,
+		BatchMaxAgeMillisecond:    1000,
 	},
 }
 
@@ -371,7 +858,53 @@ func merge(configFile string) *Config {
 	userConfig.Logging.LogPath = getStringValue(userConfig.Logging.LogPath, DefaultConfig().Logging.LogPath)
 	userConfig.NoVerifySSL = getBoolValue(userConfig.NoVerifySSL, DefaultConfig().NoVerifySSL)
 	userConfig.LocalMode = getBoolValue(userConfig.LocalMode, DefaultConfig().LocalMode)
+	userConfig.DryRun.Enabled = getBoolValue(userConfig.DryRun.Enabled, DefaultConfig().DryRun.Enabled)
+	userConfig.DryRun.Output = getStringValue(userConfig.DryRun.Output, DefaultConfig().DryRun.Output)
 	userConfig.ProxyAddress = getStringValue(userConfig.ProxyAddress, DefaultConfig().ProxyAddress)
+	userConfig.Filter.DryRun = getBoolValue(userConfig.Filter.DryRun, DefaultConfig().Filter.DryRun)
+	userConfig.Overflow.Policy = getStringValue(userConfig.Overflow.Policy, DefaultConfig().Overflow.Policy)
+	userConfig.Overflow.BlockTimeoutMillisecond = getIntValue(userConfig.Overflow.BlockTimeoutMillisecond, DefaultConfig().Overflow.BlockTimeoutMillisecond)
+	userConfig.Overflow.SpillDirectory = getStringValue(userConfig.Overflow.SpillDirectory, DefaultConfig().Overflow.SpillDirectory)
+	userConfig.Spool.Directory = getStringValue(userConfig.Spool.Directory, DefaultConfig().Spool.Directory)
+	userConfig.Spool.SizeLimitMB = getIntValue(userConfig.Spool.SizeLimitMB, DefaultConfig().Spool.SizeLimitMB)
+	userConfig.Spool.TTLMinute = getIntValue(userConfig.Spool.TTLMinute, DefaultConfig().Spool.TTLMinute)
+	userConfig.AdaptiveConcurrency.Enabled = getBoolValue(userConfig.AdaptiveConcurrency.Enabled, DefaultConfig().AdaptiveConcurrency.Enabled)
+	userConfig.AdaptiveConcurrency.Min = getIntValue(userConfig.AdaptiveConcurrency.Min, DefaultConfig().AdaptiveConcurrency.Min)
+	userConfig.AdaptiveConcurrency.Max = getIntValue(userConfig.AdaptiveConcurrency.Max, DefaultConfig().AdaptiveConcurrency.Max)
+	userConfig.AdaptiveConcurrency.LatencyThresholdMillisecond = getIntValue(userConfig.AdaptiveConcurrency.LatencyThresholdMillisecond, DefaultConfig().AdaptiveConcurrency.LatencyThresholdMillisecond)
+	userConfig.RateLimit.RequestsPerSecond = getIntValue(userConfig.RateLimit.RequestsPerSecond, DefaultConfig().RateLimit.RequestsPerSecond)
+	userConfig.RateLimit.SegmentsPerSecond = getIntValue(userConfig.RateLimit.SegmentsPerSecond, DefaultConfig().RateLimit.SegmentsPerSecond)
+	userConfig.Batching.MaxCount = getIntValue(userConfig.Batching.MaxCount, DefaultConfig().Batching.MaxCount)
+	userConfig.Batching.MaxBytes = getIntValue(userConfig.Batching.MaxBytes, DefaultConfig().Batching.MaxBytes)
+	userConfig.Batching.MaxAgeMillisecond = getIntValue(userConfig.Batching.MaxAgeMillisecond, DefaultConfig().Batching.MaxAgeMillisecond)
+	userConfig.Batching.TraceAffinity = getBoolValue(userConfig.Batching.TraceAffinity, DefaultConfig().Batching.TraceAffinity)
+	userConfig.CircuitBreaker.Enabled = getBoolValue(userConfig.CircuitBreaker.Enabled, DefaultConfig().CircuitBreaker.Enabled)
+	userConfig.CircuitBreaker.ConsecutiveFailures = getIntValue(userConfig.CircuitBreaker.ConsecutiveFailures, DefaultConfig().CircuitBreaker.ConsecutiveFailures)
+	userConfig.CircuitBreaker.ErrorRatePercent = getIntValue(userConfig.CircuitBreaker.ErrorRatePercent, DefaultConfig().CircuitBreaker.ErrorRatePercent)
+	userConfig.CircuitBreaker.MinRequests = getIntValue(userConfig.CircuitBreaker.MinRequests, DefaultConfig().CircuitBreaker.MinRequests)
+	userConfig.CircuitBreaker.WindowSecond = getIntValue(userConfig.CircuitBreaker.WindowSecond, DefaultConfig().CircuitBreaker.WindowSecond)
+	userConfig.CircuitBreaker.OpenTimeoutSecond = getIntValue(userConfig.CircuitBreaker.OpenTimeoutSecond, DefaultConfig().CircuitBreaker.OpenTimeoutSecond)
+	userConfig.CircuitBreaker.Fallback = getStringValue(userConfig.CircuitBreaker.Fallback, DefaultConfig().CircuitBreaker.Fallback)
+	userConfig.DeadLetter.Directory = getStringValue(userConfig.DeadLetter.Directory, DefaultConfig().DeadLetter.Directory)
+	userConfig.DeadLetter.SizeLimitMB = getIntValue(userConfig.DeadLetter.SizeLimitMB, DefaultConfig().DeadLetter.SizeLimitMB)
+	userConfig.Admin.Address = getStringValue(userConfig.Admin.Address, DefaultConfig().Admin.Address)
+	userConfig.FairQueue.Enabled = getBoolValue(userConfig.FairQueue.Enabled, DefaultConfig().FairQueue.Enabled)
+	userConfig.FairQueue.Key = getStringValue(userConfig.FairQueue.Key, DefaultConfig().FairQueue.Key)
+	userConfig.FairQueue.MinSharePercent = getIntValue(userConfig.FairQueue.MinSharePercent, DefaultConfig().FairQueue.MinSharePercent)
+	userConfig.Forward.Token = getStringValue(userConfig.Forward.Token, DefaultConfig().Forward.Token)
+	userConfig.Forward.ConsistentHashing = getBoolValue(userConfig.Forward.ConsistentHashing, DefaultConfig().Forward.ConsistentHashing)
+	userConfig.Forward.TimeoutSecond = getIntValue(userConfig.Forward.TimeoutSecond, DefaultConfig().Forward.TimeoutSecond)
+	userConfig.Gateway.Address = getStringValue(userConfig.Gateway.Address, DefaultConfig().Gateway.Address)
+	userConfig.Gateway.Token = getStringValue(userConfig.Gateway.Token, DefaultConfig().Gateway.Token)
+	userConfig.Gateway.TLSCertFile = getStringValue(userConfig.Gateway.TLSCertFile, DefaultConfig().Gateway.TLSCertFile)
+	userConfig.Gateway.TLSKeyFile = getStringValue(userConfig.Gateway.TLSKeyFile, DefaultConfig().Gateway.TLSKeyFile)
+	userConfig.Gateway.MaxRequestSizeMB = getIntValue(userConfig.Gateway.MaxRequestSizeMB, DefaultConfig().Gateway.MaxRequestSizeMB)
+	userConfig.Failover.FailoverAfterSecond = getIntValue(userConfig.Failover.FailoverAfterSecond, DefaultConfig().Failover.FailoverAfterSecond)
+	userConfig.Failover.ProbeIntervalSecond = getIntValue(userConfig.Failover.ProbeIntervalSecond, DefaultConfig().Failover.ProbeIntervalSecond)
+	userConfig.Coalescing.Enabled = getBoolValue(userConfig.Coalescing.Enabled, DefaultConfig().Coalescing.Enabled)
+	userConfig.Coalescing.WindowMillisecond = getIntValue(userConfig.Coalescing.WindowMillisecond, DefaultConfig().Coalescing.WindowMillisecond)
+	userConfig.Coalescing.TTLSecond = getIntValue(userConfig.Coalescing.TTLSecond, DefaultConfig().Coalescing.TTLSecond)
+	userConfig.Shutdown.DrainTimeoutSecond = getIntValue(userConfig.Shutdown.DrainTimeoutSecond, DefaultConfig().Shutdown.DrainTimeoutSecond)
 	return userConfig
 }
 
@@ -605,8 +605,361 @@ Version: 2`
 	tearTestCase()
 }
 
+func TestLoadConfigDryRun(t *testing.T) {
+	configString :=
+		`DryRun:
+  Enabled: true
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.True(t, *c.DryRun.Enabled)
+	assert.EqualValues(t, "stdout", c.DryRun.Output)
+	clearTestFile()
+}
+
+func TestLoadConfigFilter(t *testing.T) {
+	configString :=
+		`Filter:
+  DryRun: true
+  Rules:
+    - Field: "http.request.url"
+      Pattern: "*/health"
+    - Field: "name"
+      Pattern: "^internal-.*"
+      Match: "regex"
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.True(t, *c.Filter.DryRun)
+	assert.EqualValues(t, []FilterRule{
+		{Field: "http.request.url", Pattern: "*/health"},
+		{Field: "name", Pattern: "^internal-.*", Match: "regex"},
+	}, c.Filter.Rules)
+	clearTestFile()
+}
+
+func TestLoadConfigFilterDefault(t *testing.T) {
+	configString := `Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.False(t, *c.Filter.DryRun)
+	assert.Empty(t, c.Filter.Rules)
+	clearTestFile()
+}
+
+func TestLoadConfigExporters(t *testing.T) {
+	configString :=
+		`Exporters:
+  - Name: "mirror"
+    Type: "xray"
+    Region: "us-west-2"
+    RoleARN: "arn:aws:iam::123456789012:role/xray-mirror"
+    MaxRetries: 3
+  - Type: "file"
+    Directory: "/var/lib/xray/traces"
+    MaxFileSizeMB: 100
+    RotateIntervalMinute: 60
+    Gzip: true
+    MaxFiles: 24
+  - Name: "collector"
+    Type: "otlp"
+    Endpoint: "http://localhost:4318/v1/traces"
+    Headers:
+      Authorization: "Bearer token"
+  - Type: "cloudwatchlogs"
+    LogGroup: "/aws/xray/spans"
+    LogStream: "host-1"
+  - Type: "s3"
+    Bucket: "my-trace-archive"
+    Prefix: "traces"
+    MaxObjectSizeMB: 16
+    FlushIntervalSecond: 60
+  - Type: "webhook"
+    Endpoint: "https://analytics.example.com/ingest"
+    Username: "daemon"
+    Password: "secret"
+    Gzip: true
+    TimeoutSecond: 5
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+	maxRetries := 3
+
+	assert.EqualValues(t, []ExporterConfig{
+		{Name: "mirror", Type: "xray", Region: "us-west-2", RoleARN: "arn:aws:iam::123456789012:role/xray-mirror", MaxRetries: &maxRetries},
+		{Type: "file", Directory: "/var/lib/xray/traces", MaxFileSizeMB: 100, RotateIntervalMinute: 60, Gzip: true, MaxFiles: 24},
+		{Name: "collector", Type: "otlp", Endpoint: "http://localhost:4318/v1/traces", Headers: map[string]string{"Authorization": "Bearer token"}},
+		{Type: "cloudwatchlogs", LogGroup: "/aws/xray/spans", LogStream: "host-1"},
+		{Type: "s3", Bucket: "my-trace-archive", Prefix: "traces", MaxObjectSizeMB: 16, FlushIntervalSecond: 60},
+		{Type: "webhook", Endpoint: "https://analytics.example.com/ingest", Username: "daemon", Password: "secret", Gzip: true, TimeoutSecond: 5},
+	}, c.Exporters)
+	clearTestFile()
+}
+
+func TestLoadConfigOverflow(t *testing.T) {
+	configString :=
+		`Overflow:
+  Policy: "spill"
+  SpillDirectory: "/var/spool/xray"
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.EqualValues(t, "spill", c.Overflow.Policy)
+	assert.EqualValues(t, 100, c.Overflow.BlockTimeoutMillisecond)
+	assert.EqualValues(t, "/var/spool/xray", c.Overflow.SpillDirectory)
+	clearTestFile()
+}
+
+func TestLoadConfigSpool(t *testing.T) {
+	configString :=
+		`Spool:
+  Directory: "/var/spool/xray"
+  TTLMinute: 10
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.EqualValues(t, "/var/spool/xray", c.Spool.Directory)
+	assert.EqualValues(t, 100, c.Spool.SizeLimitMB)
+	assert.EqualValues(t, 10, c.Spool.TTLMinute)
+	clearTestFile()
+}
+
+func TestLoadConfigAdaptiveConcurrency(t *testing.T) {
+	configString :=
+		`AdaptiveConcurrency:
+  Enabled: true
+  Max: 64
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.True(t, *c.AdaptiveConcurrency.Enabled)
+	assert.EqualValues(t, 1, c.AdaptiveConcurrency.Min)
+	assert.EqualValues(t, 64, c.AdaptiveConcurrency.Max)
+	assert.EqualValues(t, 1000, c.AdaptiveConcurrency.LatencyThresholdMillisecond)
+	clearTestFile()
+}
+
+func TestLoadConfigRateLimit(t *testing.T) {
+	configString :=
+		`RateLimit:
+  SegmentsPerSecond: 2600
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.EqualValues(t, 0, c.RateLimit.RequestsPerSecond)
+	assert.EqualValues(t, 2600, c.RateLimit.SegmentsPerSecond)
+	clearTestFile()
+}
+
+func TestLoadConfigBatching(t *testing.T) {
+	configString :=
+		`Batching:
+  MaxBytes: 1048576
+  MaxAgeMillisecond: 200
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.EqualValues(t, 50, c.Batching.MaxCount)
+	assert.EqualValues(t, 1048576, c.Batching.MaxBytes)
+	assert.EqualValues(t, 200, c.Batching.MaxAgeMillisecond)
+	assert.False(t, *c.Batching.TraceAffinity)
+	clearTestFile()
+}
+
+func TestLoadConfigBatchingTraceAffinity(t *testing.T) {
+	configString :=
+		`Batching:
+  TraceAffinity: true
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.True(t, *c.Batching.TraceAffinity)
+	assert.EqualValues(t, 1000, c.Batching.MaxAgeMillisecond)
+	clearTestFile()
+}
+
+func TestLoadConfigCircuitBreaker(t *testing.T) {
+	configString :=
+		`CircuitBreaker:
+  Enabled: true
+  ConsecutiveFailures: 3
+  Fallback: "spool"
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.True(t, *c.CircuitBreaker.Enabled)
+	assert.EqualValues(t, 3, c.CircuitBreaker.ConsecutiveFailures)
+	assert.EqualValues(t, 50, c.CircuitBreaker.ErrorRatePercent)
+	assert.EqualValues(t, 30, c.CircuitBreaker.OpenTimeoutSecond)
+	assert.EqualValues(t, "spool", c.CircuitBreaker.Fallback)
+	clearTestFile()
+}
+
+func TestLoadConfigDeadLetter(t *testing.T) {
+	configString :=
+		`DeadLetter:
+  Directory: "/var/lib/xray/deadletter"
+Admin:
+  Address: "127.0.0.1:2001"
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.EqualValues(t, "/var/lib/xray/deadletter", c.DeadLetter.Directory)
+	assert.EqualValues(t, 10, c.DeadLetter.SizeLimitMB)
+	assert.EqualValues(t, "127.0.0.1:2001", c.Admin.Address)
+	clearTestFile()
+}
+
+func TestLoadConfigFairQueue(t *testing.T) {
+	configString :=
+		`FairQueue:
+  Enabled: true
+  Key: "tenant"
+  Weights:
+    checkout: 3
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.True(t, *c.FairQueue.Enabled)
+	assert.EqualValues(t, "tenant", c.FairQueue.Key)
+	assert.EqualValues(t, 10, c.FairQueue.MinSharePercent)
+	assert.EqualValues(t, map[string]int{"checkout": 3}, c.FairQueue.Weights)
+	clearTestFile()
+}
+
+func TestLoadConfigForwardAndGateway(t *testing.T) {
+	configString :=
+		`Forward:
+  Gateways:
+    - "https://gateway-1:2002"
+    - "https://gateway-2:2002"
+  Token: "secret"
+  ConsistentHashing: true
+Gateway:
+  Address: "0.0.0.0:2002"
+  Token: "secret"
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.EqualValues(t, []string{"https://gateway-1:2002", "https://gateway-2:2002"}, c.Forward.Gateways)
+	assert.EqualValues(t, "secret", c.Forward.Token)
+	assert.True(t, *c.Forward.ConsistentHashing)
+	assert.EqualValues(t, 0, c.Forward.TimeoutSecond)
+	assert.EqualValues(t, "0.0.0.0:2002", c.Gateway.Address)
+	assert.EqualValues(t, "secret", c.Gateway.Token)
+	assert.EqualValues(t, "", c.Gateway.TLSCertFile)
+	assert.EqualValues(t, 16, c.Gateway.MaxRequestSizeMB)
+	clearTestFile()
+}
+
+func TestLoadConfigRouting(t *testing.T) {
+	configString :=
+		`Routing:
+  Rules:
+    - Field: "tenant"
+      Pattern: "payments"
+      Destination: "payments"
+    - Field: "annotations.account"
+      Pattern: "^1234.*$"
+      Match: "regex"
+      Destination: "payments"
+  Destinations:
+    - Name: "payments"
+      Region: "eu-west-1"
+      RoleARN: "arn:aws:iam::123456789012:role/xray"
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.EqualValues(t, []RoutingRule{
+		{Field: "tenant", Pattern: "payments", Destination: "payments"},
+		{Field: "annotations.account", Pattern: "^1234.*$", Match: "regex", Destination: "payments"},
+	}, c.Routing.Rules)
+	assert.EqualValues(t, 1, len(c.Routing.Destinations))
+	assert.EqualValues(t, "payments", c.Routing.Destinations[0].Name)
+	assert.EqualValues(t, "", c.Routing.Destinations[0].Type)
+	assert.EqualValues(t, "eu-west-1", c.Routing.Destinations[0].Region)
+	assert.EqualValues(t, "arn:aws:iam::123456789012:role/xray", c.Routing.Destinations[0].RoleARN)
+	clearTestFile()
+}
+
+func TestLoadConfigFailover(t *testing.T) {
+	configString :=
+		`Failover:
+  Endpoints:
+    - Region: "us-west-2"
+    - Endpoint: "https://xray.us-east-2.amazonaws.com"
+  ProbeIntervalSecond: 10
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.EqualValues(t, []FailoverEndpoint{{Region: "us-west-2"}, {Endpoint: "https://xray.us-east-2.amazonaws.com"}}, c.Failover.Endpoints)
+	assert.EqualValues(t, 60, c.Failover.FailoverAfterSecond)
+	assert.EqualValues(t, 10, c.Failover.ProbeIntervalSecond)
+	clearTestFile()
+}
+
+func TestLoadConfigCoalescing(t *testing.T) {
+	configString :=
+		`Coalescing:
+  Enabled: true
+  TTLSecond: 60
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.True(t, *c.Coalescing.Enabled)
+	assert.EqualValues(t, 5000, c.Coalescing.WindowMillisecond)
+	assert.EqualValues(t, 60, c.Coalescing.TTLSecond)
+	clearTestFile()
+}
+
+func TestLoadConfigShutdown(t *testing.T) {
+	configString :=
+		`Shutdown:
+  DrainTimeoutSecond: 5
+Version: 2`
+	setupTestFile(configString)
+
+	c := merge(tstFilePath)
+
+	assert.EqualValues(t, 5, c.Shutdown.DrainTimeoutSecond)
+	clearTestFile()
+}
+
 func TestValidConfigArray(t *testing.T) {
-	validString := []string{"TotalBufferSizeMB", "Concurrency", "Endpoint", "Region", "Socket.UDPAddress", "Socket.TCPAddress", "ProxyServer.IdleConnTimeout", "ProxyServer.MaxIdleConnsPerHost", "ProxyServer.MaxIdleConns", "Logging.LogRotation", "Logging.LogLevel", "Logging.LogPath", "LocalMode", "ResourceARN", "RoleARN", "NoVerifySSL", "ProxyAddress", "Version"}
+	validString := []string{"TotalBufferSizeMB", "Concurrency", "Endpoint", "Region", "Socket.UDPAddress", "Socket.TCPAddress", "ProxyServer.IdleConnTimeout", "ProxyServer.MaxIdleConnsPerHost", "ProxyServer.MaxIdleConns", "Logging.LogRotation", "Logging.LogLevel", "Logging.LogPath", "LocalMode", "DryRun.Enabled", "DryRun.Output", "ResourceARN", "RoleARN", "NoVerifySSL", "ProxyAddress", "Filter.DryRun", "Filter.Rules", "Exporters", "Overflow.Policy", "Overflow.BlockTimeoutMillisecond", "Overflow.SpillDirectory", "Spool.Directory", "Spool.SizeLimitMB", "Spool.TTLMinute", "AdaptiveConcurrency.Enabled", "AdaptiveConcurrency.Min", "AdaptiveConcurrency.Max", "AdaptiveConcurrency.LatencyThresholdMillisecond", "RateLimit.RequestsPerSecond", "RateLimit.SegmentsPerSecond", "Batching.MaxCount", "Batching.MaxBytes", "Batching.MaxAgeMillisecond", "Batching.TraceAffinity", "CircuitBreaker.Enabled", "CircuitBreaker.ConsecutiveFailures", "CircuitBreaker.ErrorRatePercent", "CircuitBreaker.MinRequests", "CircuitBreaker.WindowSecond", "CircuitBreaker.OpenTimeoutSecond", "CircuitBreaker.Fallback", "DeadLetter.Directory", "DeadLetter.SizeLimitMB", "Admin.Address", "FairQueue.Enabled", "FairQueue.Key", "FairQueue.MinSharePercent", "FairQueue.Weights", "Forward.Gateways", "Forward.Token", "Forward.ConsistentHashing", "Forward.TimeoutSecond", "Gateway.Address", "Gateway.Token", "Gateway.TLSCertFile", "Gateway.TLSKeyFile", "Gateway.MaxRequestSizeMB", "Routing.Rules", "Routing.Destinations", "Failover.Endpoints", "Failover.FailoverAfterSecond", "Failover.ProbeIntervalSecond", "Coalescing.Enabled", "Coalescing.WindowMillisecond", "Coalescing.TTLSecond", "Shutdown.DrainTimeoutSecond", "Version"}
 	testString := validConfigArray()
 	if len(validString) != len(testString) {
 		t.Fatalf("Unexpect test array length. Got %v but should be %v", len(testString), len(validString))
@@ -13,6 +13,8 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -180,13 +182,11 @@ func GetAWSConfig(ctx context.Context, cn connAttr, c *daemoncfg.Config, roleArn
 		}
 	}
 	if awsRegion == "" {
-		log.Errorf("Cannot fetch region variable from config file, environment variables, ecs metadata, or ec2 metadata. Use local-mode to use the local config region.")
-		os.Exit(1)
+		return aws.Config{}, errors.New("cannot fetch region variable from config file, environment variables, ecs metadata, or ec2 metadata. Use local-mode to use the local config region")
 	}
 	cfg, err = cn.newAWSConfig(ctx, roleArn, awsRegion)
 	if err != nil {
-		log.Errorf("Error creating AWS config: %v", err)
-		os.Exit(1)
+		return aws.Config{}, fmt.Errorf("error creating AWS config: %v", err)
 	}
 
 	// Apply custom settings
@@ -0,0 +1,204 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package conn
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/xray"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	log "github.com/cihub/seelog"
+)
+
+// FailoverConfig bounds the switches of a Failover between endpoints.
+type FailoverConfig struct {
+	// Time the active endpoint fails continuously before calls switch to the next endpoint.
+	FailoverAfter time.Duration
+
+	// Interval between calls sent to the primary endpoint as probes while failed over.
+	ProbeInterval time.Duration
+}
+
+// Failover is an X-Ray client sending calls to the first of a list of endpoints, the primary,
+// switching to the next endpoint once the active one failed continuously for a while.
+// While failed over, a call is sent to the primary on every probe interval, and calls fail back
+// to the primary once it succeeds. A failed probe is sent again to the active endpoint.
+type Failover struct {
+	// AWS configs and clients of the endpoints, the primary first.
+	configs []aws.Config
+	clients []XRay
+
+	config FailoverConfig
+
+	lock sync.Mutex
+
+	// Index of the endpoint calls are sent to.
+	active int
+
+	// Time the active endpoint started failing, zero if its last call succeeded.
+	failingSince time.Time
+
+	// Time the primary was last probed.
+	lastProbe time.Time
+
+	// Returns current time, replaced in tests.
+	now func() time.Time
+}
+
+// NewFailover returns a Failover between the X-Ray endpoints of configs, the primary first.
+func NewFailover(configs []aws.Config, c FailoverConfig) *Failover {
+	clients := make([]XRay, 0, len(configs))
+	for _, cfg := range configs {
+		clients = append(clients, NewXRay(cfg))
+	}
+	return newFailover(configs, clients, c)
+}
+
+func newFailover(configs []aws.Config, clients []XRay, c FailoverConfig) *Failover {
+	return &Failover{
+		configs: configs,
+		clients: clients,
+		config:  c,
+		now:     time.Now,
+	}
+}
+
+// PutTraceSegments sends input to the active endpoint, or to the primary as a probe.
+func (f *Failover) PutTraceSegments(ctx context.Context, input *xray.PutTraceSegmentsInput, opts ...func(*xray.Options)) (*xray.PutTraceSegmentsOutput, error) {
+	var out *xray.PutTraceSegmentsOutput
+	err := f.call(func(x XRay) error {
+		var err error
+		out, err = x.PutTraceSegments(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+// PutTelemetryRecords sends input to the active endpoint, or to the primary as a probe.
+func (f *Failover) PutTelemetryRecords(ctx context.Context, input *xray.PutTelemetryRecordsInput, opts ...func(*xray.Options)) (*xray.PutTelemetryRecordsOutput, error) {
+	var out *xray.PutTelemetryRecordsOutput
+	err := f.call(func(x XRay) error {
+		var err error
+		out, err = x.PutTelemetryRecords(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+// Len returns the number of endpoints.
+func (f *Failover) Len() int {
+	return len(f.configs)
+}
+
+// Config returns the AWS config of endpoint i.
+func (f *Failover) Config(i int) aws.Config {
+	return f.configs[i]
+}
+
+// Active returns the index of the endpoint calls are sent to, 0 for the primary.
+func (f *Failover) Active() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.active
+}
+
+// Report records the outcome of a call to endpoint i sent outside of the Failover, failed if
+// the endpoint could not be reached, timed out or answered with a 5xx response.
+func (f *Failover) Report(i int, failed bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.record(i, failed)
+}
+
+// call sends fn to the active endpoint, or to the primary if it is time to probe it.
+func (f *Failover) call(fn func(x XRay) error) error {
+	i, probe := f.pick()
+	if probe {
+		err := fn(f.clients[0])
+		failed := isEndpointFailure(err)
+		f.Report(0, failed)
+		if !failed {
+			return err
+		}
+		log.Debugf("Probe of primary X-Ray endpoint failed with: %v", err)
+		i = f.Active()
+	}
+	err := fn(f.clients[i])
+	f.Report(i, isEndpointFailure(err))
+	return err
+}
+
+// pick returns the index of the endpoint to call, and true if the call probes the primary.
+func (f *Failover) pick() (int, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.active != 0 && f.now().Sub(f.lastProbe) >= f.config.ProbeInterval {
+		f.lastProbe = f.now()
+		return 0, true
+	}
+	return f.active, false
+}
+
+// record updates the active endpoint with the outcome of a call to endpoint i. Lock must be held.
+func (f *Failover) record(i int, failed bool) {
+	if i == 0 && f.active != 0 && !failed {
+		log.Infof("Primary X-Ray endpoint %v recovered, failing back", f.name(0))
+		f.active = 0
+		f.failingSince = time.Time{}
+		return
+	}
+	if i != f.active {
+		return
+	}
+	if !failed {
+		f.failingSince = time.Time{}
+		return
+	}
+	now := f.now()
+	if f.failingSince.IsZero() {
+		f.failingSince = now
+		return
+	}
+	if now.Sub(f.failingSince) < f.config.FailoverAfter || f.active == len(f.clients)-1 {
+		return
+	}
+	log.Warnf("X-Ray endpoint %v failed for %v, failing over to %v", f.name(f.active), now.Sub(f.failingSince), f.name(f.active+1))
+	f.active++
+	f.failingSince = time.Time{}
+	f.lastProbe = now
+}
+
+// name returns the endpoint i in logs, its base endpoint if set, its region otherwise.
+func (f *Failover) name(i int) string {
+	if e := f.configs[i].BaseEndpoint; e != nil && *e != "" {
+		return *e
+	}
+	return f.configs[i].Region
+}
+
+// isEndpointFailure returns true if err shows the endpoint is unavailable: it could not be reached,
+// timed out or answered with a 5xx response. Errors the service answered otherwise, such as
+// throttling or invalid requests, and cancelled calls are not failures of the endpoint.
+func isEndpointFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var re *smithyhttp.ResponseError
+	if errors.As(err, &re) && re.Response != nil && re.Response.Response != nil && re.Response.StatusCode != 0 {
+		return re.Response.StatusCode >= 500
+	}
+	var ae smithy.APIError
+	return !errors.As(err, &ae)
+}
@@ -0,0 +1,170 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package conn
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/xray"
+	"github.com/aws/aws-xray-daemon/pkg/util/test"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+// endpointStub is an X-Ray client failing while down is true, counting its calls.
+type endpointStub struct {
+	down  bool
+	calls int
+}
+
+func (e *endpointStub) PutTraceSegments(ctx context.Context, input *xray.PutTraceSegmentsInput, opts ...func(*xray.Options)) (*xray.PutTraceSegmentsOutput, error) {
+	e.calls++
+	if e.down {
+		return nil, errors.New("dial tcp: connection refused")
+	}
+	return &xray.PutTraceSegmentsOutput{}, nil
+}
+
+func (e *endpointStub) PutTelemetryRecords(ctx context.Context, input *xray.PutTelemetryRecordsInput, opts ...func(*xray.Options)) (*xray.PutTelemetryRecordsOutput, error) {
+	e.calls++
+	if e.down {
+		return nil, errors.New("dial tcp: connection refused")
+	}
+	return &xray.PutTelemetryRecordsOutput{}, nil
+}
+
+func getTestFailover(endpoints ...*endpointStub) (*Failover, *time.Time) {
+	test.LogSetup()
+	configs := make([]aws.Config, 0, len(endpoints))
+	clients := make([]XRay, 0, len(endpoints))
+	for _, e := range endpoints {
+		configs = append(configs, aws.Config{Region: "us-east-1"})
+		clients = append(clients, e)
+	}
+	f := newFailover(configs, clients, FailoverConfig{FailoverAfter: time.Minute, ProbeInterval: 30 * time.Second})
+	now := time.Unix(1700000000, 0)
+	f.now = func() time.Time { return now }
+	return f, &now
+}
+
+func put(f *Failover) error {
+	_, err := f.PutTraceSegments(context.Background(), &xray.PutTraceSegmentsInput{})
+	return err
+}
+
+func TestFailoverAfterContinuousFailures(t *testing.T) {
+	primary := &endpointStub{down: true}
+	secondary := &endpointStub{}
+	f, now := getTestFailover(primary, secondary)
+
+	assert.NotNil(t, put(f))
+	*now = now.Add(59 * time.Second)
+	assert.NotNil(t, put(f))
+	assert.EqualValues(t, 0, f.Active(), "The primary has not failed for long enough")
+
+	*now = now.Add(time.Second)
+	assert.NotNil(t, put(f))
+	assert.EqualValues(t, 1, f.Active())
+
+	assert.Nil(t, put(f))
+	assert.EqualValues(t, 3, primary.calls)
+	assert.EqualValues(t, 1, secondary.calls)
+}
+
+func TestFailoverSuccessResetsFailures(t *testing.T) {
+	primary := &endpointStub{down: true}
+	f, now := getTestFailover(primary, &endpointStub{})
+
+	assert.NotNil(t, put(f))
+	*now = now.Add(50 * time.Second)
+	primary.down = false
+	assert.Nil(t, put(f))
+	primary.down = true
+	assert.NotNil(t, put(f))
+	*now = now.Add(50 * time.Second)
+	assert.NotNil(t, put(f))
+
+	assert.EqualValues(t, 0, f.Active())
+}
+
+func TestFailoverProbesAndFailsBack(t *testing.T) {
+	primary := &endpointStub{down: true}
+	secondary := &endpointStub{}
+	f, now := getTestFailover(primary, secondary)
+	put(f)
+	*now = now.Add(time.Minute)
+	put(f)
+	assert.EqualValues(t, 1, f.Active())
+
+	*now = now.Add(30 * time.Second)
+	assert.Nil(t, put(f), "A failed probe is sent again to the active endpoint")
+	assert.EqualValues(t, 3, primary.calls)
+	assert.EqualValues(t, 1, secondary.calls)
+	assert.EqualValues(t, 1, f.Active())
+
+	*now = now.Add(10 * time.Second)
+	assert.Nil(t, put(f))
+	assert.EqualValues(t, 3, primary.calls, "The primary is probed once per probe interval")
+
+	primary.down = false
+	*now = now.Add(20 * time.Second)
+	assert.Nil(t, put(f))
+	assert.EqualValues(t, 0, f.Active())
+	assert.EqualValues(t, 4, primary.calls)
+	assert.EqualValues(t, 2, secondary.calls)
+}
+
+func TestFailoverStaysOnLastEndpoint(t *testing.T) {
+	secondary := &endpointStub{down: true}
+	f, now := getTestFailover(&endpointStub{down: true}, secondary)
+	put(f)
+	*now = now.Add(time.Minute)
+	put(f)
+	put(f)
+	*now = now.Add(time.Minute)
+	put(f)
+
+	assert.EqualValues(t, 1, f.Active())
+}
+
+func TestFailoverReport(t *testing.T) {
+	f, now := getTestFailover(&endpointStub{}, &endpointStub{}, &endpointStub{})
+
+	f.Report(0, true)
+	*now = now.Add(time.Minute)
+	f.Report(0, true)
+	assert.EqualValues(t, 1, f.Active())
+	f.Report(0, true)
+	f.Report(2, false)
+	assert.EqualValues(t, 1, f.Active(), "Outcomes of inactive endpoints other than the primary are ignored")
+	f.Report(0, false)
+	assert.EqualValues(t, 0, f.Active())
+}
+
+func TestIsEndpointFailure(t *testing.T) {
+	response := func(status int) error {
+		return &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}}, Err: errors.New("failed")}
+	}
+
+	assert.False(t, isEndpointFailure(nil))
+	assert.False(t, isEndpointFailure(context.Canceled))
+	assert.True(t, isEndpointFailure(errors.New("dial tcp: connection refused")))
+	assert.True(t, isEndpointFailure(response(503)))
+	assert.True(t, isEndpointFailure(response(0)), "A response error without status means no response was received")
+	assert.True(t, isEndpointFailure(&smithyhttp.ResponseError{Response: &smithyhttp.Response{}, Err: errors.New("refused")}))
+	assert.False(t, isEndpointFailure(response(400)))
+	assert.False(t, isEndpointFailure(&smithy.GenericAPIError{Code: "ThrottlingException"}))
+}
@@ -0,0 +1,239 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+// Package deadletter records segment documents dropped or rejected by the daemon, along with the reason.
+package deadletter
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/spool"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	log "github.com/cihub/seelog"
+)
+
+// Reasons segment documents are dead-lettered for.
+const (
+	// ReasonMissingSegment is recorded for messages without header or segment.
+	ReasonMissingSegment = "missing-segment"
+
+	// ReasonInvalidHeader is recorded for segments with an invalid header.
+	ReasonInvalidHeader = "invalid-header"
+
+	// ReasonNoBuffer is recorded for segments received while the buffer pool is empty.
+	ReasonNoBuffer = "no-buffer"
+
+	// ReasonTooLarge is recorded for forwarded segments larger than a segment buffer.
+	ReasonTooLarge = "too-large"
+
+	// ReasonBufferFull is recorded for segments dropped by a full segment buffer.
+	ReasonBufferFull = "buffer-full"
+
+	// ReasonBatchQueueFull is recorded for segments dropped by a full batch queue.
+	ReasonBatchQueueFull = "batch-queue-full"
+
+	// ReasonBreakerOpen is recorded for segments dropped while the circuit breaker around X-Ray is open.
+	ReasonBreakerOpen = "breaker-open"
+
+	// ReasonShutdown is recorded for segments left once uploads were cancelled at shutdown, without spool.
+	ReasonShutdown = "shutdown"
+
+	// ReasonUnprocessed is recorded for segments rejected by X-Ray for permanent reasons.
+	ReasonUnprocessed = "unprocessed"
+
+	// ReasonResubmitExhausted is recorded for unprocessed segments resubmitted the maximum number of times.
+	ReasonResubmitExhausted = "resubmit-exhausted"
+
+	// ReasonExportFailed is recorded for routed segments their destination failed to receive.
+	ReasonExportFailed = "export-failed"
+)
+
+// Number of recent entries kept in memory.
+const recentCapacity = 100
+
+// Number of recent entries listed by default.
+const defaultLimit = 20
+
+// Number of batches of entries waiting to be written, further entries are counted and not written.
+const queueCapacity = 256
+
+// Name of the telemetry counter of entries not written because the queue was full.
+const overflowCounterName = "deadletter.overflow"
+
+// D is the dead-letter store of the daemon, nil if not configured.
+var D *Store
+
+// Entry is a dead-lettered segment document.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Reason    string    `json:"reason"`
+	ErrorCode string    `json:"error_code,omitempty"`
+	Document  string    `json:"document"`
+}
+
+// Store writes dead-lettered documents to rotating NDJSON files and keeps the most recent in memory.
+// Entries are written by a background go routine, so recording them never waits for the disk.
+type Store struct {
+	// Files entries are written to.
+	files *spool.Spool
+
+	// Batches of entries waiting to be written.
+	queue chan []Entry
+
+	// Channel closed once the queued entries are written.
+	written chan struct{}
+
+	// Lock guarding the fields below.
+	lock sync.Mutex
+
+	// Ring of the most recent entries.
+	recent []Entry
+
+	// Index in recent of the next entry.
+	next int
+
+	// Boolean, set to true once the queue is closed.
+	closed bool
+}
+
+// Init instantiates the dead-letter store D writing to directory dir, holding at most maxBytes.
+func Init(dir string, maxBytes int64) error {
+	s, err := New(dir, maxBytes)
+	if err != nil {
+		return err
+	}
+	D = s
+	return nil
+}
+
+// New returns a Store writing to directory dir, holding at most maxBytes, the oldest files being removed to make room.
+func New(dir string, maxBytes int64) (*Store, error) {
+	files, err := spool.NewWithLimits(dir, maxBytes, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		files:   files,
+		queue:   make(chan []Entry, queueCapacity),
+		written: make(chan struct{}),
+	}
+	go s.write()
+	return s, nil
+}
+
+// Record dead-letters segment document doc for the given reason and error code.
+// Does nothing if the store is nil.
+func (s *Store) Record(reason string, errorCode string, doc []byte) {
+	if s == nil {
+		return
+	}
+	s.add([]Entry{{Time: time.Now(), Reason: reason, ErrorCode: errorCode, Document: string(doc)}})
+}
+
+// RecordBatch dead-letters segment documents docs for the given reason and error code.
+// Does nothing if the store is nil.
+func (s *Store) RecordBatch(reason string, errorCode string, docs []string) {
+	if s == nil || len(docs) == 0 {
+		return
+	}
+	now := time.Now()
+	entries := make([]Entry, 0, len(docs))
+	for _, doc := range docs {
+		entries = append(entries, Entry{Time: now, Reason: reason, ErrorCode: errorCode, Document: doc})
+	}
+	s.add(entries)
+}
+
+// Recent returns at most limit of the most recent entries, newest first.
+func (s *Store) Recent(limit int) []Entry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if limit > len(s.recent) {
+		limit = len(s.recent)
+	}
+	entries := make([]Entry, 0, limit)
+	for i := 1; i <= limit; i++ {
+		entries = append(entries, s.recent[(s.next-i+len(s.recent))%len(s.recent)])
+	}
+	return entries
+}
+
+// ServeHTTP lists recent entries as a JSON array, newest first. The limit query parameter sets the number of entries.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := defaultLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Recent(limit)); err != nil {
+		log.Errorf("Unable to write dead-letter entries: %v", err)
+	}
+}
+
+// Close writes the queued entries and seals the file being written. Entries recorded afterwards are not written.
+func (s *Store) Close() error {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.lock.Unlock()
+	<-s.written
+	return s.files.Close()
+}
+
+// add keeps entries in memory and queues them to be written, counting them instead if the queue is full.
+func (s *Store) add(entries []Entry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, e := range entries {
+		if len(s.recent) < recentCapacity {
+			s.recent = append(s.recent, e)
+		} else {
+			s.recent[s.next] = e
+		}
+		s.next = (s.next + 1) % recentCapacity
+	}
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- entries:
+	default:
+		log.Debugf("Dead-letter queue is full, not writing %d entries", len(entries))
+		telemetry.T.Count(overflowCounterName, int64(len(entries)))
+	}
+}
+
+// write writes the queued entries to files until the queue is closed.
+func (s *Store) write() {
+	defer close(s.written)
+	for entries := range s.queue {
+		lines := make([]string, 0, len(entries))
+		for _, e := range entries {
+			line, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			lines = append(lines, string(line))
+		}
+		if err := s.files.Spill(lines); err != nil {
+			log.Errorf("Unable to write dead-letter entries: %v", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-xray-daemon/pkg/spool"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestStore(t *testing.T) (*Store, string) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func TestRecordNilStore(t *testing.T) {
+	var s *Store
+
+	s.Record(ReasonBufferFull, "", []byte("{}"))
+	s.RecordBatch(ReasonBatchQueueFull, "", []string{"{}"})
+}
+
+func TestRecordWritesEntries(t *testing.T) {
+	s, dir := getTestStore(t)
+	defer os.RemoveAll(dir)
+
+	s.Record(ReasonInvalidHeader, "", []byte("{\"format\":\"xml\"}\n{}"))
+	s.RecordBatch(ReasonUnprocessed, "InvalidTraceId", []string{"{\"id\":\"1\"}"})
+	s.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	assert.Equal(t, 1, len(files))
+	content, _ := ioutil.ReadFile(files[0])
+	var entries []Entry
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var e Entry
+		assert.Nil(t, json.Unmarshal([]byte(line), &e))
+		entries = append(entries, e)
+	}
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, ReasonInvalidHeader, entries[0].Reason)
+	assert.Equal(t, "{\"format\":\"xml\"}\n{}", entries[0].Document)
+	assert.Equal(t, ReasonUnprocessed, entries[1].Reason)
+	assert.Equal(t, "InvalidTraceId", entries[1].ErrorCode)
+	assert.False(t, entries[1].Time.IsZero())
+}
+
+func TestRecordCountsEntriesOverQueueCapacity(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	dir, err := ioutil.TempDir("", "deadletter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sp, err := spool.New(dir)
+	assert.Nil(t, err)
+	// Entries are not written until the writer is started.
+	s := &Store{files: sp, queue: make(chan []Entry, 1), written: make(chan struct{})}
+
+	s.RecordBatch(ReasonBufferFull, "", []string{"{\"id\":\"1\"}"})
+	s.RecordBatch(ReasonBufferFull, "", []string{"{\"id\":\"2\"}", "{\"id\":\"3\"}"})
+	go s.write()
+	s.Close()
+
+	assert.EqualValues(t, 2, telemetry.T.Counter("deadletter.overflow"))
+	assert.Equal(t, 3, len(s.Recent(10)), "Entries not written are still listed")
+	files, _ := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	assert.Equal(t, 1, len(files))
+	content, _ := ioutil.ReadFile(files[0])
+	assert.Equal(t, 1, strings.Count(string(content), "\n"))
+}
+
+func TestRecentNewestFirst(t *testing.T) {
+	s, dir := getTestStore(t)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < recentCapacity+5; i++ {
+		s.RecordBatch(ReasonBufferFull, "", []string{fmt.Sprintf("{\"id\":\"%v\"}", i)})
+	}
+
+	entries := s.Recent(recentCapacity + 10)
+	assert.Equal(t, recentCapacity, len(entries))
+	assert.Equal(t, fmt.Sprintf("{\"id\":\"%v\"}", recentCapacity+4), entries[0].Document)
+	assert.Equal(t, "{\"id\":\"5\"}", entries[recentCapacity-1].Document)
+}
+
+func TestServeHTTP(t *testing.T) {
+	s, dir := getTestStore(t)
+	defer os.RemoveAll(dir)
+	s.RecordBatch(ReasonBufferFull, "", []string{"{\"id\":\"1\"}", "{\"id\":\"2\"}"})
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/deadletter?limit=1", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var entries []Entry
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &entries))
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "{\"id\":\"2\"}", entries[0].Document)
+}
+
+func TestServeHTTPInvalidLimit(t *testing.T) {
+	s, dir := getTestStore(t)
+	defer os.RemoveAll(dir)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/deadletter?limit=all", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
@@ -0,0 +1,541 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	"github.com/aws/smithy-go"
+	log "github.com/cihub/seelog"
+)
+
+// Limits of a PutLogEvents call.
+const (
+	// Maximum number of events of a call.
+	maxLogEventsPerCall = 10000
+
+	// Maximum size of the events of a call, each event counting its message and logEventOverhead bytes.
+	maxLogEventsBytes = 1048576
+
+	// Bytes counted for every event in addition to its message.
+	logEventOverhead = 26
+
+	// Maximum size of an event, including logEventOverhead.
+	maxLogEventBytes = 262144
+
+	// Maximum time between the first and last event of a call.
+	maxLogEventsSpan = 24 * time.Hour
+)
+
+// Maximum number of times a call is sent again after the stream or sequence token changed.
+const maxSequenceRetries = 3
+
+// Error code of the segments with a span too large for a log event.
+const errorCodeTooLarge = "TooLarge"
+
+// Error code of the segments with a span rejected by CloudWatch Logs for its timestamp.
+const errorCodeRejected = "Rejected"
+
+// Version of the CloudWatch Logs JSON protocol, prefixing the X-Amz-Target header.
+const logsTargetPrefix = "Logs_20140328."
+
+// CloudWatchLogsConfig describes the log stream a CloudWatch Logs exporter writes span records to.
+type CloudWatchLogsConfig struct {
+	// Log group of the stream, which must exist.
+	LogGroup string
+
+	// Log stream span records are written to, created if it does not exist.
+	LogStream string
+
+	// Region of the log group.
+	Region string
+
+	// URL of the CloudWatch Logs service, https://logs.<region>.amazonaws.com if empty.
+	Endpoint string
+
+	// Credentials requests are signed with.
+	Credentials aws.CredentialsProvider
+
+	// Client sending the requests, http.DefaultClient if nil.
+	HTTPClient aws.HTTPClient
+}
+
+// CloudWatchLogs exports segment documents as structured span records, one log event per span,
+// written to a CloudWatch Logs stream with signed PutLogEvents calls.
+type CloudWatchLogs struct {
+	config   CloudWatchLogsConfig
+	endpoint string
+	client   aws.HTTPClient
+	signer   *awsSigner
+
+	// Serializes calls to the stream, which share the sequence token.
+	lock sync.Mutex
+
+	// Sequence token returned by the last call, empty before the first call.
+	sequenceToken string
+
+	// Whether the log stream is known to exist.
+	streamExists bool
+}
+
+// spanRecord is the structured record of a span written as a log event.
+type spanRecord struct {
+	Resource          spanRecordResource     `json:"resource"`
+	Scope             otlpScope              `json:"scope"`
+	TraceID           string                 `json:"traceId"`
+	SpanID            string                 `json:"spanId"`
+	ParentSpanID      string                 `json:"parentSpanId,omitempty"`
+	Name              string                 `json:"name"`
+	Kind              string                 `json:"kind"`
+	StartTimeUnixNano int64                  `json:"startTimeUnixNano"`
+	EndTimeUnixNano   int64                  `json:"endTimeUnixNano"`
+	DurationNano      int64                  `json:"durationNano"`
+	Attributes        map[string]interface{} `json:"attributes,omitempty"`
+	Events            []spanRecordEvent      `json:"events,omitempty"`
+	Status            spanRecordStatus       `json:"status"`
+}
+
+type spanRecordResource struct {
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type spanRecordEvent struct {
+	TimeUnixNano int64                  `json:"timeUnixNano"`
+	Name         string                 `json:"name"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type spanRecordStatus struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Names of the OTLP span kinds in span records.
+var spanKindNames = map[int]string{
+	spanKindInternal: "INTERNAL",
+	spanKindServer:   "SERVER",
+	spanKindClient:   "CLIENT",
+}
+
+type inputLogEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// logEvent is an event to write along with the index of the segment document it was converted from.
+type logEvent struct {
+	inputLogEvent
+	doc int
+}
+
+type putLogEventsInput struct {
+	LogGroupName  string          `json:"logGroupName"`
+	LogStreamName string          `json:"logStreamName"`
+	LogEvents     []inputLogEvent `json:"logEvents"`
+	SequenceToken string          `json:"sequenceToken,omitempty"`
+}
+
+type putLogEventsOutput struct {
+	NextSequenceToken     string                 `json:"nextSequenceToken"`
+	RejectedLogEventsInfo *rejectedLogEventsInfo `json:"rejectedLogEventsInfo"`
+}
+
+type rejectedLogEventsInfo struct {
+	TooNewLogEventStartIndex *int `json:"tooNewLogEventStartIndex"`
+	TooOldLogEventEndIndex   *int `json:"tooOldLogEventEndIndex"`
+	ExpiredLogEventEndIndex  *int `json:"expiredLogEventEndIndex"`
+}
+
+type createLogStreamInput struct {
+	LogGroupName  string `json:"logGroupName"`
+	LogStreamName string `json:"logStreamName"`
+}
+
+// cloudWatchLogsError is an error returned by CloudWatch Logs.
+type cloudWatchLogsError struct {
+	StatusCode int
+	Type       string
+	Message    string
+
+	// Sequence token expected by the stream, set for sequence token errors.
+	ExpectedSequenceToken string
+}
+
+func (e *cloudWatchLogsError) Error() string {
+	return fmt.Sprintf("cloudwatchlogs exporter: %v (status %v): %v", e.Type, e.StatusCode, e.Message)
+}
+
+// Unwrap returns the status of the response, telling callers whether the request may be retried.
+func (e *cloudWatchLogsError) Unwrap() error {
+	return &StatusError{
+		Exporter:   "cloudwatchlogs",
+		StatusCode: e.StatusCode,
+		Status:     fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		Body:       e.Message,
+	}
+}
+
+// ErrorCode returns the type of the error, such as ThrottlingException, which CloudWatch Logs returns with status 400.
+func (e *cloudWatchLogsError) ErrorCode() string {
+	return e.Type
+}
+
+// ErrorMessage returns the message of the error.
+func (e *cloudWatchLogsError) ErrorMessage() string {
+	return e.Message
+}
+
+// ErrorFault returns whether the error is caused by the client or the service.
+func (e *cloudWatchLogsError) ErrorFault() smithy.ErrorFault {
+	if e.StatusCode >= 500 {
+		return smithy.FaultServer
+	}
+	return smithy.FaultClient
+}
+
+// NewCloudWatchLogs returns an exporter writing span records to the log stream described by c.
+func NewCloudWatchLogs(c CloudWatchLogsConfig) (*CloudWatchLogs, error) {
+	if c.LogGroup == "" || c.LogStream == "" {
+		return nil, errors.New("cloudwatchlogs exporter: log group and log stream are required")
+	}
+	if c.Region == "" {
+		return nil, errors.New("cloudwatchlogs exporter: region is required")
+	}
+	if c.Credentials == nil {
+		return nil, errors.New("cloudwatchlogs exporter: credentials are required")
+	}
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://logs." + c.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("cloudwatchlogs exporter: invalid endpoint %q", endpoint)
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &CloudWatchLogs{
+		config:   c,
+		endpoint: endpoint,
+		client:   client,
+		signer:   newAWSSigner(c.Credentials, "logs", c.Region),
+	}, nil
+}
+
+// Export converts docs into span records, and writes them in as few PutLogEvents calls as the limits allow.
+// Documents which cannot be converted, or with a span rejected by CloudWatch Logs, are returned as unprocessed.
+// Calls are not atomic: if a call fails, records written by the previous calls are written again on retry.
+func (l *CloudWatchLogs) Export(ctx context.Context, docs []string) ([]types.UnprocessedTraceSegment, error) {
+	var events []logEvent
+	failed := make(map[int]types.UnprocessedTraceSegment)
+	for i, doc := range docs {
+		records, err := spanRecords(doc)
+		if err != nil {
+			log.Debugf("cloudwatchlogs exporter: unable to convert segment: %v", err)
+			failed[i] = unprocessedSegment(doc, errorCodeInvalidSegment, err.Error())
+			continue
+		}
+		for _, r := range records {
+			message, err := json.Marshal(r)
+			if err != nil {
+				failed[i] = unprocessedSegment(doc, errorCodeInvalidSegment, err.Error())
+				break
+			}
+			if len(message)+logEventOverhead > maxLogEventBytes {
+				failed[i] = unprocessedSegment(doc, errorCodeTooLarge, fmt.Sprintf("span record of %d bytes exceeds the log event size limit", len(message)))
+				break
+			}
+			events = append(events, logEvent{
+				inputLogEvent: inputLogEvent{Timestamp: r.StartTimeUnixNano / int64(time.Millisecond), Message: string(message)},
+				doc:           i,
+			})
+		}
+	}
+	// Events of a call must be in chronological order.
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp < events[j].Timestamp
+	})
+	for _, batch := range splitLogEvents(events) {
+		rejected, err := l.putLogEvents(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range rejected {
+			if _, ok := failed[e.doc]; !ok {
+				failed[e.doc] = unprocessedSegment(docs[e.doc], errorCodeRejected, "span timestamp rejected by CloudWatch Logs")
+			}
+		}
+	}
+	var unprocessed []types.UnprocessedTraceSegment
+	for i := range docs {
+		if u, ok := failed[i]; ok {
+			unprocessed = append(unprocessed, u)
+		}
+	}
+	return unprocessed, nil
+}
+
+// Close releases idle connections to CloudWatch Logs.
+func (l *CloudWatchLogs) Close() error {
+	if c, ok := l.client.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+	return nil
+}
+
+// putLogEvents writes events to the log stream, creating the stream first if needed, and returns
+// the events rejected for their timestamp.
+func (l *CloudWatchLogs) putLogEvents(ctx context.Context, events []logEvent) ([]logEvent, error) {
+	input := putLogEventsInput{
+		LogGroupName:  l.config.LogGroup,
+		LogStreamName: l.config.LogStream,
+		LogEvents:     make([]inputLogEvent, len(events)),
+	}
+	for i, e := range events {
+		input.LogEvents[i] = e.inputLogEvent
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for attempt := 0; ; attempt++ {
+		if !l.streamExists {
+			if err := l.createLogStream(ctx); err != nil {
+				return nil, err
+			}
+			l.streamExists = true
+		}
+		input.SequenceToken = l.sequenceToken
+		var output putLogEventsOutput
+		err := l.call(ctx, "PutLogEvents", input, &output)
+		if e, ok := err.(*cloudWatchLogsError); ok && attempt < maxSequenceRetries {
+			switch e.Type {
+			case "InvalidSequenceTokenException":
+				log.Debugf("cloudwatchlogs exporter: sequence token of stream %v changed", l.config.LogStream)
+				l.sequenceToken = e.ExpectedSequenceToken
+				continue
+			case "DataAlreadyAcceptedException":
+				l.sequenceToken = e.ExpectedSequenceToken
+				return nil, nil
+			case "ResourceNotFoundException":
+				// The stream was deleted since it was created.
+				l.streamExists = false
+				l.sequenceToken = ""
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		l.sequenceToken = output.NextSequenceToken
+		return output.RejectedLogEventsInfo.rejected(events), nil
+	}
+}
+
+// createLogStream creates the log stream, succeeding if it already exists.
+func (l *CloudWatchLogs) createLogStream(ctx context.Context) error {
+	err := l.call(ctx, "CreateLogStream", createLogStreamInput{
+		LogGroupName:  l.config.LogGroup,
+		LogStreamName: l.config.LogStream,
+	}, nil)
+	if e, ok := err.(*cloudWatchLogsError); ok && e.Type == "ResourceAlreadyExistsException" {
+		return nil
+	}
+	if err == nil {
+		log.Infof("cloudwatchlogs exporter: created log stream %v in log group %v", l.config.LogStream, l.config.LogGroup)
+	}
+	return err
+}
+
+// call sends a signed request for action with input, and decodes the response into output unless nil.
+func (l *CloudWatchLogs) call(ctx context.Context, action string, input interface{}, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("cloudwatchlogs exporter: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cloudwatchlogs exporter: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", logsTargetPrefix+action)
+	if err := l.signer.sign(ctx, req, body); err != nil {
+		return fmt.Errorf("cloudwatchlogs exporter: unable to sign request: %w", err)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudwatchlogs exporter: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxLogEventsBytes))
+	if err != nil {
+		return fmt.Errorf("cloudwatchlogs exporter: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newCloudWatchLogsError(resp.StatusCode, respBody)
+	}
+	if output == nil || len(respBody) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, output); err != nil {
+		return fmt.Errorf("cloudwatchlogs exporter: invalid %v response: %w", action, err)
+	}
+	return nil
+}
+
+// newCloudWatchLogsError decodes the JSON error document body of a response with statusCode.
+func newCloudWatchLogsError(statusCode int, body []byte) *cloudWatchLogsError {
+	var doc struct {
+		Type                  string `json:"__type"`
+		Message               string `json:"message"`
+		UpperMessage          string `json:"Message"`
+		ExpectedSequenceToken string `json:"expectedSequenceToken"`
+	}
+	e := &cloudWatchLogsError{StatusCode: statusCode}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		e.Type = http.StatusText(statusCode)
+		e.Message = string(bytes.TrimSpace(body))
+		return e
+	}
+	// The type may be prefixed by the namespace of the service.
+	e.Type = doc.Type[strings.LastIndex(doc.Type, "#")+1:]
+	e.Message = doc.Message
+	if e.Message == "" {
+		e.Message = doc.UpperMessage
+	}
+	e.ExpectedSequenceToken = doc.ExpectedSequenceToken
+	return e
+}
+
+// rejected returns the events of a call rejected according to info, which may be nil.
+func (info *rejectedLogEventsInfo) rejected(events []logEvent) []logEvent {
+	if info == nil {
+		return nil
+	}
+	var rejected []logEvent
+	for i, e := range events {
+		if (info.TooNewLogEventStartIndex != nil && i >= *info.TooNewLogEventStartIndex) ||
+			(info.TooOldLogEventEndIndex != nil && i < *info.TooOldLogEventEndIndex) ||
+			(info.ExpiredLogEventEndIndex != nil && i < *info.ExpiredLogEventEndIndex) {
+			rejected = append(rejected, e)
+		}
+	}
+	return rejected
+}
+
+// splitLogEvents splits chronologically sorted events into batches within the limits of a PutLogEvents call.
+func splitLogEvents(events []logEvent) [][]logEvent {
+	var batches [][]logEvent
+	start := 0
+	size := 0
+	for i, e := range events {
+		eventSize := len(e.Message) + logEventOverhead
+		if i > start && (i-start == maxLogEventsPerCall || size+eventSize > maxLogEventsBytes ||
+			time.Duration(e.Timestamp-events[start].Timestamp)*time.Millisecond > maxLogEventsSpan) {
+			batches = append(batches, events[start:i])
+			start = i
+			size = 0
+		}
+		size += eventSize
+	}
+	if start < len(events) {
+		batches = append(batches, events[start:])
+	}
+	return batches
+}
+
+// spanRecords converts segment document doc and its subsegments into span records.
+func spanRecords(doc string) ([]spanRecord, error) {
+	converter := newSpanConverter()
+	if err := converter.add(doc); err != nil {
+		return nil, err
+	}
+	var records []spanRecord
+	for _, rs := range converter.request.ResourceSpans {
+		resource := attributeMap(rs.Resource.Attributes)
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				records = append(records, newSpanRecord(resource, ss.Scope, s))
+			}
+		}
+	}
+	return records, nil
+}
+
+func newSpanRecord(resource map[string]interface{}, scope otlpScope, s otlpSpan) spanRecord {
+	start, _ := strconv.ParseInt(s.StartTimeUnixNano, 10, 64)
+	end, _ := strconv.ParseInt(s.EndTimeUnixNano, 10, 64)
+	r := spanRecord{
+		Resource:          spanRecordResource{Attributes: resource},
+		Scope:             scope,
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentSpanID,
+		Name:              s.Name,
+		Kind:              spanKindNames[s.Kind],
+		StartTimeUnixNano: start,
+		EndTimeUnixNano:   end,
+		DurationNano:      end - start,
+		Attributes:        attributeMap(s.Attributes),
+		Status:            spanRecordStatus{Code: "UNSET", Message: s.Status.Message},
+	}
+	if s.Status.Code == statusCodeError {
+		r.Status.Code = "ERROR"
+	}
+	for _, e := range s.Events {
+		t, _ := strconv.ParseInt(e.TimeUnixNano, 10, 64)
+		r.Events = append(r.Events, spanRecordEvent{TimeUnixNano: t, Name: e.Name, Attributes: attributeMap(e.Attributes)})
+	}
+	return r
+}
+
+// attributeMap converts OTLP attributes into a map of plain values, nil if there are none.
+func attributeMap(attrs []otlpKeyValue) map[string]interface{} {
+	if len(attrs) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{}, len(attrs))
+	for _, a := range attrs {
+		switch v := a.Value; {
+		case v.StringValue != nil:
+			m[a.Key] = *v.StringValue
+		case v.BoolValue != nil:
+			m[a.Key] = *v.BoolValue
+		case v.IntValue != nil:
+			m[a.Key] = json.Number(*v.IntValue)
+		case v.DoubleValue != nil:
+			m[a.Key] = *v.DoubleValue
+		}
+	}
+	return m
+}
+
+func unprocessedSegment(doc string, errorCode string, message string) types.UnprocessedTraceSegment {
+	return types.UnprocessedTraceSegment{
+		Id:        aws.String(segmentID(doc)),
+		ErrorCode: aws.String(errorCode),
+		Message:   aws.String(message),
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+// logsStandIn is a local stand-in for CloudWatch Logs, answering each action with the queued responses
+// and then with a success.
+type logsStandIn struct {
+	lock      sync.Mutex
+	server    *httptest.Server
+	actions   []string
+	puts      []putLogEventsInput
+	responses map[string][]logsResponse
+	token     int
+}
+
+type logsResponse struct {
+	status int
+	body   string
+}
+
+func newLogsStandIn(t *testing.T) *logsStandIn {
+	s := &logsStandIn{responses: make(map[string][]logsResponse)}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		assert.Equal(t, "application/x-amz-json-1.1", r.Header.Get("Content-Type"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-west-2/logs/aws4_request")
+		action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), logsTargetPrefix)
+		s.actions = append(s.actions, action)
+		body, _ := ioutil.ReadAll(r.Body)
+		if queued := s.responses[action]; len(queued) > 0 {
+			s.responses[action] = queued[1:]
+			w.WriteHeader(queued[0].status)
+			w.Write([]byte(queued[0].body))
+			return
+		}
+		switch action {
+		case "CreateLogStream":
+			var input createLogStreamInput
+			assert.Nil(t, json.Unmarshal(body, &input))
+			assert.Equal(t, "traces", input.LogGroupName)
+			assert.Equal(t, "host-1", input.LogStreamName)
+		case "PutLogEvents":
+			var input putLogEventsInput
+			assert.Nil(t, json.Unmarshal(body, &input))
+			s.puts = append(s.puts, input)
+			s.token++
+			json.NewEncoder(w).Encode(map[string]string{"nextSequenceToken": "token-" + string(rune('0'+s.token))})
+		}
+	}))
+	return s
+}
+
+func (s *logsStandIn) respond(action string, status int, body string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.responses[action] = append(s.responses[action], logsResponse{status: status, body: body})
+}
+
+func newTestCloudWatchLogs(t *testing.T, endpoint string) *CloudWatchLogs {
+	l, err := NewCloudWatchLogs(CloudWatchLogsConfig{
+		LogGroup:    "traces",
+		LogStream:   "host-1",
+		Region:      "us-west-2",
+		Endpoint:    endpoint,
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	})
+	assert.Nil(t, err)
+	return l
+}
+
+func TestCloudWatchLogsExport(t *testing.T) {
+	s := newLogsStandIn(t)
+	defer s.server.Close()
+	l := newTestCloudWatchLogs(t, s.server.URL)
+
+	unprocessed, err := l.Export(context.Background(), []string{testSegment})
+
+	assert.Nil(t, err)
+	assert.Empty(t, unprocessed)
+	assert.Equal(t, []string{"CreateLogStream", "PutLogEvents"}, s.actions)
+	assert.Len(t, s.puts, 1)
+	put := s.puts[0]
+	assert.Equal(t, "traces", put.LogGroupName)
+	assert.Equal(t, "host-1", put.LogStreamName)
+	assert.Equal(t, "", put.SequenceToken)
+	assert.Len(t, put.LogEvents, 4)
+	for i := 1; i < len(put.LogEvents); i++ {
+		assert.True(t, put.LogEvents[i-1].Timestamp <= put.LogEvents[i].Timestamp)
+	}
+	assert.Equal(t, int64(1461096053375), put.LogEvents[0].Timestamp)
+
+	var root map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(put.LogEvents[0].Message), &root))
+	assert.Equal(t, "5759e988bd862e3fe1be46a994272793", root["traceId"])
+	assert.Equal(t, "70de5b6f19ff9a0a", root["spanId"])
+	assert.Equal(t, "web", root["name"])
+	assert.Equal(t, "SERVER", root["kind"])
+	assert.Equal(t, map[string]interface{}{"code": "ERROR", "message": "order is null"}, root["status"])
+	assert.Equal(t, "web", root["resource"].(map[string]interface{})["attributes"].(map[string]interface{})["service.name"])
+	attrs := root["attributes"].(map[string]interface{})
+	assert.Equal(t, "GET", attrs["http.request.method"])
+	assert.Equal(t, float64(500), attrs["http.response.status_code"])
+	assert.Equal(t, "alice", attrs["customer"])
+	assert.Equal(t, float64(29020000), root["durationNano"])
+
+	// The sequence token of the previous call is sent, and the stream is not created again.
+	_, err = l.Export(context.Background(), []string{testSegment})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"CreateLogStream", "PutLogEvents", "PutLogEvents"}, s.actions)
+	assert.Equal(t, "token-1", s.puts[1].SequenceToken)
+}
+
+func TestCloudWatchLogsExistingStream(t *testing.T) {
+	s := newLogsStandIn(t)
+	defer s.server.Close()
+	s.respond("CreateLogStream", http.StatusBadRequest, `{"__type":"com.amazonaws.logs#ResourceAlreadyExistsException","message":"The specified log stream already exists"}`)
+	l := newTestCloudWatchLogs(t, s.server.URL)
+
+	_, err := l.Export(context.Background(), []string{testSegment})
+
+	assert.Nil(t, err)
+	assert.Len(t, s.puts, 1)
+}
+
+func TestCloudWatchLogsInvalidSequenceToken(t *testing.T) {
+	s := newLogsStandIn(t)
+	defer s.server.Close()
+	s.respond("PutLogEvents", http.StatusBadRequest, `{"__type":"InvalidSequenceTokenException","message":"invalid token","expectedSequenceToken":"expected"}`)
+	l := newTestCloudWatchLogs(t, s.server.URL)
+
+	_, err := l.Export(context.Background(), []string{testSegment})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"CreateLogStream", "PutLogEvents", "PutLogEvents"}, s.actions)
+	assert.Len(t, s.puts, 1)
+	assert.Equal(t, "expected", s.puts[0].SequenceToken)
+}
+
+func TestCloudWatchLogsDeletedStream(t *testing.T) {
+	s := newLogsStandIn(t)
+	defer s.server.Close()
+	l := newTestCloudWatchLogs(t, s.server.URL)
+	_, err := l.Export(context.Background(), []string{testSegment})
+	assert.Nil(t, err)
+	s.respond("PutLogEvents", http.StatusBadRequest, `{"__type":"ResourceNotFoundException","message":"The specified log stream does not exist."}`)
+
+	_, err = l.Export(context.Background(), []string{testSegment})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"CreateLogStream", "PutLogEvents", "PutLogEvents", "CreateLogStream", "PutLogEvents"}, s.actions)
+	assert.Equal(t, "", s.puts[1].SequenceToken)
+}
+
+func TestCloudWatchLogsRejectedEvents(t *testing.T) {
+	s := newLogsStandIn(t)
+	defer s.server.Close()
+	s.respond("PutLogEvents", http.StatusOK, `{"nextSequenceToken":"next","rejectedLogEventsInfo":{"tooOldLogEventEndIndex":1}}`)
+	l := newTestCloudWatchLogs(t, s.server.URL)
+	recent := `{"trace_id":"1-5759e988-bd862e3fe1be46a994272794","id":"80de5b6f19ff9a0a","name":"api","start_time":1461096054,"end_time":1461096055}`
+
+	unprocessed, err := l.Export(context.Background(), []string{recent, testSegment, "{"})
+
+	assert.Nil(t, err)
+	assert.Len(t, unprocessed, 2)
+	assert.Equal(t, "70de5b6f19ff9a0a", *unprocessed[0].Id)
+	assert.Equal(t, errorCodeRejected, *unprocessed[0].ErrorCode)
+	assert.Equal(t, errorCodeInvalidSegment, *unprocessed[1].ErrorCode)
+	assert.Equal(t, "next", l.sequenceToken)
+}
+
+func TestCloudWatchLogsServiceError(t *testing.T) {
+	s := newLogsStandIn(t)
+	defer s.server.Close()
+	s.respond("PutLogEvents", http.StatusServiceUnavailable, `{"__type":"ServiceUnavailableException","message":"try again"}`)
+	l := newTestCloudWatchLogs(t, s.server.URL)
+
+	unprocessed, err := l.Export(context.Background(), []string{testSegment})
+
+	assert.Nil(t, unprocessed)
+	assert.EqualError(t, err, "cloudwatchlogs exporter: ServiceUnavailableException (status 503): try again")
+	var se *StatusError
+	assert.True(t, errors.As(err, &se))
+	assert.EqualValues(t, http.StatusServiceUnavailable, se.StatusCode)
+}
+
+func TestSplitLogEvents(t *testing.T) {
+	event := func(timestamp int64, size int) logEvent {
+		return logEvent{inputLogEvent: inputLogEvent{Timestamp: timestamp, Message: strings.Repeat("x", size)}}
+	}
+	var events []logEvent
+	for i := 0; i < maxLogEventsPerCall+1; i++ {
+		events = append(events, event(0, 1))
+	}
+	batches := splitLogEvents(events)
+	assert.Len(t, batches, 2)
+	assert.Len(t, batches[0], maxLogEventsPerCall)
+
+	large := maxLogEventBytes - logEventOverhead
+	events = []logEvent{event(0, large), event(0, large), event(0, large), event(0, large), event(0, large)}
+	batches = splitLogEvents(events)
+	assert.Len(t, batches, 2)
+	assert.Equal(t, 4, len(batches[0]))
+
+	day := int64(24 * time.Hour / time.Millisecond)
+	events = []logEvent{event(0, 1), event(day, 1), event(day+1, 1)}
+	batches = splitLogEvents(events)
+	assert.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+
+	assert.Empty(t, splitLogEvents(nil))
+}
+
+func TestNewCloudWatchLogsInvalidConfig(t *testing.T) {
+	creds := credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")
+	_, err := NewCloudWatchLogs(CloudWatchLogsConfig{LogStream: "host-1", Region: "us-west-2", Credentials: creds})
+	assert.NotNil(t, err)
+	_, err = NewCloudWatchLogs(CloudWatchLogsConfig{LogGroup: "traces", LogStream: "host-1", Credentials: creds})
+	assert.NotNil(t, err)
+	_, err = NewCloudWatchLogs(CloudWatchLogsConfig{LogGroup: "traces", LogStream: "host-1", Region: "us-west-2", Credentials: creds, Endpoint: "logs"})
+	assert.EqualError(t, err, `cloudwatchlogs exporter: invalid endpoint "logs"`)
+
+	l, err := NewCloudWatchLogs(CloudWatchLogsConfig{LogGroup: "traces", LogStream: "host-1", Region: "us-west-2", Credentials: creds})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://logs.us-west-2.amazonaws.com", l.endpoint)
+}
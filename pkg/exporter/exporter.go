@@ -0,0 +1,43 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+// Package exporter sends batches of segment documents to destinations such as AWS X-Ray.
+package exporter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+)
+
+// Exporter sends batches of segment documents to a destination.
+type Exporter interface {
+	// Export sends docs, and returns the segments the destination did not accept.
+	Export(ctx context.Context, docs []string) ([]types.UnprocessedTraceSegment, error)
+
+	// Close flushes the documents held by the exporter and releases its resources.
+	Close() error
+}
+
+// StatusError is returned when a destination answers a request with an unsuccessful HTTP status.
+type StatusError struct {
+	// Exporter which sent the request, such as webhook.
+	Exporter string
+
+	StatusCode int
+	Status     string
+
+	// Beginning of the response body.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v exporter: endpoint returned %v: %v", e.Exporter, e.Status, e.Body)
+}
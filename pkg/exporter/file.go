@@ -0,0 +1,266 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	"github.com/aws/aws-xray-daemon/pkg/spool"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	log "github.com/cihub/seelog"
+)
+
+// Prefix of the names of exported files.
+const filePrefix = "segments-"
+
+// Extension of exported files.
+const fileExtension = ".ndjson"
+
+// Extension appended to compressed files.
+const gzipExtension = ".gz"
+
+// Prefix of the telemetry counters of file exporters.
+const fileCounterName = "exporter.file"
+
+// FileConfig describes the files written by a File exporter.
+type FileConfig struct {
+	// Directory holding the files, created if missing.
+	Directory string
+
+	// Size of the documents of a file after which a new file is started, 0 for no limit.
+	MaxFileBytes int64
+
+	// Age of a file after which a new file is started, 0 for no limit.
+	RotateInterval time.Duration
+
+	// Gzip, if true, compresses files.
+	Gzip bool
+
+	// Number of files kept, the oldest being removed first, 0 keeps every file.
+	MaxFiles int
+}
+
+// File exports segment documents to rotating newline delimited JSON files.
+// The file being written has a .tmp extension, which is removed once the file is rotated.
+type File struct {
+	config FileConfig
+
+	// Rotated files, named and sealed like those of the spool.
+	files spool.Files
+
+	lock sync.Mutex
+
+	// File being written, nil if none.
+	current *os.File
+
+	// Compressor writing to current, nil without compression.
+	gz *gzip.Writer
+
+	// Bytes of documents written to current file, before compression.
+	currentBytes int64
+
+	// Time current file was created.
+	openedAt time.Time
+
+	// Channel closed to stop time-based rotation.
+	stop chan struct{}
+
+	// Channel closed once time-based rotation stopped.
+	stopped chan struct{}
+
+	// Returns current time, replaced in tests.
+	now func() time.Time
+}
+
+// NewFile returns a File exporter writing files as described by c.
+// Files left being written by a previous run are rotated.
+func NewFile(c FileConfig) (*File, error) {
+	if c.Directory == "" {
+		return nil, fmt.Errorf("file exporter: directory is empty")
+	}
+	if err := os.MkdirAll(c.Directory, 0700); err != nil {
+		return nil, fmt.Errorf("file exporter: unable to create directory %v: %v", c.Directory, err)
+	}
+	f := &File{
+		config: c,
+		files:  spool.Files{Dir: c.Directory, Prefix: filePrefix, Extension: fileExtension},
+		now:    time.Now,
+	}
+	if c.Gzip {
+		f.files.Extension += gzipExtension
+	}
+	if err := f.recover(); err != nil {
+		return nil, err
+	}
+	if c.RotateInterval > 0 {
+		f.stop = make(chan struct{})
+		f.stopped = make(chan struct{})
+		go f.rotateOnInterval()
+	}
+	return f, nil
+}
+
+// Export appends docs to the file being written, one document per line, and rotates the file once full.
+// Documents which are not valid JSON are skipped. Docs written are not returned as failed if the rotation fails.
+func (f *File) Export(ctx context.Context, docs []string) ([]types.UnprocessedTraceSegment, error) {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		// Compacting guarantees a document spans a single line.
+		if err := json.Compact(&buf, []byte(doc)); err != nil {
+			log.Warnf("file exporter: skipping invalid segment document: %v", err)
+			continue
+		}
+		buf.WriteByte('\n')
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.current != nil && f.expired() {
+		f.tryRotate()
+	}
+	if f.current == nil {
+		if err := f.open(); err != nil {
+			return nil, err
+		}
+	}
+	var w io.Writer = f.current
+	if f.gz != nil {
+		w = f.gz
+	}
+	n, err := w.Write(buf.Bytes())
+	f.currentBytes += int64(n)
+	if err != nil {
+		return nil, fmt.Errorf("file exporter: unable to write %v: %v", f.current.Name(), err)
+	}
+	if f.config.MaxFileBytes > 0 && f.currentBytes >= f.config.MaxFileBytes {
+		f.tryRotate()
+	}
+	return nil, nil
+}
+
+// Close rotates the file being written.
+func (f *File) Close() error {
+	if f.stop != nil {
+		close(f.stop)
+		<-f.stopped
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.current == nil {
+		return nil
+	}
+	return f.rotate()
+}
+
+// rotateOnInterval rotates the file being written once it reaches the rotate interval, until stopped.
+func (f *File) rotateOnInterval() {
+	defer close(f.stopped)
+	period := time.Second
+	if f.config.RotateInterval < period {
+		period = f.config.RotateInterval
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+		f.lock.Lock()
+		if f.current != nil && f.expired() {
+			f.tryRotate()
+		}
+		f.lock.Unlock()
+	}
+}
+
+// expired returns true if the file being written reached the rotate interval. Must be called with the lock held.
+func (f *File) expired() bool {
+	return f.config.RotateInterval > 0 && f.now().Sub(f.openedAt) >= f.config.RotateInterval
+}
+
+// recover rotates files left being written by a previous run, and applies the retention count.
+func (f *File) recover() error {
+	if err := f.files.Recover(); err != nil {
+		return fmt.Errorf("file exporter: %v", err)
+	}
+	return f.prune()
+}
+
+func (f *File) open() error {
+	now := f.now()
+	file, err := f.files.Create(now)
+	if err != nil {
+		return fmt.Errorf("file exporter: %v", err)
+	}
+	f.current = file
+	if f.config.Gzip {
+		f.gz = gzip.NewWriter(file)
+	}
+	f.currentBytes = 0
+	f.openedAt = now
+	return nil
+}
+
+// rotate closes the file being written, removes its temporary extension and applies the retention count.
+// Must be called with the lock held.
+func (f *File) rotate() error {
+	name := f.current.Name()
+	var err error
+	if f.gz != nil {
+		err = f.gz.Close()
+		f.gz = nil
+	}
+	if sealErr := spool.Seal(f.current); err == nil {
+		err = sealErr
+	}
+	f.current = nil
+	if err != nil {
+		return fmt.Errorf("file exporter: unable to rotate %v: %v", name, err)
+	}
+	return f.prune()
+}
+
+// tryRotate rotates the file being written, logging and counting a failure. Documents written so far stay
+// in the file, and the next documents go to a new file. Must be called with the lock held.
+func (f *File) tryRotate() {
+	if err := f.rotate(); err != nil {
+		log.Errorf("%v", err)
+		telemetry.T.Count(fileCounterName+".rotate-failed", 1)
+	}
+}
+
+// prune removes the oldest rotated files beyond the retention count.
+func (f *File) prune() error {
+	if f.config.MaxFiles == 0 {
+		return nil
+	}
+	names, err := f.files.Sealed()
+	if err != nil {
+		return fmt.Errorf("file exporter: %v", err)
+	}
+	for len(names) > f.config.MaxFiles {
+		if err := os.Remove(names[0]); err != nil {
+			return fmt.Errorf("file exporter: unable to remove %v: %v", names[0], err)
+		}
+		log.Debugf("file exporter: removed %v beyond retention of %v files", names[0], f.config.MaxFiles)
+		names = names[1:]
+	}
+	return nil
+}
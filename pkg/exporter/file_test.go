@@ -0,0 +1,166 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestFile(t *testing.T, c FileConfig) *File {
+	dir, err := ioutil.TempDir("", "exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Directory = filepath.Join(dir, "segments")
+	f, err := NewFile(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func exportedFiles(f *File) []string {
+	names, _ := f.files.Sealed()
+	return names
+}
+
+func TestNewFileEmptyDirectory(t *testing.T) {
+	f, err := NewFile(FileConfig{})
+
+	assert.Nil(t, f)
+	assert.EqualError(t, err, "file exporter: directory is empty")
+}
+
+func TestFileExportAndClose(t *testing.T) {
+	f := getTestFile(t, FileConfig{})
+	defer os.RemoveAll(filepath.Dir(f.config.Directory))
+
+	_, err := f.Export(context.Background(), []string{"{\"id\": \"1\",\n \"name\": \"web\"}", "invalid", "{\"id\":\"2\"}"})
+	assert.Nil(t, err)
+	assert.Empty(t, exportedFiles(f), "File being written should not be visible")
+
+	assert.Nil(t, f.Close())
+
+	files := exportedFiles(f)
+	assert.Equal(t, 1, len(files))
+	content, _ := ioutil.ReadFile(files[0])
+	assert.Equal(t, "{\"id\":\"1\",\"name\":\"web\"}\n{\"id\":\"2\"}\n", string(content))
+}
+
+func TestFileRotatesOnSize(t *testing.T) {
+	f := getTestFile(t, FileConfig{MaxFileBytes: 20})
+	defer os.RemoveAll(filepath.Dir(f.config.Directory))
+
+	f.Export(context.Background(), []string{"{\"id\":\"1\"}"})
+	assert.Empty(t, exportedFiles(f))
+	f.Export(context.Background(), []string{"{\"id\":\"2\"}"})
+	assert.Equal(t, 1, len(exportedFiles(f)), "File is rotated once it holds the maximum size")
+	f.Export(context.Background(), []string{"{\"id\":\"3\"}"})
+	f.Close()
+
+	assert.Equal(t, 2, len(exportedFiles(f)))
+}
+
+func TestFileRotateFailureKeepsExportedDocuments(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	f := getTestFile(t, FileConfig{MaxFileBytes: 20})
+	defer os.RemoveAll(filepath.Dir(f.config.Directory))
+	f.Export(context.Background(), []string{"{\"id\":\"1\"}"})
+	failed := f.current.Name()
+	// A directory in place of the rotated file makes the rotation fail.
+	os.Mkdir(strings.TrimSuffix(failed, ".tmp"), 0700)
+
+	_, err := f.Export(context.Background(), []string{"{\"id\":\"2\"}"})
+
+	assert.Nil(t, err, "Documents written are not exported again")
+	assert.EqualValues(t, 1, telemetry.T.Counter("exporter.file.rotate-failed"))
+	content, _ := ioutil.ReadFile(failed)
+	assert.Equal(t, "{\"id\":\"1\"}\n{\"id\":\"2\"}\n", string(content))
+	_, err = f.Export(context.Background(), []string{"{\"id\":\"3\"}"})
+	assert.Nil(t, err)
+	assert.NotEqual(t, failed, f.current.Name(), "Next documents go to a new file")
+	f.Close()
+}
+
+func TestFileRotatesOnInterval(t *testing.T) {
+	f := getTestFile(t, FileConfig{RotateInterval: time.Hour})
+	defer os.RemoveAll(filepath.Dir(f.config.Directory))
+	now := time.Unix(1500000000, 0)
+	f.now = func() time.Time { return now }
+
+	f.Export(context.Background(), []string{"{\"id\":\"1\"}"})
+	now = now.Add(59 * time.Minute)
+	f.Export(context.Background(), []string{"{\"id\":\"2\"}"})
+	assert.Empty(t, exportedFiles(f))
+
+	now = now.Add(time.Minute)
+	f.Export(context.Background(), []string{"{\"id\":\"3\"}"})
+	assert.Equal(t, 1, len(exportedFiles(f)), "File is rotated once it reaches the rotate interval")
+	f.Close()
+
+	files := exportedFiles(f)
+	assert.Equal(t, 2, len(files))
+	content, _ := ioutil.ReadFile(files[0])
+	assert.Equal(t, "{\"id\":\"1\"}\n{\"id\":\"2\"}\n", string(content))
+}
+
+func TestFileGzip(t *testing.T) {
+	f := getTestFile(t, FileConfig{Gzip: true})
+	defer os.RemoveAll(filepath.Dir(f.config.Directory))
+
+	f.Export(context.Background(), []string{"{\"id\":\"1\"}", "{\"id\":\"2\"}"})
+	f.Close()
+
+	files := exportedFiles(f)
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, ".gz", filepath.Ext(files[0]))
+	file, _ := os.Open(files[0])
+	defer file.Close()
+	r, err := gzip.NewReader(file)
+	assert.Nil(t, err)
+	content, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "{\"id\":\"1\"}\n{\"id\":\"2\"}\n", string(content))
+}
+
+func TestFileRetention(t *testing.T) {
+	f := getTestFile(t, FileConfig{MaxFileBytes: 1, MaxFiles: 2})
+	defer os.RemoveAll(filepath.Dir(f.config.Directory))
+
+	for _, doc := range []string{"{\"id\":\"1\"}", "{\"id\":\"2\"}", "{\"id\":\"3\"}"} {
+		f.Export(context.Background(), []string{doc})
+	}
+
+	files := exportedFiles(f)
+	assert.Equal(t, 2, len(files), "Oldest file is removed beyond the retention count")
+	content, _ := ioutil.ReadFile(files[0])
+	assert.Equal(t, "{\"id\":\"2\"}\n", string(content))
+}
+
+func TestFileRecoversFileBeingWritten(t *testing.T) {
+	f := getTestFile(t, FileConfig{})
+	defer os.RemoveAll(filepath.Dir(f.config.Directory))
+	f.Export(context.Background(), []string{"{\"id\":\"1\"}"})
+
+	// Simulates a restart without closing the exporter.
+	recovered, err := NewFile(FileConfig{Directory: f.config.Directory})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(exportedFiles(recovered)))
+}
@@ -0,0 +1,302 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	"github.com/aws/aws-xray-daemon/pkg/gateway"
+	log "github.com/cihub/seelog"
+)
+
+// Number of points of every gateway on the hash ring, spreading traces evenly across gateways.
+const virtualNodes = 128
+
+// Error code of the documents no gateway received while others of the batch were delivered. It is
+// transient, so the documents are resubmitted without sending the delivered ones again.
+const errorCodeUnavailable = "ServiceUnavailable"
+
+// ForwardConfig describes the gateway daemons a Forward exporter sends batches to.
+type ForwardConfig struct {
+	// Base URLs of the gateways, such as https://gateway:2002.
+	Gateways []string
+
+	// Token sent as bearer authorization.
+	Token string
+
+	// ConsistentHashing, if true, sends all segments of a trace to the same gateway, chosen by trace id.
+	// Batches are otherwise sent to gateways in turn.
+	ConsistentHashing bool
+
+	// Timeout of a request, 0 for no timeout.
+	Timeout time.Duration
+}
+
+// Forward exports batches of segment documents to gateway daemons, which upload them to X-Ray,
+// as gzip compressed newline delimited JSON. A batch a gateway fails to receive is sent to the next gateway.
+type Forward struct {
+	config ForwardConfig
+	client *http.Client
+
+	// Ring of gateways keyed by trace id, nil without consistent hashing.
+	ring *hashRing
+
+	// Number of batches sent to gateways in turn.
+	turn uint32
+}
+
+// NewForward returns an exporter sending batches to the gateways described by c.
+func NewForward(c ForwardConfig) (*Forward, error) {
+	if len(c.Gateways) == 0 {
+		return nil, errors.New("forward exporter: no gateway")
+	}
+	for i, g := range c.Gateways {
+		u, err := url.Parse(g)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("forward exporter: invalid gateway %q", g)
+		}
+		c.Gateways[i] = strings.TrimSuffix(g, "/")
+	}
+	f := &Forward{
+		config: c,
+		client: &http.Client{Timeout: c.Timeout},
+	}
+	if c.ConsistentHashing {
+		f.ring = newHashRing(c.Gateways)
+	}
+	return f, nil
+}
+
+// Export sends docs to their gateway, one request per gateway. Docs are segment documents, or gateway
+// envelopes carrying the header of the segment. Documents which are not valid JSON are returned as unprocessed.
+// If some gateways received their documents but not others, the documents not received are returned as
+// unprocessed, so only they are sent again; an error is returned if no gateway received its documents.
+func (f *Forward) Export(ctx context.Context, docs []string) ([]types.UnprocessedTraceSegment, error) {
+	var unprocessed []types.UnprocessedTraceSegment
+	batches := make(map[int]*bytes.Buffer)
+	// Segment ids of the documents sent to every gateway.
+	ids := make(map[int][]string)
+	turn := int(atomic.AddUint32(&f.turn, 1) % uint32(len(f.config.Gateways)))
+	for _, doc := range docs {
+		var s struct {
+			TraceID string `json:"trace_id"`
+			ID      string `json:"id"`
+			Segment *struct {
+				TraceID string `json:"trace_id"`
+				ID      string `json:"id"`
+			} `json:"segment"`
+		}
+		if err := json.Unmarshal([]byte(doc), &s); err != nil {
+			log.Debugf("forward exporter: skipping invalid segment document: %v", err)
+			unprocessed = append(unprocessed, unprocessedSegment(doc, errorCodeInvalidSegment, err.Error()))
+			continue
+		}
+		if s.Segment != nil {
+			s.TraceID = s.Segment.TraceID
+			s.ID = s.Segment.ID
+		}
+		g := turn
+		if f.ring != nil {
+			g = f.ring.owner(s.TraceID)
+		}
+		batch, ok := batches[g]
+		if !ok {
+			batch = new(bytes.Buffer)
+			batches[g] = batch
+		}
+		// Compacting guarantees a document spans a single line.
+		json.Compact(batch, []byte(doc))
+		batch.WriteByte('\n')
+		ids[g] = append(ids[g], s.ID)
+	}
+	gateways := make([]int, 0, len(batches))
+	for g := range batches {
+		gateways = append(gateways, g)
+	}
+	sort.Ints(gateways)
+	delivered := false
+	failed := make(map[int]error)
+	var err error
+	for _, g := range gateways {
+		body, cerr := compress(batches[g].Bytes())
+		if cerr != nil {
+			return nil, fmt.Errorf("forward exporter: %w", cerr)
+		}
+		if err = f.sendWithFailover(ctx, g, body); err != nil {
+			failed[g] = err
+		} else {
+			delivered = true
+		}
+	}
+	if !delivered && len(failed) > 0 {
+		return nil, err
+	}
+	for _, g := range gateways {
+		if failed[g] == nil {
+			continue
+		}
+		log.Warnf("Forwarding %d segments failed on every gateway, returning them as unprocessed: %v", len(ids[g]), failed[g])
+		for _, id := range ids[g] {
+			unprocessed = append(unprocessed, types.UnprocessedTraceSegment{
+				Id:        aws.String(id),
+				ErrorCode: aws.String(errorCodeUnavailable),
+				Message:   aws.String(failed[g].Error()),
+			})
+		}
+	}
+	return unprocessed, nil
+}
+
+// Close releases idle connections to the gateways.
+func (f *Forward) Close() error {
+	f.client.CloseIdleConnections()
+	return nil
+}
+
+// sendWithFailover sends body to gateway g, then to the following gateways in turn until one receives it.
+// Following gateways are the same for every trace of g, so traces stay together during failover.
+func (f *Forward) sendWithFailover(ctx context.Context, g int, body []byte) error {
+	var err error
+	for i := 0; i < len(f.config.Gateways); i++ {
+		gateway := f.config.Gateways[(g+i)%len(f.config.Gateways)]
+		if err = f.send(ctx, gateway, body); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if i+1 < len(f.config.Gateways) {
+			log.Warnf("Forwarding to gateway %v failed, trying the next gateway: %v", gateway, err)
+		}
+	}
+	return err
+}
+
+func (f *Forward) send(ctx context.Context, g string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g+gateway.Path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("forward exporter: %w", err)
+	}
+	req.Header.Set("Content-Type", gateway.ContentType)
+	req.Header.Set("Content-Encoding", "gzip")
+	if f.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.config.Token)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("forward exporter: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &StatusError{
+			Exporter:   "forward",
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       string(bytes.TrimSpace(msg)),
+		}
+	}
+	var r gateway.Response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err == nil && r.Dropped > 0 {
+		log.Warnf("Gateway %v dropped %d of %d forwarded segments", g, r.Dropped, r.Accepted+r.Dropped)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// compress returns body compressed with gzip.
+func compress(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(body); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// hashRing maps keys to gateways by consistent hashing, so adding or removing a gateway moves few traces.
+type hashRing struct {
+	// Hashes of the points of the ring, sorted.
+	points []uint32
+
+	// Index of the gateway owning every point.
+	owners []int
+}
+
+func newHashRing(gateways []string) *hashRing {
+	type point struct {
+		hash  uint32
+		owner int
+	}
+	points := make([]point, 0, len(gateways)*virtualNodes)
+	for i, g := range gateways {
+		for v := 0; v < virtualNodes; v++ {
+			points = append(points, point{hash: hashKey(g + "#" + strconv.Itoa(v)), owner: i})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+	r := &hashRing{
+		points: make([]uint32, len(points)),
+		owners: make([]int, len(points)),
+	}
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+// owner returns the index of the gateway owning key: that of the first point at or after the hash of key.
+func (r *hashRing) owner(key string) int {
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// hashKey returns the FNV-1a hash of key, mixed so keys differing only by their last characters,
+// such as consecutive trace ids, spread across the ring.
+func hashKey(key string) uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return uint32(x)
+}
@@ -0,0 +1,254 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-xray-daemon/pkg/gateway"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	"github.com/stretchr/testify/assert"
+)
+
+// testGateway is a gateway server recording the documents it receives.
+type testGateway struct {
+	lock    sync.Mutex
+	docs    []string
+	headers []tracesegment.Header
+	server  *httptest.Server
+}
+
+func newTestGateway(token string) *testGateway {
+	g := &testGateway{}
+	s, _ := gateway.NewServer(gateway.Config{Address: "127.0.0.1:0", Token: token, MaxRequestBytes: 1 << 20}, func(header tracesegment.Header, doc []byte) bool {
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		g.docs = append(g.docs, string(doc))
+		g.headers = append(g.headers, header)
+		return true
+	})
+	g.server = httptest.NewServer(s.Handler)
+	return g
+}
+
+func (g *testGateway) received() []string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return append([]string(nil), g.docs...)
+}
+
+func traceSegment(trace int, id int) string {
+	return fmt.Sprintf(`{"trace_id": "1-5759e988-%024x", "id": "%016x"}`, trace, id)
+}
+
+func TestForwardToGateway(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	g := newTestGateway("secret")
+	defer g.server.Close()
+	f, err := NewForward(ForwardConfig{Gateways: []string{g.server.URL + "/"}, Token: "secret"})
+	assert.Nil(t, err)
+
+	unprocessed, err := f.Export(context.Background(), []string{traceSegment(1, 1), "invalid", traceSegment(2, 2)})
+
+	assert.Nil(t, err)
+	assert.Len(t, unprocessed, 1)
+	assert.Equal(t, []string{
+		`{"trace_id":"1-5759e988-000000000000000000000001","id":"0000000000000001"}`,
+		`{"trace_id":"1-5759e988-000000000000000000000002","id":"0000000000000002"}`,
+	}, g.received())
+	assert.Nil(t, f.Close())
+}
+
+func TestForwardEnvelope(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	gateways := []*testGateway{newTestGateway(""), newTestGateway("")}
+	urls := make([]string, len(gateways))
+	for i, g := range gateways {
+		defer g.server.Close()
+		urls[i] = g.server.URL
+	}
+	f, err := NewForward(ForwardConfig{Gateways: urls, ConsistentHashing: true})
+	assert.Nil(t, err)
+	header := tracesegment.Header{Format: "json", Version: 1, Tenant: "payments", Priority: true}
+	var docs []string
+	for trace := 0; trace < 20; trace++ {
+		raw := []byte(traceSegment(trace, 1))
+		docs = append(docs, gateway.Wrap(&tracesegment.TraceSegment{Raw: &raw, Header: header}))
+		// The bare segment of the trace reaches the gateway of its envelope.
+		docs = append(docs, traceSegment(trace, 2))
+	}
+
+	unprocessed, err := f.Export(context.Background(), docs)
+
+	assert.Nil(t, err)
+	assert.Empty(t, unprocessed)
+	for _, g := range gateways {
+		traces := make(map[string]int)
+		for i, doc := range g.docs {
+			traces[doc[:48]]++
+			if strings.Contains(doc, `"id":"0000000000000001"`) {
+				assert.Equal(t, header, g.headers[i])
+			} else {
+				assert.Equal(t, tracesegment.Header{}, g.headers[i])
+			}
+		}
+		for trace, n := range traces {
+			assert.Equal(t, 2, n, "Both segments of trace %v reach the same gateway", trace)
+		}
+	}
+}
+
+func TestForwardUnauthorized(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	g := newTestGateway("secret")
+	defer g.server.Close()
+	f, err := NewForward(ForwardConfig{Gateways: []string{g.server.URL}, Token: "guess"})
+	assert.Nil(t, err)
+
+	_, err = f.Export(context.Background(), []string{traceSegment(1, 1)})
+
+	se, ok := err.(*StatusError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusUnauthorized, se.StatusCode)
+	assert.Empty(t, g.received())
+}
+
+func TestForwardConsistentHashing(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	gateways := []*testGateway{newTestGateway(""), newTestGateway(""), newTestGateway("")}
+	urls := make([]string, len(gateways))
+	for i, g := range gateways {
+		defer g.server.Close()
+		urls[i] = g.server.URL
+	}
+	f, err := NewForward(ForwardConfig{Gateways: urls, ConsistentHashing: true})
+	assert.Nil(t, err)
+
+	// Segments of a trace sent in different batches reach the same gateway.
+	var first, second []string
+	for trace := 0; trace < 300; trace++ {
+		first = append(first, traceSegment(trace, 1))
+		second = append(second, traceSegment(trace, 2))
+	}
+	_, err = f.Export(context.Background(), first)
+	assert.Nil(t, err)
+	_, err = f.Export(context.Background(), second)
+	assert.Nil(t, err)
+
+	for _, g := range gateways {
+		docs := g.received()
+		assert.True(t, len(docs) > 2*300/len(gateways)/2, "Traces are spread across gateways")
+		traces := make(map[string]int)
+		for _, doc := range docs {
+			traces[doc[:48]]++
+		}
+		for trace, n := range traces {
+			assert.Equal(t, 2, n, "Both segments of trace %v reach the same gateway", trace)
+		}
+	}
+}
+
+func TestForwardFailsOverToNextGateway(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := newTestGateway("")
+	defer up.server.Close()
+	f, err := NewForward(ForwardConfig{Gateways: []string{down.URL, up.server.URL}, ConsistentHashing: true})
+	assert.Nil(t, err)
+
+	var docs []string
+	for trace := 0; trace < 20; trace++ {
+		docs = append(docs, traceSegment(trace, 1))
+	}
+	_, err = f.Export(context.Background(), docs)
+
+	assert.Nil(t, err)
+	assert.Len(t, up.received(), len(docs))
+}
+
+func TestForwardPartialFailureReturnsUndeliveredDocuments(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	up := newTestGateway("")
+	defer up.server.Close()
+	// Receives the first batch, then fails.
+	var requests int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		up.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+	f, err := NewForward(ForwardConfig{Gateways: []string{flaky.URL, flaky.URL}, ConsistentHashing: true})
+	assert.Nil(t, err)
+
+	var docs []string
+	for trace := 0; trace < 20; trace++ {
+		docs = append(docs, traceSegment(trace, trace))
+	}
+	unprocessed, err := f.Export(context.Background(), docs)
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, up.received())
+	assert.NotEmpty(t, unprocessed)
+	assert.Equal(t, len(docs), len(up.received())+len(unprocessed), "Every document is either delivered or returned")
+	for _, u := range unprocessed {
+		assert.Equal(t, "ServiceUnavailable", *u.ErrorCode)
+		for _, doc := range up.received() {
+			assert.NotContains(t, doc, `"id":"`+*u.Id+`"`, "Delivered documents are not returned")
+		}
+	}
+}
+
+func TestForwardAllGatewaysDown(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	f, err := NewForward(ForwardConfig{Gateways: []string{down.URL, down.URL}})
+	assert.Nil(t, err)
+
+	_, err = f.Export(context.Background(), []string{traceSegment(1, 1)})
+
+	assert.EqualError(t, err, "forward exporter: endpoint returned 503 Service Unavailable: unavailable")
+}
+
+func TestHashRingStableOwner(t *testing.T) {
+	r := newHashRing([]string{"http://a", "http://b", "http://c"})
+	assert.Equal(t, r.owner("1-5759e988-bd862e3fe1be46a994272793"), r.owner("1-5759e988-bd862e3fe1be46a994272793"))
+
+	// Removing a gateway only moves the traces it owned.
+	smaller := newHashRing([]string{"http://a", "http://b"})
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("trace-%d", i)
+		if owner := r.owner(key); owner != 2 {
+			assert.Equal(t, owner, smaller.owner(key))
+		}
+	}
+}
+
+func TestNewForwardInvalidConfig(t *testing.T) {
+	_, err := NewForward(ForwardConfig{})
+	assert.EqualError(t, err, "forward exporter: no gateway")
+	_, err = NewForward(ForwardConfig{Gateways: []string{"gateway:2002"}})
+	assert.EqualError(t, err, `forward exporter: invalid gateway "gateway:2002"`)
+}
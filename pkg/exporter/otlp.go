@@ -0,0 +1,107 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	log "github.com/cihub/seelog"
+)
+
+// Error code of the segments which cannot be converted into spans.
+const errorCodeInvalidSegment = "InvalidSegment"
+
+// OTLPConfig describes the collector an OTLP exporter sends spans to.
+type OTLPConfig struct {
+	// URL of the OTLP/HTTP traces endpoint, such as http://localhost:4318/v1/traces.
+	Endpoint string
+
+	// Headers added to every request, such as authentication headers.
+	Headers map[string]string
+
+	// Timeout of a request, 0 for no timeout.
+	Timeout time.Duration
+}
+
+// OTLP exports segment documents as OpenTelemetry spans to a collector over OTLP/HTTP, encoded as JSON.
+type OTLP struct {
+	config OTLPConfig
+	client *http.Client
+}
+
+// NewOTLP returns an exporter sending spans to the collector described by c.
+func NewOTLP(c OTLPConfig) (*OTLP, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("otlp exporter: invalid endpoint %q", c.Endpoint)
+	}
+	return &OTLP{
+		config: c,
+		client: &http.Client{Timeout: c.Timeout},
+	}, nil
+}
+
+// Export converts docs into spans, and sends them in a single request.
+// Documents which cannot be converted are returned as unprocessed.
+func (o *OTLP) Export(ctx context.Context, docs []string) ([]types.UnprocessedTraceSegment, error) {
+	converter := newSpanConverter()
+	var unprocessed []types.UnprocessedTraceSegment
+	for _, doc := range docs {
+		if err := converter.add(doc); err != nil {
+			log.Debugf("otlp exporter: unable to convert segment: %v", err)
+			unprocessed = append(unprocessed, unprocessedSegment(doc, errorCodeInvalidSegment, err.Error()))
+		}
+	}
+	if len(converter.request.ResourceSpans) == 0 {
+		return unprocessed, nil
+	}
+	body, err := converter.marshal()
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &StatusError{
+			Exporter:   "otlp",
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       string(bytes.TrimSpace(msg)),
+		}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return unprocessed, nil
+}
+
+// Close releases idle connections to the collector.
+func (o *OTLP) Close() error {
+	o.client.CloseIdleConnections()
+	return nil
+}
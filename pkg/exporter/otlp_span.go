@@ -0,0 +1,412 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Span kinds of OTLP.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+// Status code of OTLP spans which failed.
+const statusCodeError = 2
+
+// Scope of the spans converted from X-Ray segments.
+const otlpScopeName = "github.com/aws/aws-xray-daemon"
+
+// The types below follow the JSON encoding of OTLP ExportTraceServiceRequest.
+
+type otlpRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// Attribute names of the fields of http, sql and aws blocks, following OpenTelemetry semantic conventions.
+var httpRequestAttributes = map[string]string{
+	"method":     "http.request.method",
+	"url":        "url.full",
+	"user_agent": "user_agent.original",
+	"client_ip":  "client.address",
+}
+
+var httpResponseAttributes = map[string]string{
+	"status":         "http.response.status_code",
+	"content_length": "http.response.body.size",
+}
+
+var sqlAttributes = map[string]string{
+	"url":              "db.connection_string",
+	"database_type":    "db.system",
+	"database_version": "db.version",
+	"user":             "db.user",
+	"sanitized_query":  "db.statement",
+}
+
+var awsAttributes = map[string]string{
+	"operation":  "rpc.method",
+	"region":     "cloud.region",
+	"request_id": "aws.request_id",
+	"account_id": "cloud.account.id",
+}
+
+// spanConverter converts X-Ray segment documents into OTLP spans grouped by service.
+type spanConverter struct {
+	request otlpRequest
+
+	// Resource spans keyed by service name.
+	services map[string]*otlpResourceSpans
+}
+
+func newSpanConverter() *spanConverter {
+	return &spanConverter{
+		services: make(map[string]*otlpResourceSpans),
+	}
+}
+
+// add converts segment document doc and its subsegments into spans.
+func (c *spanConverter) add(doc string) error {
+	d := json.NewDecoder(strings.NewReader(doc))
+	d.UseNumber()
+	var segment map[string]interface{}
+	if err := d.Decode(&segment); err != nil {
+		return fmt.Errorf("invalid segment document: %v", err)
+	}
+	traceID, err := otlpTraceID(stringField(segment, "trace_id"))
+	if err != nil {
+		return err
+	}
+	service := stringField(segment, "name")
+	if stringField(segment, "type") == "subsegment" {
+		// Independent subsegments do not name their service.
+		service = ""
+	}
+	spans, err := convertSpans(traceID, segment, stringField(segment, "parent_id"), stringField(segment, "type") != "subsegment", nil)
+	if err != nil {
+		return err
+	}
+	rs := c.resourceSpans(service, segment)
+	rs.ScopeSpans[0].Spans = append(rs.ScopeSpans[0].Spans, spans...)
+	return nil
+}
+
+// resourceSpans returns the resource spans of service, created with the attributes of segment.
+func (c *spanConverter) resourceSpans(service string, segment map[string]interface{}) *otlpResourceSpans {
+	if rs, ok := c.services[service]; ok {
+		return rs
+	}
+	var attrs []otlpKeyValue
+	if service != "" {
+		attrs = append(attrs, stringAttribute("service.name", service))
+	}
+	if s, ok := segment["service"].(map[string]interface{}); ok {
+		if version := stringField(s, "version"); version != "" {
+			attrs = append(attrs, stringAttribute("service.version", version))
+		}
+	}
+	if origin := stringField(segment, "origin"); origin != "" {
+		attrs = append(attrs, stringAttribute("cloud.provider", "aws"), stringAttribute("cloud.platform", origin))
+	}
+	rs := &otlpResourceSpans{
+		Resource:   otlpResource{Attributes: attrs},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: otlpScopeName}}},
+	}
+	c.services[service] = rs
+	c.request.ResourceSpans = append(c.request.ResourceSpans, rs)
+	return rs
+}
+
+// convertSpans returns the span of segment s, a segment if root, followed by the spans of its subsegments.
+func convertSpans(traceID string, s map[string]interface{}, parentID string, root bool, spans []otlpSpan) ([]otlpSpan, error) {
+	id := stringField(s, "id")
+	if len(id) != 16 {
+		return nil, fmt.Errorf("invalid segment id %q", id)
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return nil, fmt.Errorf("invalid segment id %q", id)
+	}
+	start := unixNano(s["start_time"])
+	end := start
+	if _, ok := s["end_time"]; ok {
+		end = unixNano(s["end_time"])
+	}
+	span := otlpSpan{
+		TraceID:           traceID,
+		SpanID:            id,
+		ParentSpanID:      parentID,
+		Name:              stringField(s, "name"),
+		Kind:              spanKind(s, root),
+		StartTimeUnixNano: strconv.FormatInt(start, 10),
+		EndTimeUnixNano:   strconv.FormatInt(end, 10),
+		Attributes:        spanAttributes(s),
+	}
+	span.Status, span.Events = spanStatus(s, end)
+	spans = append(spans, span)
+	subsegments, _ := s["subsegments"].([]interface{})
+	for _, sub := range subsegments {
+		if m, ok := sub.(map[string]interface{}); ok {
+			var err error
+			if spans, err = convertSpans(traceID, m, id, false, spans); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return spans, nil
+}
+
+// otlpTraceID converts X-Ray trace id 1-{8 hex digits}-{24 hex digits} to a 32 hex digits OTLP trace id.
+func otlpTraceID(traceID string) (string, error) {
+	parts := strings.Split(traceID, "-")
+	if len(parts) != 3 || len(parts[1]) != 8 || len(parts[2]) != 24 {
+		return "", fmt.Errorf("invalid trace id %q", traceID)
+	}
+	id := parts[1] + parts[2]
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", fmt.Errorf("invalid trace id %q", traceID)
+	}
+	return strings.ToLower(id), nil
+}
+
+// spanKind returns server for segments, client for calls to remote and AWS services, and internal otherwise.
+func spanKind(s map[string]interface{}, root bool) int {
+	if root {
+		return spanKindServer
+	}
+	switch stringField(s, "namespace") {
+	case "remote", "aws":
+		return spanKindClient
+	}
+	return spanKindInternal
+}
+
+// spanAttributes returns the attributes of the http, sql and aws blocks, annotations and metadata of s.
+func spanAttributes(s map[string]interface{}) []otlpKeyValue {
+	var attrs []otlpKeyValue
+	if h, ok := s["http"].(map[string]interface{}); ok {
+		if req, ok := h["request"].(map[string]interface{}); ok {
+			attrs = appendAttributes(attrs, req, httpRequestAttributes, "http.request.")
+		}
+		if resp, ok := h["response"].(map[string]interface{}); ok {
+			attrs = appendAttributes(attrs, resp, httpResponseAttributes, "http.response.")
+		}
+	}
+	if sql, ok := s["sql"].(map[string]interface{}); ok {
+		attrs = appendAttributes(attrs, sql, sqlAttributes, "db.")
+	}
+	if aws, ok := s["aws"].(map[string]interface{}); ok {
+		if stringField(s, "namespace") == "aws" {
+			attrs = append(attrs, stringAttribute("rpc.system", "aws-api"), stringAttribute("rpc.service", stringField(s, "name")))
+		}
+		attrs = appendAttributes(attrs, aws, awsAttributes, "aws.")
+	}
+	if user := stringField(s, "user"); user != "" {
+		attrs = append(attrs, stringAttribute("enduser.id", user))
+	}
+	if annotations, ok := s["annotations"].(map[string]interface{}); ok {
+		attrs = appendAttributes(attrs, annotations, nil, "")
+	}
+	if metadata, ok := s["metadata"].(map[string]interface{}); ok {
+		for _, namespace := range sortedKeys(metadata) {
+			if value, err := json.Marshal(metadata[namespace]); err == nil {
+				attrs = append(attrs, stringAttribute("aws.xray.metadata."+namespace, string(value)))
+			}
+		}
+	}
+	for _, flag := range []string{"error", "fault", "throttle", "in_progress"} {
+		if b, ok := s[flag].(bool); ok && b {
+			attrs = append(attrs, otlpKeyValue{Key: "aws.xray." + flag, Value: otlpAnyValue{BoolValue: &b}})
+		}
+	}
+	return attrs
+}
+
+// appendAttributes appends the scalar fields of block, named after names or prefixed with prefix if not listed.
+func appendAttributes(attrs []otlpKeyValue, block map[string]interface{}, names map[string]string, prefix string) []otlpKeyValue {
+	for _, key := range sortedKeys(block) {
+		name, ok := names[key]
+		if !ok {
+			name = prefix + key
+		}
+		if value, ok := anyValue(block[key]); ok {
+			attrs = append(attrs, otlpKeyValue{Key: name, Value: value})
+		}
+	}
+	return attrs
+}
+
+// spanStatus returns the error status of s if it has an error or fault, along with an exception event
+// for each exception of its cause.
+func spanStatus(s map[string]interface{}, end int64) (otlpStatus, []otlpEvent) {
+	failed := false
+	for _, flag := range []string{"error", "fault"} {
+		if b, ok := s[flag].(bool); ok && b {
+			failed = true
+		}
+	}
+	var status otlpStatus
+	if failed {
+		status.Code = statusCodeError
+	}
+	cause, _ := s["cause"].(map[string]interface{})
+	exceptions, _ := cause["exceptions"].([]interface{})
+	var events []otlpEvent
+	for _, e := range exceptions {
+		exception, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var attrs []otlpKeyValue
+		if t := stringField(exception, "type"); t != "" {
+			attrs = append(attrs, stringAttribute("exception.type", t))
+		}
+		message := stringField(exception, "message")
+		if message != "" {
+			attrs = append(attrs, stringAttribute("exception.message", message))
+			if failed && status.Message == "" {
+				status.Message = message
+			}
+		}
+		events = append(events, otlpEvent{TimeUnixNano: strconv.FormatInt(end, 10), Name: "exception", Attributes: attrs})
+	}
+	return status, events
+}
+
+// anyValue converts a scalar JSON value into an OTLP value, returns false for objects, arrays and null.
+func anyValue(v interface{}) (otlpAnyValue, bool) {
+	switch t := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &t}, true
+	case bool:
+		return otlpAnyValue{BoolValue: &t}, true
+	case json.Number:
+		if _, err := t.Int64(); err == nil {
+			s := t.String()
+			return otlpAnyValue{IntValue: &s}, true
+		}
+		if f, err := t.Float64(); err == nil {
+			return otlpAnyValue{DoubleValue: &f}, true
+		}
+	}
+	return otlpAnyValue{}, false
+}
+
+func stringAttribute(key string, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+// stringField returns field key of m if it is a string, the empty string otherwise.
+func stringField(m map[string]interface{}, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+// unixNano converts X-Ray epoch seconds with microsecond precision into nanoseconds.
+func unixNano(v interface{}) int64 {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return 0
+	}
+	return int64(math.Round(f*1e6)) * 1e3
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// marshal returns the JSON encoding of the request.
+func (c *spanConverter) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(c.request); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// segmentID returns the id of segment document doc, the empty string if it cannot be read.
+func segmentID(doc string) string {
+	var s struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal([]byte(doc), &s)
+	return s.ID
+}
@@ -0,0 +1,215 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSegment = `{
+  "trace_id": "1-5759e988-bd862e3fe1be46a994272793",
+  "id": "70de5b6f19ff9a0a",
+  "name": "web",
+  "origin": "AWS::EC2::Instance",
+  "start_time": 1461096053.37518,
+  "end_time": 1461096053.4042,
+  "fault": true,
+  "http": {
+    "request": {"method": "GET", "url": "https://example.com/orders", "x_forwarded_for": true},
+    "response": {"status": 500, "content_length": 42}
+  },
+  "annotations": {"customer": "alice", "items": 3, "ratio": 0.5, "vip": true},
+  "metadata": {"debug": {"query": {"page": 2}}},
+  "cause": {"exceptions": [{"type": "NullPointerException", "message": "order is null"}]},
+  "subsegments": [
+    {
+      "id": "0f910026178b71eb",
+      "name": "DynamoDB",
+      "namespace": "aws",
+      "start_time": 1461096053.38,
+      "end_time": 1461096053.39,
+      "aws": {"operation": "GetItem", "region": "us-east-1", "table_name": "orders"},
+      "subsegments": [
+        {"id": "1f910026178b71eb", "name": "marshal", "start_time": 1461096053.381, "end_time": 1461096053.382}
+      ]
+    },
+    {
+      "id": "2f910026178b71eb",
+      "name": "orders@db",
+      "namespace": "remote",
+      "start_time": 1461096053.39,
+      "end_time": 1461096053.40,
+      "error": true,
+      "sql": {"url": "jdbc:postgresql://db/orders", "database_type": "PostgreSQL", "sanitized_query": "SELECT * FROM orders WHERE id = ?", "driver_version": "42"}
+    }
+  ]
+}`
+
+func attributes(kvs []otlpKeyValue) map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, kv := range kvs {
+		switch {
+		case kv.Value.StringValue != nil:
+			m[kv.Key] = *kv.Value.StringValue
+		case kv.Value.BoolValue != nil:
+			m[kv.Key] = *kv.Value.BoolValue
+		case kv.Value.IntValue != nil:
+			m[kv.Key] = "int:" + *kv.Value.IntValue
+		case kv.Value.DoubleValue != nil:
+			m[kv.Key] = *kv.Value.DoubleValue
+		}
+	}
+	return m
+}
+
+func TestConvertSegment(t *testing.T) {
+	c := newSpanConverter()
+
+	assert.Nil(t, c.add(testSegment))
+
+	assert.Equal(t, 1, len(c.request.ResourceSpans))
+	rs := c.request.ResourceSpans[0]
+	assert.Equal(t, map[string]interface{}{
+		"service.name":   "web",
+		"cloud.provider": "aws",
+		"cloud.platform": "AWS::EC2::Instance",
+	}, attributes(rs.Resource.Attributes))
+	spans := rs.ScopeSpans[0].Spans
+	assert.Equal(t, 4, len(spans))
+
+	root := spans[0]
+	assert.Equal(t, "5759e988bd862e3fe1be46a994272793", root.TraceID)
+	assert.Equal(t, "70de5b6f19ff9a0a", root.SpanID)
+	assert.Equal(t, "", root.ParentSpanID)
+	assert.Equal(t, spanKindServer, root.Kind)
+	assert.Equal(t, "1461096053375180000", root.StartTimeUnixNano)
+	assert.Equal(t, "1461096053404200000", root.EndTimeUnixNano)
+	assert.Equal(t, otlpStatus{Code: statusCodeError, Message: "order is null"}, root.Status)
+	assert.Equal(t, 1, len(root.Events))
+	assert.Equal(t, map[string]interface{}{
+		"exception.type":    "NullPointerException",
+		"exception.message": "order is null",
+	}, attributes(root.Events[0].Attributes))
+	assert.Equal(t, map[string]interface{}{
+		"http.request.method":          "GET",
+		"url.full":                     "https://example.com/orders",
+		"http.request.x_forwarded_for": true,
+		"http.response.status_code":    "int:500",
+		"http.response.body.size":      "int:42",
+		"customer":                     "alice",
+		"items":                        "int:3",
+		"ratio":                        0.5,
+		"vip":                          true,
+		"aws.xray.metadata.debug":      `{"query":{"page":2}}`,
+		"aws.xray.fault":               true,
+	}, attributes(root.Attributes))
+
+	dynamo := spans[1]
+	assert.Equal(t, "70de5b6f19ff9a0a", dynamo.ParentSpanID)
+	assert.Equal(t, spanKindClient, dynamo.Kind)
+	assert.Equal(t, otlpStatus{}, dynamo.Status)
+	assert.Equal(t, map[string]interface{}{
+		"rpc.system":     "aws-api",
+		"rpc.service":    "DynamoDB",
+		"rpc.method":     "GetItem",
+		"cloud.region":   "us-east-1",
+		"aws.table_name": "orders",
+	}, attributes(dynamo.Attributes))
+
+	marshal := spans[2]
+	assert.Equal(t, "0f910026178b71eb", marshal.ParentSpanID)
+	assert.Equal(t, spanKindInternal, marshal.Kind)
+
+	db := spans[3]
+	assert.Equal(t, spanKindClient, db.Kind)
+	assert.Equal(t, statusCodeError, db.Status.Code)
+	assert.Equal(t, map[string]interface{}{
+		"db.connection_string": "jdbc:postgresql://db/orders",
+		"db.system":            "PostgreSQL",
+		"db.statement":         "SELECT * FROM orders WHERE id = ?",
+		"db.driver_version":    "42",
+		"aws.xray.error":       true,
+	}, attributes(db.Attributes))
+}
+
+func TestConvertIndependentSubsegment(t *testing.T) {
+	c := newSpanConverter()
+
+	err := c.add(`{"type": "subsegment", "trace_id": "1-5759e988-bd862e3fe1be46a994272793", "id": "0f910026178b71eb",
+		"parent_id": "70de5b6f19ff9a0a", "name": "S3", "namespace": "aws", "start_time": 1461096053.38, "in_progress": true}`)
+
+	assert.Nil(t, err)
+	span := c.request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Empty(t, c.request.ResourceSpans[0].Resource.Attributes)
+	assert.Equal(t, "70de5b6f19ff9a0a", span.ParentSpanID)
+	assert.Equal(t, spanKindClient, span.Kind)
+	assert.Equal(t, span.StartTimeUnixNano, span.EndTimeUnixNano, "Segments in progress end when they start")
+}
+
+func TestConvertInvalidSegment(t *testing.T) {
+	c := newSpanConverter()
+
+	assert.EqualError(t, c.add(`{"trace_id": "1-5759e988", "id": "70de5b6f19ff9a0a"}`), `invalid trace id "1-5759e988"`)
+	assert.EqualError(t, c.add(`{"trace_id": "1-5759e988-bd862e3fe1be46a994272793", "id": "web"}`), `invalid segment id "web"`)
+	assert.NotNil(t, c.add(`invalid`))
+	assert.Empty(t, c.request.ResourceSpans)
+}
+
+func TestOTLPExport(t *testing.T) {
+	var received otlpRequest
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+	o, err := NewOTLP(OTLPConfig{Endpoint: server.URL + "/v1/traces", Headers: map[string]string{"Authorization": "Bearer token"}})
+	assert.Nil(t, err)
+
+	unprocessed, err := o.Export(context.Background(), []string{testSegment, `{"id": "0f910026178b71eb"}`})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(unprocessed))
+	assert.Equal(t, "0f910026178b71eb", *unprocessed[0].Id)
+	assert.Equal(t, errorCodeInvalidSegment, *unprocessed[0].ErrorCode)
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+	assert.Equal(t, 1, len(received.ResourceSpans))
+	assert.Equal(t, 4, len(received.ResourceSpans[0].ScopeSpans[0].Spans))
+	assert.Nil(t, o.Close())
+}
+
+func TestOTLPExportCollectorError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	o, _ := NewOTLP(OTLPConfig{Endpoint: server.URL})
+
+	_, err := o.Export(context.Background(), []string{testSegment})
+
+	assert.EqualError(t, err, "otlp exporter: endpoint returned 503 Service Unavailable: unavailable")
+	assert.EqualValues(t, http.StatusServiceUnavailable, err.(*StatusError).StatusCode)
+}
+
+func TestNewOTLPInvalidEndpoint(t *testing.T) {
+	o, err := NewOTLP(OTLPConfig{Endpoint: "localhost:4318"})
+
+	assert.Nil(t, o)
+	assert.EqualError(t, err, `otlp exporter: invalid endpoint "localhost:4318"`)
+}
@@ -0,0 +1,478 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	log "github.com/cihub/seelog"
+)
+
+// Maximum number of objects kept in memory while uploads fail, older objects are written to the spool directory.
+const maxPendingObjects = 16
+
+// Timeout of an upload if the config has none.
+const defaultS3Timeout = time.Minute
+
+// Prefix of the telemetry counters of S3 exporters.
+const s3CounterName = "exporter.s3"
+
+// S3Config describes the objects an S3 exporter uploads.
+type S3Config struct {
+	// Bucket objects are uploaded to.
+	Bucket string
+
+	// Prefix of object keys, such as traces/.
+	Prefix string
+
+	// Prefix of object names, such as the host name.
+	Name string
+
+	// Region of the bucket.
+	Region string
+
+	// URL of an S3-compatible service addressed path-style, the virtual-hosted
+	// https://<bucket>.s3.<region>.amazonaws.com if empty.
+	Endpoint string
+
+	// Size of the documents of an object, before compression, after which the object is uploaded, 0 for no limit.
+	MaxObjectBytes int64
+
+	// Age of an object after which it is uploaded, 0 for no limit.
+	FlushInterval time.Duration
+
+	// Timeout of an upload, a minute if 0.
+	Timeout time.Duration
+
+	// Directory objects are written to while more than maxPendingObjects wait to be uploaded, and at close
+	// if their upload failed. They are uploaded from it, also after a restart. Objects are dropped if empty.
+	SpoolDirectory string
+
+	// Maximum size of the objects in SpoolDirectory, the oldest being removed to make room, 0 for no limit.
+	MaxSpoolBytes int64
+
+	// Credentials requests are signed with.
+	Credentials aws.CredentialsProvider
+
+	// Client sending the requests, http.DefaultClient if nil.
+	HTTPClient aws.HTTPClient
+}
+
+// S3 exports segment documents to gzip compressed newline delimited JSON objects, partitioned by hour
+// with keys such as prefix/yyyy/mm/dd/hh/name-seq.ndjson.gz, uploaded with signed PutObject calls.
+// Objects whose upload failed are kept, in memory then in the spool directory, and uploaded again with the next object.
+type S3 struct {
+	config S3Config
+	client aws.HTTPClient
+	signer *awsSigner
+
+	// Guards the fields below, never held while uploading.
+	lock sync.Mutex
+
+	// Held while uploading, so objects are uploaded one at a time, oldest first.
+	uploading sync.Mutex
+
+	// Compressed documents of the object being written, nil if none.
+	current *bytes.Buffer
+
+	// Compressor writing to current.
+	gz *gzip.Writer
+
+	// Bytes of documents written to current object, before compression.
+	currentBytes int64
+
+	// Time current object was started.
+	openedAt time.Time
+
+	// Objects waiting to be uploaded in memory, oldest first, all newer than the spooled ones.
+	pending []s3Object
+
+	// Keys of the objects waiting to be uploaded in the spool directory, oldest first, and their size.
+	spooled      []string
+	spooledBytes int64
+
+	// Sequence number of the next object, starting at the creation time of the exporter in milliseconds
+	// so objects of a restarted daemon do not overwrite previous ones.
+	seq int64
+
+	// Channel closed to stop time-based uploads.
+	stop chan struct{}
+
+	// Channel closed once time-based uploads stopped.
+	stopped chan struct{}
+
+	// Returns current time, replaced in tests.
+	now func() time.Time
+}
+
+type s3Object struct {
+	key string
+
+	// Compressed documents of the object, nil if it is in the spool directory.
+	body []byte
+}
+
+// NewS3 returns an S3 exporter uploading objects as described by c, starting with the objects left in its spool directory.
+func NewS3(c S3Config) (*S3, error) {
+	if c.Bucket == "" {
+		return nil, errors.New("s3 exporter: bucket is empty")
+	}
+	if c.Region == "" {
+		return nil, errors.New("s3 exporter: region is required")
+	}
+	if c.Credentials == nil {
+		return nil, errors.New("s3 exporter: credentials are required")
+	}
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("s3 exporter: invalid endpoint %q", c.Endpoint)
+		}
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultS3Timeout
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	s := &S3{
+		config: c,
+		client: client,
+		// Object keys are sent as is, S3 does not normalize paths.
+		signer: newAWSSigner(c.Credentials, "s3", c.Region, func(o *v4.SignerOptions) {
+			o.DisableURIPathEscaping = true
+		}),
+		seq: time.Now().UnixNano() / int64(time.Millisecond),
+		now: time.Now,
+	}
+	if err := s.loadSpool(); err != nil {
+		return nil, fmt.Errorf("s3 exporter: unable to use spool directory: %w", err)
+	}
+	if c.FlushInterval > 0 {
+		s.stop = make(chan struct{})
+		s.stopped = make(chan struct{})
+		go s.flushOnInterval()
+	}
+	return s, nil
+}
+
+// Export appends docs to the object being written, one document per line, and uploads the object once full.
+// Documents which are not valid JSON are skipped. A failed upload is logged, counted and retried later,
+// so it does not fail the export of docs, which are already held by the exporter.
+func (s *S3) Export(ctx context.Context, docs []string) ([]types.UnprocessedTraceSegment, error) {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		// Compacting guarantees a document spans a single line.
+		if err := json.Compact(&buf, []byte(doc)); err != nil {
+			log.Warnf("s3 exporter: skipping invalid segment document: %v", err)
+			continue
+		}
+		buf.WriteByte('\n')
+	}
+	s.lock.Lock()
+	if s.current != nil && s.expired() {
+		s.seal()
+	}
+	if s.current == nil {
+		s.open()
+	}
+	n, err := s.gz.Write(buf.Bytes())
+	s.currentBytes += int64(n)
+	if err != nil {
+		s.lock.Unlock()
+		return nil, fmt.Errorf("s3 exporter: unable to compress segments: %w", err)
+	}
+	if s.config.MaxObjectBytes > 0 && s.currentBytes >= s.config.MaxObjectBytes {
+		s.seal()
+	}
+	s.lock.Unlock()
+	// Uploads in progress, such as time-based ones, upload the object sealed here too.
+	s.upload(ctx, false)
+	return nil, nil
+}
+
+// Close uploads the object being written along with the objects whose upload failed.
+// Objects still failing are written to the spool directory, to be uploaded after a restart.
+func (s *S3) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.stopped
+	}
+	s.lock.Lock()
+	if s.current != nil {
+		s.seal()
+	}
+	s.lock.Unlock()
+	if err := s.upload(context.Background(), true); err != nil {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		n := len(s.pending) + len(s.spooled)
+		for len(s.pending) > 0 {
+			s.spoolOldest()
+		}
+		return fmt.Errorf("s3 exporter: %d objects not uploaded: %w", n, err)
+	}
+	return nil
+}
+
+// flushOnInterval uploads the object being written once it reaches the flush interval, along with
+// the objects whose upload failed, until stopped.
+func (s *S3) flushOnInterval() {
+	defer close(s.stopped)
+	period := time.Second
+	if s.config.FlushInterval < period {
+		period = s.config.FlushInterval
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		s.lock.Lock()
+		if s.current != nil && s.expired() {
+			s.seal()
+		}
+		s.lock.Unlock()
+		s.upload(context.Background(), false)
+	}
+}
+
+// expired returns true if the object being written reached the flush interval, or belongs to a past hour.
+// Must be called with the lock held.
+func (s *S3) expired() bool {
+	now := s.now()
+	if !now.UTC().Truncate(time.Hour).Equal(s.openedAt.UTC().Truncate(time.Hour)) {
+		return true
+	}
+	return s.config.FlushInterval > 0 && now.Sub(s.openedAt) >= s.config.FlushInterval
+}
+
+func (s *S3) open() {
+	s.current = new(bytes.Buffer)
+	s.gz = gzip.NewWriter(s.current)
+	s.currentBytes = 0
+	s.openedAt = s.now()
+}
+
+// seal completes the object being written and queues it for upload. Must be called with the lock held.
+func (s *S3) seal() {
+	s.gz.Close()
+	s.pending = append(s.pending, s3Object{key: s.key(), body: s.current.Bytes()})
+	s.seq++
+	s.current = nil
+	s.gz = nil
+	if len(s.pending) > maxPendingObjects {
+		s.spoolOldest()
+	}
+}
+
+// key returns the key of the object being written, partitioned by the hour it was started.
+func (s *S3) key() string {
+	t := s.openedAt.UTC()
+	prefix := s.config.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return fmt.Sprintf("%v%04d/%02d/%02d/%02d/%v-%d%v%v", prefix, t.Year(), t.Month(), t.Day(), t.Hour(),
+		s.config.Name, s.seq, fileExtension, gzipExtension)
+}
+
+// upload uploads the spooled then the pending objects in order, and stops at the first failure.
+// If wait is false, it returns right away while another upload is in progress. Must be called without the lock held.
+func (s *S3) upload(ctx context.Context, wait bool) error {
+	if wait {
+		s.uploading.Lock()
+	} else if !s.uploading.TryLock() {
+		return nil
+	}
+	defer s.uploading.Unlock()
+	for {
+		o, ok := s.next()
+		if !ok {
+			return nil
+		}
+		if o.body == nil {
+			body, err := os.ReadFile(s.spoolPath(o.key))
+			if err != nil {
+				// The object was removed to make room in the spool directory.
+				log.Warnf("s3 exporter: skipping spooled object %v: %v", o.key, err)
+				s.uploaded(o.key)
+				continue
+			}
+			o.body = body
+		}
+		if err := s.putObject(ctx, o); err != nil {
+			log.Errorf("s3 exporter: unable to upload %v, retrying later: %v", o.key, err)
+			telemetry.T.Count(s3CounterName+".failed", 1)
+			return err
+		}
+		log.Debugf("s3 exporter: uploaded %v of %d bytes", o.key, len(o.body))
+		telemetry.T.Count(s3CounterName+".uploaded", 1)
+		s.uploaded(o.key)
+	}
+}
+
+// next returns the oldest object waiting to be uploaded, false if none.
+func (s *S3) next() (s3Object, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.spooled) > 0 {
+		return s3Object{key: s.spooled[0]}, true
+	}
+	if len(s.pending) > 0 {
+		return s.pending[0], true
+	}
+	return s3Object{}, false
+}
+
+// uploaded stops tracking the object with the given key, pending or spooled.
+func (s *S3) uploaded(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, o := range s.pending {
+		if o.key == key {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			return
+		}
+	}
+	for i, k := range s.spooled {
+		if k == key {
+			s.removeSpooled(i)
+			return
+		}
+	}
+}
+
+func (s *S3) putObject(ctx context.Context, o s3Object) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+	var u string
+	if s.config.Endpoint == "" {
+		u = fmt.Sprintf("https://%v.s3.%v.amazonaws.com/%v", s.config.Bucket, s.config.Region, o.key)
+	} else {
+		u = fmt.Sprintf("%v/%v/%v", strings.TrimSuffix(s.config.Endpoint, "/"), s.config.Bucket, o.key)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(o.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	if err := s.signer.sign(ctx, req, o.body); err != nil {
+		return fmt.Errorf("unable to sign request: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("PutObject returned %v: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// loadSpool tracks the objects left in the spool directory, oldest first, creating the directory if needed.
+func (s *S3) loadSpool() error {
+	if s.config.SpoolDirectory == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.config.SpoolDirectory, 0700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(s.config.SpoolDirectory)
+	if err != nil {
+		return err
+	}
+	// Keys sort by the hour, then the sequence number of their object.
+	for _, entry := range entries {
+		key, err := url.PathUnescape(entry.Name())
+		if entry.IsDir() || err != nil || !strings.HasSuffix(key, fileExtension+gzipExtension) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		s.spooled = append(s.spooled, key)
+		s.spooledBytes += info.Size()
+	}
+	if len(s.spooled) > 0 {
+		log.Infof("s3 exporter: uploading %d objects left in %v", len(s.spooled), s.config.SpoolDirectory)
+	}
+	return nil
+}
+
+// spoolOldest writes the oldest pending object to the spool directory, removing the oldest spooled objects
+// to make room. The object is dropped if there is no spool directory or it cannot be written.
+// Must be called with the lock held.
+func (s *S3) spoolOldest() {
+	o := s.pending[0]
+	s.pending = s.pending[1:]
+	if s.config.SpoolDirectory == "" {
+		log.Errorf("s3 exporter: dropping object %v, %v objects are waiting to be uploaded", o.key, len(s.pending))
+		telemetry.T.Count(s3CounterName+".dropped", 1)
+		return
+	}
+	size := int64(len(o.body))
+	for s.config.MaxSpoolBytes > 0 && len(s.spooled) > 0 && s.spooledBytes+size > s.config.MaxSpoolBytes {
+		log.Errorf("s3 exporter: dropping spooled object %v to make room in %v", s.spooled[0], s.config.SpoolDirectory)
+		telemetry.T.Count(s3CounterName+".dropped", 1)
+		s.removeSpooled(0)
+	}
+	if err := os.WriteFile(s.spoolPath(o.key), o.body, 0600); err != nil {
+		log.Errorf("s3 exporter: dropping object %v: %v", o.key, err)
+		telemetry.T.Count(s3CounterName+".dropped", 1)
+		return
+	}
+	s.spooled = append(s.spooled, o.key)
+	s.spooledBytes += size
+	telemetry.T.Count(s3CounterName+".spooled", 1)
+}
+
+// removeSpooled removes the i-th spooled object from the spool directory. Must be called with the lock held.
+func (s *S3) removeSpooled(i int) {
+	name := s.spoolPath(s.spooled[i])
+	if info, err := os.Stat(name); err == nil {
+		s.spooledBytes -= info.Size()
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		log.Warnf("s3 exporter: unable to remove spooled object: %v", err)
+	}
+	s.spooled = append(s.spooled[:i], s.spooled[i+1:]...)
+}
+
+// spoolPath returns the name of the file of the object with the given key in the spool directory.
+func (s *S3) spoolPath(key string) string {
+	return filepath.Join(s.config.SpoolDirectory, url.PathEscape(key))
+}
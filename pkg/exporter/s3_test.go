@@ -0,0 +1,312 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+// s3StandIn is a local stand-in for an S3-compatible service, storing uploaded objects by path.
+type s3StandIn struct {
+	lock    sync.Mutex
+	server  *httptest.Server
+	objects map[string]string
+	keys    []string
+
+	// Number of uploads to fail before storing objects.
+	failures int
+
+	// Channel uploads wait on before being handled, nil to handle them right away.
+	hold chan struct{}
+}
+
+func newS3StandIn(t *testing.T) *s3StandIn {
+	s := &s3StandIn{objects: make(map[string]string)}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.hold != nil {
+			select {
+			case <-s.hold:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-west-2/s3/aws4_request")
+		body, _ := ioutil.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		assert.Equal(t, hex.EncodeToString(sum[:]), r.Header.Get("X-Amz-Content-Sha256"))
+		if s.failures > 0 {
+			s.failures--
+			http.Error(w, "<Error><Code>SlowDown</Code></Error>", http.StatusServiceUnavailable)
+			return
+		}
+		gz, err := gzip.NewReader(strings.NewReader(string(body)))
+		assert.Nil(t, err)
+		content, err := ioutil.ReadAll(gz)
+		assert.Nil(t, err)
+		s.objects[r.URL.Path] = string(content)
+		s.keys = append(s.keys, r.URL.Path)
+	}))
+	return s
+}
+
+func (s *s3StandIn) uploaded() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.keys...)
+}
+
+func newTestS3(t *testing.T, endpoint string, maxObjectBytes int64, now time.Time) *S3 {
+	return newTestS3WithSpool(t, endpoint, maxObjectBytes, now, "")
+}
+
+func newTestS3WithSpool(t *testing.T, endpoint string, maxObjectBytes int64, now time.Time, dir string) *S3 {
+	telemetry.T = telemetry.GetTestTelemetry()
+	s, err := NewS3(S3Config{
+		Bucket:         "archive",
+		Prefix:         "traces",
+		Name:           "host-1",
+		Region:         "us-west-2",
+		Endpoint:       endpoint,
+		MaxObjectBytes: maxObjectBytes,
+		Timeout:        time.Second,
+		SpoolDirectory: dir,
+		Credentials:    credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	})
+	assert.Nil(t, err)
+	s.seq = 1
+	s.now = func() time.Time {
+		return now
+	}
+	return s
+}
+
+func TestS3UploadsOnSize(t *testing.T) {
+	stand := newS3StandIn(t)
+	defer stand.server.Close()
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	s := newTestS3(t, stand.server.URL, 20, now)
+
+	_, err := s.Export(context.Background(), []string{`{"id": "1"}`})
+	assert.Nil(t, err)
+	assert.Empty(t, stand.uploaded())
+	_, err = s.Export(context.Background(), []string{`{"id": "2"}`, "invalid"})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"/archive/traces/2026/03/04/05/host-1-1.ndjson.gz"}, stand.uploaded())
+	assert.Equal(t, "{\"id\":\"1\"}\n{\"id\":\"2\"}\n", stand.objects["/archive/traces/2026/03/04/05/host-1-1.ndjson.gz"])
+
+	_, err = s.Export(context.Background(), []string{`{"id": "3"}`})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+	assert.Equal(t, "{\"id\":\"3\"}\n", stand.objects["/archive/traces/2026/03/04/05/host-1-2.ndjson.gz"])
+}
+
+func TestS3PartitionsByHour(t *testing.T) {
+	stand := newS3StandIn(t)
+	defer stand.server.Close()
+	now := time.Date(2026, 3, 4, 5, 59, 59, 0, time.UTC)
+	s := newTestS3(t, stand.server.URL, 0, now)
+
+	_, err := s.Export(context.Background(), []string{`{"id": "1"}`})
+	assert.Nil(t, err)
+	s.now = func() time.Time {
+		return now.Add(time.Second)
+	}
+	_, err = s.Export(context.Background(), []string{`{"id": "2"}`})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+
+	assert.Equal(t, []string{
+		"/archive/traces/2026/03/04/05/host-1-1.ndjson.gz",
+		"/archive/traces/2026/03/04/06/host-1-2.ndjson.gz",
+	}, stand.uploaded())
+}
+
+func TestS3UploadsOnInterval(t *testing.T) {
+	stand := newS3StandIn(t)
+	defer stand.server.Close()
+	s, err := NewS3(S3Config{
+		Bucket:        "archive",
+		Name:          "host-1",
+		Region:        "us-west-2",
+		Endpoint:      stand.server.URL,
+		FlushInterval: 10 * time.Millisecond,
+		Credentials:   credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	})
+	assert.Nil(t, err)
+	defer s.Close()
+
+	_, err = s.Export(context.Background(), []string{`{"id": "1"}`})
+	assert.Nil(t, err)
+
+	for i := 0; i < 100 && len(stand.uploaded()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Len(t, stand.uploaded(), 1)
+	assert.True(t, strings.HasPrefix(stand.uploaded()[0], "/archive/"+time.Now().UTC().Format("2006/01/02")))
+}
+
+func TestS3RetriesFailedUpload(t *testing.T) {
+	stand := newS3StandIn(t)
+	defer stand.server.Close()
+	stand.failures = 1
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	s := newTestS3(t, stand.server.URL, 1, now)
+
+	_, err := s.Export(context.Background(), []string{`{"id": "1"}`})
+	assert.Nil(t, err)
+	assert.Empty(t, stand.uploaded())
+	assert.Len(t, s.pending, 1)
+
+	_, err = s.Export(context.Background(), []string{`{"id": "2"}`})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"/archive/traces/2026/03/04/05/host-1-1.ndjson.gz",
+		"/archive/traces/2026/03/04/05/host-1-2.ndjson.gz",
+	}, stand.uploaded())
+	assert.Empty(t, s.pending)
+}
+
+func TestS3DropsOldestPendingObject(t *testing.T) {
+	stand := newS3StandIn(t)
+	defer stand.server.Close()
+	stand.failures = maxPendingObjects + 1
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	s := newTestS3(t, stand.server.URL, 1, now)
+
+	for i := 0; i <= maxPendingObjects; i++ {
+		_, err := s.Export(context.Background(), []string{fmt.Sprintf(`{"id": "%d"}`, i)})
+		assert.Nil(t, err)
+	}
+
+	assert.Len(t, s.pending, maxPendingObjects)
+	assert.Equal(t, "traces/2026/03/04/05/host-1-2.ndjson.gz", s.pending[0].key)
+	assert.EqualValues(t, 1, telemetry.T.Counter("exporter.s3.dropped"))
+	assert.EqualValues(t, maxPendingObjects+1, telemetry.T.Counter("exporter.s3.failed"))
+	assert.Nil(t, s.Close())
+	assert.Len(t, stand.uploaded(), maxPendingObjects)
+}
+
+func TestS3SpoolsOldestPendingObject(t *testing.T) {
+	stand := newS3StandIn(t)
+	defer stand.server.Close()
+	stand.failures = maxPendingObjects + 1
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	s := newTestS3WithSpool(t, stand.server.URL, 1, now, t.TempDir())
+
+	for i := 0; i <= maxPendingObjects; i++ {
+		_, err := s.Export(context.Background(), []string{fmt.Sprintf(`{"id": "%d"}`, i)})
+		assert.Nil(t, err)
+	}
+
+	assert.Len(t, s.pending, maxPendingObjects)
+	assert.Equal(t, []string{"traces/2026/03/04/05/host-1-1.ndjson.gz"}, s.spooled)
+	assert.EqualValues(t, 1, telemetry.T.Counter("exporter.s3.spooled"))
+	assert.Nil(t, s.Close())
+	assert.Len(t, stand.uploaded(), maxPendingObjects+1)
+	assert.Equal(t, "/archive/traces/2026/03/04/05/host-1-1.ndjson.gz", stand.uploaded()[0], "Spooled objects are uploaded first")
+	assert.Equal(t, "{\"id\":\"0\"}\n", stand.objects["/archive/traces/2026/03/04/05/host-1-1.ndjson.gz"])
+	files, _ := ioutil.ReadDir(s.config.SpoolDirectory)
+	assert.Empty(t, files)
+}
+
+func TestS3UploadsSpooledObjectsAfterRestart(t *testing.T) {
+	stand := newS3StandIn(t)
+	defer stand.server.Close()
+	stand.failures = 1
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	s := newTestS3WithSpool(t, stand.server.URL, 0, now, dir)
+
+	_, err := s.Export(context.Background(), []string{`{"id": "1"}`})
+	assert.Nil(t, err)
+	assert.NotNil(t, s.Close())
+	assert.Empty(t, stand.uploaded())
+
+	s = newTestS3WithSpool(t, stand.server.URL, 0, now, dir)
+	assert.Nil(t, s.Close())
+	assert.Equal(t, []string{"/archive/traces/2026/03/04/05/host-1-1.ndjson.gz"}, stand.uploaded())
+}
+
+func TestS3ExportDoesNotWaitForUpload(t *testing.T) {
+	stand := newS3StandIn(t)
+	defer stand.server.Close()
+	stand.hold = make(chan struct{})
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	s := newTestS3(t, stand.server.URL, 1, now)
+
+	done := make(chan struct{})
+	go func() {
+		s.Export(context.Background(), []string{`{"id": "1"}`})
+		close(done)
+	}()
+	// Wait for the first upload to start.
+	for {
+		if !s.uploading.TryLock() {
+			break
+		}
+		s.uploading.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	_, err := s.Export(context.Background(), []string{`{"id": "2"}`})
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "Export does not wait for the upload in progress")
+
+	<-done
+	assert.EqualValues(t, 1, telemetry.T.Counter("exporter.s3.failed"), "The upload times out")
+	close(stand.hold)
+	assert.Nil(t, s.Close())
+	assert.Len(t, stand.objects, 2)
+}
+
+func TestS3CloseReportsFailedUpload(t *testing.T) {
+	stand := newS3StandIn(t)
+	defer stand.server.Close()
+	stand.failures = 1
+	s := newTestS3(t, stand.server.URL, 0, time.Now())
+
+	_, err := s.Export(context.Background(), []string{`{"id": "1"}`})
+	assert.Nil(t, err)
+
+	err = s.Close()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "s3 exporter: 1 objects not uploaded")
+}
+
+func TestNewS3InvalidConfig(t *testing.T) {
+	creds := credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")
+	_, err := NewS3(S3Config{Region: "us-west-2", Credentials: creds})
+	assert.EqualError(t, err, "s3 exporter: bucket is empty")
+	_, err = NewS3(S3Config{Bucket: "archive", Credentials: creds})
+	assert.NotNil(t, err)
+	_, err = NewS3(S3Config{Bucket: "archive", Region: "us-west-2", Credentials: creds, Endpoint: "localhost"})
+	assert.EqualError(t, err, `s3 exporter: invalid endpoint "localhost"`)
+}
@@ -0,0 +1,50 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// awsSigner signs requests to AWS services not covered by the SDK clients the daemon depends on.
+type awsSigner struct {
+	signer      *v4.Signer
+	credentials aws.CredentialsProvider
+	service     string
+	region      string
+}
+
+func newAWSSigner(credentials aws.CredentialsProvider, service string, region string, optFns ...func(*v4.SignerOptions)) *awsSigner {
+	return &awsSigner{
+		signer:      v4.NewSigner(optFns...),
+		credentials: credentials,
+		service:     service,
+		region:      region,
+	}
+}
+
+// sign adds a Signature Version 4 authorization to req, which sends body.
+func (s *awsSigner) sign(ctx context.Context, req *http.Request, body []byte) error {
+	creds, err := s.credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	return s.signer.SignHTTP(ctx, creds, req, payloadHash, s.service, s.region, time.Now())
+}
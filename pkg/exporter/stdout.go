@@ -0,0 +1,73 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	log "github.com/cihub/seelog"
+)
+
+// Writer exports segment documents to an io.Writer such as stdout, one document per line.
+type Writer struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewWriter returns an exporter writing segment documents to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w: w,
+	}
+}
+
+// Export writes docs, compacted to a single line each, in one write so batches do not interleave.
+// Documents which are not valid JSON are skipped.
+func (x *Writer) Export(ctx context.Context, docs []string) ([]types.UnprocessedTraceSegment, error) {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		// Compacting guarantees a document spans a single line.
+		if err := json.Compact(&buf, []byte(doc)); err != nil {
+			log.Warnf("writer exporter: skipping invalid segment document: %v", err)
+			continue
+		}
+		buf.WriteByte('\n')
+	}
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if _, err := x.w.Write(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("writer exporter: %v", err)
+	}
+	return nil, nil
+}
+
+// Close does nothing, the writer is owned by the caller.
+func (x *Writer) Close() error {
+	return nil
+}
+
+// Discard accepts segment documents without sending them, so they are only counted.
+type Discard struct{}
+
+// Export accepts docs.
+func (Discard) Export(ctx context.Context, docs []string) ([]types.UnprocessedTraceSegment, error) {
+	return nil, nil
+}
+
+// Close does nothing.
+func (Discard) Close() error {
+	return nil
+}
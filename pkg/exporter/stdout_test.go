@@ -0,0 +1,37 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterExport(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	r, err := w.Export(context.Background(), []string{"{\"id\": \"1\",\n \"name\": \"web\"}", "invalid"})
+
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+	assert.Equal(t, "{\"id\":\"1\",\"name\":\"web\"}\n", buf.String(), "Invalid documents are skipped")
+	assert.Nil(t, w.Close())
+}
+
+func TestDiscardExport(t *testing.T) {
+	r, err := Discard{}.Export(context.Background(), []string{"{}"})
+
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+}
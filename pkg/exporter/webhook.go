@@ -0,0 +1,141 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	log "github.com/cihub/seelog"
+)
+
+// WebhookConfig describes the HTTP endpoint a Webhook exporter posts batches to.
+type WebhookConfig struct {
+	// URL batches are posted to.
+	URL string
+
+	// Headers added to every request.
+	Headers map[string]string
+
+	// Token sent as bearer authorization, if not empty.
+	BearerToken string
+
+	// User name and password sent as basic authorization, if the user name is not empty.
+	Username string
+	Password string
+
+	// Gzip, if true, compresses request bodies.
+	Gzip bool
+
+	// Timeout of a request, 0 for no timeout.
+	Timeout time.Duration
+}
+
+// Webhook exports batches of segment documents to an HTTP endpoint, each batch posted as a JSON array.
+// Unsuccessful responses are returned as a StatusError, so 5xx and 429 responses can be retried.
+type Webhook struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+// NewWebhook returns an exporter posting batches to the endpoint described by c.
+func NewWebhook(c WebhookConfig) (*Webhook, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook exporter: invalid url %q", c.URL)
+	}
+	if c.BearerToken != "" && c.Username != "" {
+		return nil, errors.New("webhook exporter: bearer and basic authorization are exclusive")
+	}
+	return &Webhook{
+		config: c,
+		client: &http.Client{Timeout: c.Timeout},
+	}, nil
+}
+
+// Export posts docs in a single request. Documents which are not valid JSON are returned as unprocessed.
+func (w *Webhook) Export(ctx context.Context, docs []string) ([]types.UnprocessedTraceSegment, error) {
+	var unprocessed []types.UnprocessedTraceSegment
+	var body bytes.Buffer
+	body.WriteByte('[')
+	n := 0
+	for _, doc := range docs {
+		if !json.Valid([]byte(doc)) {
+			log.Debugf("webhook exporter: skipping invalid segment document")
+			unprocessed = append(unprocessed, unprocessedSegment(doc, errorCodeInvalidSegment, "invalid segment document"))
+			continue
+		}
+		if n > 0 {
+			body.WriteByte(',')
+		}
+		json.Compact(&body, []byte(doc))
+		n++
+	}
+	body.WriteByte(']')
+	if n == 0 {
+		return unprocessed, nil
+	}
+	payload := body.Bytes()
+	if w.config.Gzip {
+		compressed, err := compress(payload)
+		if err != nil {
+			return nil, fmt.Errorf("webhook exporter: %w", err)
+		}
+		payload = compressed
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("webhook exporter: %w", err)
+	}
+	for k, v := range w.config.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.config.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	switch {
+	case w.config.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+w.config.BearerToken)
+	case w.config.Username != "":
+		req.SetBasicAuth(w.config.Username, w.config.Password)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webhook exporter: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &StatusError{
+			Exporter:   "webhook",
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       string(bytes.TrimSpace(msg)),
+		}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return unprocessed, nil
+}
+
+// Close releases idle connections to the endpoint.
+func (w *Webhook) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookExport(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "tenant-1", r.Header.Get("X-Tenant"))
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	w, err := NewWebhook(WebhookConfig{URL: server.URL, Headers: map[string]string{"X-Tenant": "tenant-1"}, BearerToken: "secret"})
+	assert.Nil(t, err)
+
+	unprocessed, err := w.Export(context.Background(), []string{`{"id": "1"}`, `{"id":`, `{"id": "2"}`})
+
+	assert.Nil(t, err)
+	assert.Len(t, unprocessed, 1)
+	assert.Equal(t, errorCodeInvalidSegment, *unprocessed[0].ErrorCode)
+	assert.Equal(t, `[{"id":"1"},{"id":"2"}]`, body)
+	assert.Nil(t, w.Close())
+}
+
+func TestWebhookGzipAndBasicAuth(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "daemon", user)
+		assert.Equal(t, "pass", password)
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(r.Body)
+		assert.Nil(t, err)
+		b, _ := ioutil.ReadAll(gz)
+		body = string(b)
+	}))
+	defer server.Close()
+	w, err := NewWebhook(WebhookConfig{URL: server.URL, Username: "daemon", Password: "pass", Gzip: true})
+	assert.Nil(t, err)
+
+	_, err = w.Export(context.Background(), []string{`{"id": "1"}`})
+
+	assert.Nil(t, err)
+	assert.Equal(t, `[{"id":"1"}]`, body)
+}
+
+func TestWebhookStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	w, err := NewWebhook(WebhookConfig{URL: server.URL})
+	assert.Nil(t, err)
+
+	unprocessed, err := w.Export(context.Background(), []string{`{"id": "1"}`})
+
+	assert.Nil(t, unprocessed)
+	se, ok := err.(*StatusError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusTooManyRequests, se.StatusCode)
+	assert.EqualError(t, err, "webhook exporter: endpoint returned 429 Too Many Requests: slow down")
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	w, err := NewWebhook(WebhookConfig{URL: server.URL, Timeout: 10 * time.Millisecond})
+	assert.Nil(t, err)
+
+	_, err = w.Export(context.Background(), []string{`{"id": "1"}`})
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Timeout")
+}
+
+func TestWebhookConnectionRefused(t *testing.T) {
+	w, err := NewWebhook(WebhookConfig{URL: "http://127.0.0.1:1"})
+	assert.Nil(t, err)
+
+	_, err = w.Export(context.Background(), []string{`{"id": "1"}`})
+
+	var ne net.Error
+	assert.True(t, errors.As(err, &ne), "Transport errors are wrapped: %v", err)
+}
+
+func TestNewWebhookInvalidConfig(t *testing.T) {
+	_, err := NewWebhook(WebhookConfig{URL: "example.com/ingest"})
+	assert.EqualError(t, err, `webhook exporter: invalid url "example.com/ingest"`)
+	_, err = NewWebhook(WebhookConfig{URL: "https://example.com/ingest", BearerToken: "secret", Username: "daemon"})
+	assert.NotNil(t, err)
+}
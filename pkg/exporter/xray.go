@@ -0,0 +1,52 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/xray"
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	"github.com/aws/aws-xray-daemon/pkg/conn"
+)
+
+// XRay exports segment documents to AWS X-Ray.
+type XRay struct {
+	client conn.XRay
+}
+
+// NewXRay returns an exporter sending segment documents with client.
+func NewXRay(client conn.XRay) *XRay {
+	return &XRay{
+		client: client,
+	}
+}
+
+// Export sends docs to X-Ray service in a single PutTraceSegments request, made once: failed batches
+// are retried by the daemon, within its retry budget, so SDK retries would multiply its attempts.
+func (x *XRay) Export(ctx context.Context, docs []string) ([]types.UnprocessedTraceSegment, error) {
+	r, err := x.client.PutTraceSegments(ctx, &xray.PutTraceSegmentsInput{
+		TraceSegmentDocuments: docs,
+	}, withoutRetries)
+	if err != nil {
+		return nil, err
+	}
+	return r.UnprocessedTraceSegments, nil
+}
+
+// withoutRetries makes a single attempt of an X-Ray request.
+func withoutRetries(o *xray.Options) {
+	o.RetryMaxAttempts = 1
+}
+
+// Close does nothing, X-Ray exporter holds no documents.
+func (x *XRay) Close() error {
+	return nil
+}
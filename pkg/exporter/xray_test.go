@@ -0,0 +1,63 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/xray"
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockXRayClient struct {
+	input   *xray.PutTraceSegmentsInput
+	options xray.Options
+	output  *xray.PutTraceSegmentsOutput
+	err     error
+}
+
+func (c *mockXRayClient) PutTraceSegments(ctx context.Context, input *xray.PutTraceSegmentsInput, opts ...func(*xray.Options)) (*xray.PutTraceSegmentsOutput, error) {
+	c.input = input
+	for _, opt := range opts {
+		opt(&c.options)
+	}
+	return c.output, c.err
+}
+
+func (c *mockXRayClient) PutTelemetryRecords(ctx context.Context, input *xray.PutTelemetryRecordsInput, opts ...func(*xray.Options)) (*xray.PutTelemetryRecordsOutput, error) {
+	return nil, nil
+}
+
+func TestXRayExport(t *testing.T) {
+	unprocessed := []types.UnprocessedTraceSegment{{Id: aws.String("9472"), ErrorCode: aws.String("InvalidSegment")}}
+	client := &mockXRayClient{output: &xray.PutTraceSegmentsOutput{UnprocessedTraceSegments: unprocessed}}
+	x := NewXRay(client)
+
+	r, err := x.Export(context.Background(), []string{"{\"id\":\"9472\"}", "{\"id\":\"9473\"}"})
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, unprocessed, r)
+	assert.EqualValues(t, []string{"{\"id\":\"9472\"}", "{\"id\":\"9473\"}"}, client.input.TraceSegmentDocuments)
+	assert.EqualValues(t, 1, client.options.RetryMaxAttempts, "Failed batches are retried by the daemon, not the SDK")
+	assert.Nil(t, x.Close())
+}
+
+func TestXRayExportError(t *testing.T) {
+	client := &mockXRayClient{output: &xray.PutTraceSegmentsOutput{}, err: errors.New("connection timeout")}
+
+	r, err := NewXRay(client).Export(context.Background(), []string{"{}"})
+
+	assert.EqualError(t, err, "connection timeout")
+	assert.Nil(t, r)
+}
@@ -0,0 +1,166 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+// Package filter drops segments matching configured rules before they are batched.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-xray-daemon/pkg/cfg"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	log "github.com/cihub/seelog"
+)
+
+const annotationsPrefix = "annotations."
+
+// Filter evaluates segment documents against a set of rules.
+type Filter struct {
+	// Compiled rules, a segment matching any of them is dropped.
+	rules []*rule
+
+	// DryRun, if true, only counts matching segments.
+	dryRun bool
+
+	// Counter for segments matching a rule.
+	matched uint64
+
+	// Counter for segments dropped.
+	dropped uint64
+}
+
+type rule struct {
+	field   string
+	pattern *regexp.Regexp
+}
+
+// New returns a Filter for the given rules. An error is returned if a rule has
+// an unknown field or match type, or its pattern does not compile.
+func New(rules []cfg.FilterRule, dryRun bool) (*Filter, error) {
+	f := &Filter{
+		rules:  make([]*rule, 0, len(rules)),
+		dryRun: dryRun,
+	}
+	for _, r := range rules {
+		if !IsValidField(r.Field) {
+			return nil, fmt.Errorf("filter: unknown field %q", r.Field)
+		}
+		expr, ok := Expression(r.Pattern, r.Match)
+		if !ok {
+			return nil, fmt.Errorf("filter: unknown match type %q for field %q", r.Match, r.Field)
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("filter: invalid pattern for field %q: %v", r.Field, err)
+		}
+		f.rules = append(f.rules, &rule{field: r.Field, pattern: pattern})
+	}
+	return f, nil
+}
+
+// Enabled returns true if the filter has at least one rule.
+func (f *Filter) Enabled() bool {
+	return f != nil && len(f.rules) > 0
+}
+
+// Drop returns true if segment ts matches a rule and should be dropped.
+// In dry-run mode matches are counted but Drop always returns false.
+// Segments that cannot be parsed are never dropped.
+func (f *Filter) Drop(ts *tracesegment.TraceSegment) bool {
+	if !f.Enabled() {
+		return false
+	}
+	doc, err := ts.Document()
+	if err != nil {
+		log.Debugf("filter: unable to parse segment: %v", err)
+		return false
+	}
+	for _, r := range f.rules {
+		if r.matches(doc) {
+			atomic.AddUint64(&f.matched, 1)
+			if f.dryRun {
+				log.Debugf("filter: segment %v matches rule on %v (dry run)", doc.ID, r.field)
+				return false
+			}
+			atomic.AddUint64(&f.dropped, 1)
+			return true
+		}
+	}
+	return false
+}
+
+// MatchedCount returns number of segments matching a rule.
+func (f *Filter) MatchedCount() uint64 {
+	return atomic.LoadUint64(&f.matched)
+}
+
+// DroppedCount returns number of segments dropped.
+func (f *Filter) DroppedCount() uint64 {
+	return atomic.LoadUint64(&f.dropped)
+}
+
+func (r *rule) matches(doc *tracesegment.Document) bool {
+	value, ok := FieldValue(doc, r.field)
+	if !ok {
+		return false
+	}
+	return r.pattern.MatchString(value)
+}
+
+// FieldValue returns the value of field in doc, false if the field is not set.
+func FieldValue(doc *tracesegment.Document, field string) (string, bool) {
+	switch field {
+	case "name":
+		return doc.Name, doc.Name != ""
+	case "origin":
+		return doc.Origin, doc.Origin != ""
+	case "http.request.url":
+		return doc.HTTP.Request.URL, doc.HTTP.Request.URL != ""
+	case "http.request.method":
+		return doc.HTTP.Request.Method, doc.HTTP.Request.Method != ""
+	}
+	value, ok := doc.Annotations[strings.TrimPrefix(field, annotationsPrefix)]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+// IsValidField returns true if field is a document field rules can match on.
+func IsValidField(field string) bool {
+	switch field {
+	case "name", "origin", "http.request.url", "http.request.method":
+		return true
+	}
+	return strings.HasPrefix(field, annotationsPrefix) && len(field) > len(annotationsPrefix)
+}
+
+// Expression returns the regular expression matching pattern with match type match, glob or regex,
+// false if the match type is unknown. Glob is used if match is empty.
+func Expression(pattern string, match string) (string, bool) {
+	switch strings.ToLower(match) {
+	case "", "glob":
+		return globToRegexp(pattern), true
+	case "regex":
+		return pattern, true
+	}
+	return "", false
+}
+
+// globToRegexp converts glob pattern p into an anchored regular expression.
+// '*' matches any sequence of characters, including '/', and '?' matches a single character.
+func globToRegexp(p string) string {
+	expr := regexp.QuoteMeta(p)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	return "^" + expr + "$"
+}
@@ -0,0 +1,114 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package filter
+
+import (
+	"testing"
+
+	"github.com/aws/aws-xray-daemon/pkg/cfg"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestSegment(doc string) *tracesegment.TraceSegment {
+	raw := []byte(doc)
+	return &tracesegment.TraceSegment{
+		Raw: &raw,
+	}
+}
+
+var healthCheck = `{"trace_id":"1-5759e988-bd862e3fe1be46a994272793","id":"defdfd9912dc5a56","name":"web",` +
+	`"origin":"AWS::EC2::Instance","http":{"request":{"url":"http://localhost/health","method":"GET"}},` +
+	`"annotations":{"team":"checkout","retries":3}}`
+
+func TestNewWithInvalidField(t *testing.T) {
+	f, err := New([]cfg.FilterRule{{Field: "http.response.status", Pattern: "200"}}, false)
+
+	assert.Nil(t, f)
+	assert.EqualError(t, err, "filter: unknown field \"http.response.status\"")
+}
+
+func TestNewWithInvalidMatchType(t *testing.T) {
+	f, err := New([]cfg.FilterRule{{Field: "name", Pattern: "web", Match: "prefix"}}, false)
+
+	assert.Nil(t, f)
+	assert.EqualError(t, err, "filter: unknown match type \"prefix\" for field \"name\"")
+}
+
+func TestNewWithInvalidRegex(t *testing.T) {
+	f, err := New([]cfg.FilterRule{{Field: "name", Pattern: "web(", Match: "regex"}}, false)
+
+	assert.Nil(t, f)
+	assert.Contains(t, err.Error(), "filter: invalid pattern for field \"name\"")
+}
+
+func TestEnabled(t *testing.T) {
+	var nilFilter *Filter
+	empty, _ := New(nil, false)
+	f, _ := New([]cfg.FilterRule{{Field: "name", Pattern: "web"}}, false)
+
+	assert.False(t, nilFilter.Enabled())
+	assert.False(t, empty.Enabled())
+	assert.True(t, f.Enabled())
+	assert.False(t, nilFilter.Drop(getTestSegment(healthCheck)))
+}
+
+func TestDropMatchingRules(t *testing.T) {
+	testCases := []struct {
+		rule cfg.FilterRule
+		drop bool
+	}{
+		{cfg.FilterRule{Field: "name", Pattern: "web"}, true},
+		{cfg.FilterRule{Field: "name", Pattern: "we?"}, true},
+		{cfg.FilterRule{Field: "name", Pattern: "api"}, false},
+		{cfg.FilterRule{Field: "http.request.url", Pattern: "*/health"}, true},
+		{cfg.FilterRule{Field: "http.request.url", Pattern: "/health"}, false},
+		{cfg.FilterRule{Field: "http.request.url", Pattern: "/health$", Match: "regex"}, true},
+		{cfg.FilterRule{Field: "http.request.method", Pattern: "GET"}, true},
+		{cfg.FilterRule{Field: "http.request.method", Pattern: "POST"}, false},
+		{cfg.FilterRule{Field: "origin", Pattern: "AWS::EC2::*"}, true},
+		{cfg.FilterRule{Field: "annotations.team", Pattern: "checkout"}, true},
+		{cfg.FilterRule{Field: "annotations.retries", Pattern: "^[0-9]+$", Match: "regex"}, true},
+		{cfg.FilterRule{Field: "annotations.owner", Pattern: "*"}, false},
+	}
+	for _, testCase := range testCases {
+		f, err := New([]cfg.FilterRule{testCase.rule}, false)
+		assert.Nil(t, err)
+
+		assert.Equal(t, testCase.drop, f.Drop(getTestSegment(healthCheck)), "rule: %v", testCase.rule)
+	}
+}
+
+func TestDropCounters(t *testing.T) {
+	f, _ := New([]cfg.FilterRule{{Field: "name", Pattern: "api"}, {Field: "http.request.url", Pattern: "*/health"}}, false)
+
+	assert.True(t, f.Drop(getTestSegment(healthCheck)))
+	assert.False(t, f.Drop(getTestSegment(`{"name":"worker"}`)))
+
+	assert.EqualValues(t, 1, f.MatchedCount())
+	assert.EqualValues(t, 1, f.DroppedCount())
+}
+
+func TestDropDryRun(t *testing.T) {
+	f, _ := New([]cfg.FilterRule{{Field: "name", Pattern: "web"}}, true)
+
+	assert.False(t, f.Drop(getTestSegment(healthCheck)))
+	assert.False(t, f.Drop(getTestSegment(healthCheck)))
+
+	assert.EqualValues(t, 2, f.MatchedCount())
+	assert.EqualValues(t, 0, f.DroppedCount())
+}
+
+func TestDropInvalidDocument(t *testing.T) {
+	f, _ := New([]cfg.FilterRule{{Field: "name", Pattern: "*"}}, false)
+
+	assert.False(t, f.Drop(getTestSegment(`{"name": web}`)))
+	assert.EqualValues(t, 0, f.MatchedCount())
+}
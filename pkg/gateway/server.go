@@ -0,0 +1,261 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+// Package gateway provides the http server receiving segment batches forwarded by other daemons,
+// so only the gateway daemon holds AWS credentials and calls X-Ray.
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	log "github.com/cihub/seelog"
+)
+
+// Path batches are posted to.
+const Path = "/v1/segments"
+
+// ProxyPath is the prefix of the X-Ray API calls of SDKs proxied by forwarding daemons, such as
+// ProxyPath + "/GetSamplingRules".
+const ProxyPath = "/v1/xray"
+
+// ContentType of forwarded batches: newline delimited envelopes, or segment documents.
+const ContentType = "application/x-ndjson"
+
+// Time the server waits for requests being handled when closed.
+const shutdownTimeout = 5 * time.Second
+
+// Name of the gateway in telemetry counters.
+const counterName = "gateway"
+
+// Config describes the gateway server.
+type Config struct {
+	// Address the server listens on.
+	Address string
+
+	// Token forwarding daemons must send as bearer authorization, no authorization if empty, which is
+	// only allowed if Address is a loopback address.
+	Token string
+
+	// Certificate and key files of TLS, plain HTTP if empty.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Maximum size of a batch, after decompression.
+	MaxRequestBytes int64
+
+	// Proxy handles the X-Ray API calls proxied by forwarding daemons, stripped of ProxyPath.
+	// They are not served if nil.
+	Proxy http.Handler
+}
+
+// Server represents the gateway HTTP server.
+type Server struct {
+	*http.Server
+	config Config
+
+	// Accepts a forwarded segment document, received with header, into the pipeline of the daemon,
+	// returns false if it was dropped.
+	accept func(header tracesegment.Header, doc []byte) bool
+}
+
+// Envelope is a line of a forwarded batch: a segment document with the header it was received with.
+// Lines holding a bare segment document are accepted too, with an empty header.
+type Envelope struct {
+	Header  tracesegment.Header `json:"header"`
+	Segment json.RawMessage     `json:"segment"`
+}
+
+// Wrap returns the envelope of segment ts as a line of a forwarded batch. The raw document is
+// not validated, so the envelope of an invalid document is not valid JSON either.
+func Wrap(ts *tracesegment.TraceSegment) string {
+	header, _ := json.Marshal(ts.Header)
+	var b strings.Builder
+	b.Grow(len(header) + len(*ts.Raw) + 24)
+	b.WriteString(`{"header":`)
+	b.Write(header)
+	b.WriteString(`,"segment":`)
+	b.Write(*ts.Raw)
+	b.WriteString(`}`)
+	return b.String()
+}
+
+// Response is the body of the response to a forwarded batch.
+type Response struct {
+	// Number of documents accepted and dropped by the gateway.
+	Accepted int `json:"accepted"`
+	Dropped  int `json:"dropped"`
+}
+
+// NewServer returns a gateway server described by c, handing every forwarded segment document to accept.
+// A server without token is refused unless it listens on loopback only, as anyone reaching it could
+// send segments to X-Ray with the credentials of the daemon.
+func NewServer(c Config, accept func(header tracesegment.Header, doc []byte) bool) (*Server, error) {
+	if c.Token == "" && !isLoopback(c.Address) {
+		return nil, fmt.Errorf("gateway: a token is required to listen on %q, which is not a loopback address", c.Address)
+	}
+	s := &Server{
+		config: c,
+		accept: accept,
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, s)
+	if c.Proxy != nil {
+		mux.Handle(ProxyPath+"/", s.authorize(http.StripPrefix(ProxyPath, c.Proxy)))
+	}
+	s.Server = &http.Server{
+		Addr:    c.Address,
+		Handler: mux,
+	}
+	return s, nil
+}
+
+// isLoopback returns true if address, host:port, only accepts connections from the local host.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeHTTP accepts a batch of newline delimited envelopes or segment documents, optionally gzip compressed.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		log.Warnf("gateway: rejected unauthorized batch from %v", r.RemoteAddr)
+		telemetry.T.Count(counterName+".unauthorized", 1)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "invalid gzip body", http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+	// Read one byte more than the limit to tell a batch at the limit from a larger one.
+	batch, err := io.ReadAll(io.LimitReader(body, s.config.MaxRequestBytes+1))
+	if err != nil {
+		http.Error(w, "unable to read body", http.StatusBadRequest)
+		return
+	}
+	if int64(len(batch)) > s.config.MaxRequestBytes {
+		http.Error(w, "batch too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var resp Response
+	scanner := bufio.NewScanner(bytes.NewReader(batch))
+	scanner.Buffer(nil, len(batch)+1)
+	for scanner.Scan() {
+		doc := bytes.TrimSpace(scanner.Bytes())
+		if len(doc) == 0 {
+			continue
+		}
+		header, doc := unwrap(doc)
+		if s.accept(header, doc) {
+			resp.Accepted++
+		} else {
+			resp.Dropped++
+		}
+	}
+	telemetry.T.Count(counterName+".accepted", int64(resp.Accepted))
+	if resp.Dropped > 0 {
+		log.Warnf("gateway: dropped %d of %d segments forwarded by %v", resp.Dropped, resp.Accepted+resp.Dropped, r.RemoteAddr)
+		telemetry.T.Count(counterName+".dropped", int64(resp.Dropped))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// unwrap returns the header and segment document of line, an empty header and line itself if line is not an envelope.
+func unwrap(line []byte) (tracesegment.Header, []byte) {
+	var e Envelope
+	if err := json.Unmarshal(line, &e); err != nil || len(e.Segment) == 0 {
+		return tracesegment.Header{}, line
+	}
+	return e.Header, e.Segment
+}
+
+// authorize returns a handler passing requests carrying the bearer token of the gateway to h.
+func (s *Server) authorize(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			log.Warnf("gateway: rejected unauthorized proxy request from %v", r.RemoteAddr)
+			telemetry.T.Count(counterName+".unauthorized", 1)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// authorized returns true if r carries the bearer token of the gateway.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.config.Token == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(s.config.Token)) == 1
+}
+
+// Serve starts server.
+func (s *Server) Serve() {
+	log.Infof("Starting gateway http server on %s", s.Addr)
+	var err error
+	if s.config.TLSCertFile != "" {
+		err = s.ListenAndServeTLS(s.config.TLSCertFile, s.config.TLSKeyFile)
+	} else {
+		err = s.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("gateway http server failed to listen: %v", err)
+	}
+}
+
+// Close stops server, waiting for the batches being received.
+func (s *Server) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Server.Shutdown(ctx); err != nil {
+		log.Errorf("unable to close the gateway server: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	"github.com/stretchr/testify/assert"
+)
+
+type acceptor struct {
+	docs    []string
+	headers []tracesegment.Header
+
+	// Number of documents accepted before the next ones are dropped, negative for no limit.
+	capacity int
+}
+
+func (a *acceptor) accept(header tracesegment.Header, doc []byte) bool {
+	if a.capacity >= 0 && len(a.docs) >= a.capacity {
+		return false
+	}
+	a.docs = append(a.docs, string(doc))
+	a.headers = append(a.headers, header)
+	return true
+}
+
+func newTestServer(a *acceptor) *Server {
+	telemetry.T = telemetry.GetTestTelemetry()
+	s, _ := NewServer(Config{Address: "127.0.0.1:0", Token: "secret", MaxRequestBytes: 1024}, a.accept)
+	return s
+}
+
+func post(s *Server, token string, encoding string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, Path, bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, req)
+	return w
+}
+
+func gzipBody(body string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(body))
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestServerAcceptsGzipBatch(t *testing.T) {
+	a := &acceptor{capacity: -1}
+	s := newTestServer(a)
+
+	w := post(s, "secret", "gzip", gzipBody("{\"id\":\"1\"}\n\n{\"id\":\"2\"}\n"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{`{"id":"1"}`, `{"id":"2"}`}, a.docs)
+	var resp Response
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, Response{Accepted: 2}, resp)
+	assert.EqualValues(t, 2, telemetry.T.Counter("gateway.accepted"))
+}
+
+func TestServerAcceptsEnvelopes(t *testing.T) {
+	a := &acceptor{capacity: -1}
+	s := newTestServer(a)
+	raw := []byte(`{"id":"1","name":"web"}`)
+	envelope := Wrap(&tracesegment.TraceSegment{Raw: &raw, Header: tracesegment.Header{Format: "json", Version: 1, Tenant: "payments", Priority: true}})
+
+	w := post(s, "secret", "", []byte(envelope+"\n{\"id\":\"2\"}\n"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{`{"id":"1","name":"web"}`, `{"id":"2"}`}, a.docs)
+	assert.Equal(t, []tracesegment.Header{{Format: "json", Version: 1, Tenant: "payments", Priority: true}, {}}, a.headers)
+}
+
+func TestServerReportsDroppedDocuments(t *testing.T) {
+	a := &acceptor{capacity: 1}
+	s := newTestServer(a)
+
+	w := post(s, "secret", "", []byte("{\"id\":\"1\"}\n{\"id\":\"2\"}"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp Response
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, Response{Accepted: 1, Dropped: 1}, resp)
+	assert.EqualValues(t, 1, telemetry.T.Counter("gateway.dropped"))
+}
+
+func TestServerRejectsUnauthorizedBatch(t *testing.T) {
+	a := &acceptor{capacity: -1}
+	s := newTestServer(a)
+
+	assert.Equal(t, http.StatusUnauthorized, post(s, "", "", []byte(`{"id":"1"}`)).Code)
+	assert.Equal(t, http.StatusUnauthorized, post(s, "guess", "", []byte(`{"id":"1"}`)).Code)
+	assert.Empty(t, a.docs)
+	assert.EqualValues(t, 2, telemetry.T.Counter("gateway.unauthorized"))
+}
+
+func TestServerWithoutToken(t *testing.T) {
+	a := &acceptor{capacity: -1}
+	telemetry.T = telemetry.GetTestTelemetry()
+	s, err := NewServer(Config{Address: "127.0.0.1:2002", MaxRequestBytes: 1024}, a.accept)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, post(s, "", "", []byte(`{"id":"1"}`)).Code)
+	assert.Len(t, a.docs, 1)
+}
+
+func TestServerWithoutTokenListensOnLoopbackOnly(t *testing.T) {
+	for address, loopback := range map[string]bool{
+		"127.0.0.1:2002": true,
+		"[::1]:2002":     true,
+		"localhost:2002": true,
+		":2002":          false,
+		"0.0.0.0:2002":   false,
+		"10.0.0.1:2002":  false,
+	} {
+		_, err := NewServer(Config{Address: address, MaxRequestBytes: 1024}, (&acceptor{}).accept)
+		assert.Equal(t, loopback, err == nil, address)
+
+		_, err = NewServer(Config{Address: address, Token: "secret", MaxRequestBytes: 1024}, (&acceptor{}).accept)
+		assert.Nil(t, err, address)
+	}
+}
+
+func TestServerRejectsInvalidRequests(t *testing.T) {
+	a := &acceptor{capacity: -1}
+	s := newTestServer(a)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(s, "secret", "gzip", gzipBody(strings.Repeat("x", 1025))).Code)
+	assert.Equal(t, http.StatusBadRequest, post(s, "secret", "gzip", []byte("not gzip")).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, post(s, "secret", "br", []byte("{}")).Code)
+
+	req := httptest.NewRequest(http.MethodGet, Path, nil)
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Empty(t, a.docs)
+}
+
+func TestServerProxiesAuthorizedRequests(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	var paths []string
+	proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	})
+	s, _ := NewServer(Config{Token: "secret", MaxRequestBytes: 1024, Proxy: proxy}, (&acceptor{}).accept)
+
+	request := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, ProxyPath+"/GetSamplingRules", strings.NewReader("{}"))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request("secret"))
+	assert.Equal(t, http.StatusUnauthorized, request("guess"))
+	assert.Equal(t, []string{"/GetSamplingRules"}, paths)
+}
@@ -0,0 +1,81 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+// Package overflow defines how a full segment buffer or batch queue makes room for new data.
+package overflow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Policy is applied when a buffer is full.
+type Policy int
+
+const (
+	// DropOldest drops the oldest item in the buffer to make room for the new one.
+	DropOldest Policy = iota
+
+	// DropNewest drops the new item and keeps the buffer as is.
+	DropNewest
+
+	// Block waits for room in the buffer up to a timeout, then drops the new item.
+	Block
+
+	// Spill writes the new item to secondary storage.
+	Spill
+)
+
+var policyNames = map[Policy]string{
+	DropOldest: "drop-oldest",
+	DropNewest: "drop-newest",
+	Block:      "block",
+	Spill:      "spill",
+}
+
+// String returns the configuration name of policy p.
+func (p Policy) String() string {
+	return policyNames[p]
+}
+
+// Counter returns the name of the telemetry counter tracking policy p for the given buffer.
+func (p Policy) Counter(buffer string) string {
+	return buffer + ".overflow." + p.String()
+}
+
+// ParsePolicy returns the Policy with configuration name s. Empty s returns DropOldest.
+func ParsePolicy(s string) (Policy, error) {
+	if s == "" {
+		return DropOldest, nil
+	}
+	for policy, name := range policyNames {
+		if strings.EqualFold(name, s) {
+			return policy, nil
+		}
+	}
+	return DropOldest, fmt.Errorf("overflow: unknown policy %q", s)
+}
+
+// Spiller stores segment documents that do not fit in memory.
+type Spiller interface {
+	Spill(docs []string) error
+}
+
+// Config describes the overflow behavior of a buffer.
+type Config struct {
+	// Policy applied when the buffer is full.
+	Policy Policy
+
+	// Maximum time the Block policy waits for room in the buffer.
+	BlockTimeout time.Duration
+
+	// Secondary storage used by the Spill policy.
+	Spiller Spiller
+}
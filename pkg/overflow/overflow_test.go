@@ -0,0 +1,43 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package overflow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePolicy(t *testing.T) {
+	testCases := map[string]Policy{
+		"":            DropOldest,
+		"drop-oldest": DropOldest,
+		"drop-newest": DropNewest,
+		"Block":       Block,
+		"SPILL":       Spill,
+	}
+	for name, expected := range testCases {
+		policy, err := ParsePolicy(name)
+
+		assert.Nil(t, err)
+		assert.Equal(t, expected, policy, name)
+	}
+}
+
+func TestParsePolicyUnknown(t *testing.T) {
+	_, err := ParsePolicy("drop-random")
+
+	assert.EqualError(t, err, "overflow: unknown policy \"drop-random\"")
+}
+
+func TestPolicyCounter(t *testing.T) {
+	assert.Equal(t, "ringbuffer.overflow.drop-oldest", DropOldest.Counter("ringbuffer"))
+	assert.Equal(t, "batch.overflow.block", Block.Counter("batch"))
+}
@@ -0,0 +1,110 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	log "github.com/cihub/seelog"
+)
+
+// Number of batches of segments held by trace affinity batching before they are sent, whatever their age.
+const affinityBatches = 10
+
+// traceGroups holds the segments pending in the batching window, grouped by trace id.
+type traceGroups struct {
+	// Trace ids, in order of arrival of the first segment of each trace.
+	order []string
+
+	groups map[string]*traceGroup
+
+	// Number of segments held.
+	count int
+}
+
+type traceGroup struct {
+	segments []*tracesegment.TraceSegment
+
+	// Size in bytes of the segments once encoded in a PutTraceSegments request.
+	bytes int
+}
+
+func newTraceGroups() *traceGroups {
+	return &traceGroups{
+		groups: make(map[string]*traceGroup),
+	}
+}
+
+// add holds segment ts with the other segments of its trace. Segments which cannot be
+// parsed are grouped together.
+func (g *traceGroups) add(ts *tracesegment.TraceSegment) {
+	var traceID string
+	if doc, err := ts.Document(); err == nil {
+		traceID = doc.TraceID
+	} else {
+		log.Debugf("processor: unable to read trace id of segment: %v", err)
+	}
+	group, ok := g.groups[traceID]
+	if !ok {
+		group = &traceGroup{}
+		g.groups[traceID] = group
+		g.order = append(g.order, traceID)
+	}
+	group.segments = append(group.segments, ts)
+	group.bytes += encodedSize(*ts.Raw)
+	g.count++
+}
+
+// len returns the number of segments held, 0 if g is nil.
+func (g *traceGroups) len() int {
+	if g == nil {
+		return 0
+	}
+	return g.count
+}
+
+// reset removes every segment held.
+func (g *traceGroups) reset() {
+	for i := range g.order {
+		g.order[i] = ""
+	}
+	g.order = g.order[0:0]
+	g.groups = make(map[string]*traceGroup)
+	g.count = 0
+}
+
+// sendTraceGroups sends the segments held by trace affinity batching, in as few batches as the
+// limits allow, the segments of a trace in the same batch unless they exceed the limits of one.
+func (p *Processor) sendTraceGroups(batch []*tracesegment.TraceSegment) []*tracesegment.TraceSegment {
+	log.Debugf("processor: sending %d segments of %d traces", p.affinity.count, len(p.affinity.order))
+	for _, traceID := range p.affinity.order {
+		group := p.affinity.groups[traceID]
+		if len(batch) > 0 && !p.fits(len(batch)+len(group.segments), p.batchBytes+group.bytes) {
+			batch = p.sendBatchAsync(batch)
+		}
+		for _, ts := range group.segments {
+			size := encodedSize(*ts.Raw)
+			if len(batch) > 0 && !p.fits(len(batch)+1, p.batchBytes+size) {
+				batch = p.sendBatchAsync(batch)
+			}
+			batch = append(batch, ts)
+			p.batchBytes += size
+		}
+	}
+	p.affinity.reset()
+	if len(batch) > 0 {
+		batch = p.sendBatchAsync(batch)
+	}
+	return batch
+}
+
+// fits returns true if count segments of bytes in size fit in one batch.
+func (p *Processor) fits(count int, bytes int) bool {
+	return count <= p.batchSize && (p.maxBatchBytes <= 0 || requestOverhead+bytes <= p.maxBatchBytes)
+}
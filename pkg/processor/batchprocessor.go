@@ -11,12 +11,18 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"math/rand"
 	"regexp"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/xray"
-	"github.com/aws/aws-xray-daemon/pkg/conn"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	"github.com/aws/aws-xray-daemon/pkg/breaker"
+	"github.com/aws/aws-xray-daemon/pkg/deadletter"
+	"github.com/aws/aws-xray-daemon/pkg/exporter"
+	"github.com/aws/aws-xray-daemon/pkg/overflow"
 	"github.com/aws/aws-xray-daemon/pkg/telemetry"
 	"github.com/aws/aws-xray-daemon/pkg/util/timer"
 	log "github.com/cihub/seelog"
@@ -25,6 +31,9 @@ import (
 var /* const */ segIdRegexp = regexp.MustCompile(`\"id\":\"(.*?)\"`)
 var /* const */ traceIdRegexp = regexp.MustCompile(`\"trace_id\":\"(.*?)\"`)
 
+// Name of the batch queue used in telemetry counters.
+const counterName = "batch"
+
 // Structure for trace segments batch.
 type segmentsBatch struct {
 	// Boolean channel set to true when processing the batch segments is done.
@@ -33,57 +42,178 @@ type segmentsBatch struct {
 	// String slice of trace segments.
 	batches chan []string
 
-	// Instance of XRay, used to send data to X-Ray service.
-	xRay conn.XRay
+	// Exporter sending batches to X-Ray service.
+	exporter exporter.Exporter
 
 	// Random generator, used for back off logic in case of exceptions.
 	randGen *rand.Rand
 
+	// Lock guarding randGen, shared by the poll go routines.
+	randLock sync.Mutex
+
 	// Instance of timer.
 	timer timer.Timer
+
+	// Overflow policy applied when the batches channel is full.
+	overflow overflow.Config
+
+	// Bounds of the retries of a failed batch.
+	retry retryConfig
+
+	// Budget shared by the retries of all poll go routines, nil disables retries.
+	budget *retryBudget
+
+	// Lock guarding resubmits.
+	resubmitLock sync.Mutex
+
+	// Number of times unprocessed segments were resubmitted, keyed by segment id.
+	resubmits map[string]int
+
+	// Lock guarding closed, held while queueing resubmitted segments.
+	closeLock sync.RWMutex
+
+	// Boolean, set to true once the batches channel is closed.
+	closed bool
+
+	// Spool storing batches which cannot be delivered, nil drops them.
+	spool Spool
+
+	// Set to 1 when the spool may hold segments to replay.
+	spoolPending int32
+
+	// Channel signalled when a batch is delivered, to replay spooled segments.
+	reachable chan struct{}
+
+	// Channel closed to stop the replay of spooled segments.
+	stop chan struct{}
+
+	// Interval between attempts to replay spooled segments.
+	replayInterval time.Duration
+
+	// Size of the batches of spooled segments replayed.
+	replayBatchSize int
+
+	// Circuit breaker around X-Ray service, nil if disabled.
+	breaker *breaker.Breaker
+
+	// Boolean, set to true to spool batches while the breaker is open instead of dropping them.
+	spoolOnOpen bool
+
+	// Adaptive limit of the calls in flight to X-Ray service, nil if disabled.
+	limiter *concurrencyLimiter
+
+	// Limit of the requests and segments per second sent to X-Ray service, nil if disabled.
+	rateLimiter *rateLimiter
 }
 
+// send sends batch to the batches channel.
+// If the channel is full, the batch is handled by the overflow policy.
 func (s *segmentsBatch) send(batch []string) {
+	select {
+	case s.batches <- batch:
+		return
+	default:
+	}
+	switch s.overflow.Policy {
+	case overflow.DropNewest:
+		s.drop(batch, overflow.DropNewest.Counter(counterName))
+	case overflow.Block:
+		s.block(batch)
+	case overflow.Spill:
+		s.spill(batch)
+	default:
+		s.dropOldest(batch)
+	}
+}
+
+func (s *segmentsBatch) dropOldest(batch []string) {
 	select {
 	case s.batches <- batch:
 
 	default:
 		select {
 		case batchTruncated := <-s.batches:
+			deadletter.D.RecordBatch(deadletter.ReasonBatchQueueFull, "", batchTruncated)
 			telemetry.T.SegmentSpillover(int64(len(batchTruncated)))
+			telemetry.T.Count(overflow.DropOldest.Counter(counterName), int64(len(batchTruncated)))
 			log.Warnf("Spilling over %v segments", len(batchTruncated))
 
 		default:
 			log.Debug("Segment batch: channel is de-queued")
 		}
 		log.Debug("Segment batch: retrying batch")
-		s.send(batch)
+		s.dropOldest(batch)
 	}
 }
 
-func (s *segmentsBatch) poll() {
-	ctx := context.Background()
+// drop drops batch and increments telemetry counter.
+func (s *segmentsBatch) drop(batch []string, counter string) {
+	deadletter.D.RecordBatch(deadletter.ReasonBatchQueueFull, "", batch)
+	telemetry.T.SegmentSpillover(int64(len(batch)))
+	telemetry.T.Count(counter, int64(len(batch)))
+	log.Warnf("Segment batch queue is full. Dropping newest %v segments", len(batch))
+}
+
+// block waits for room in the batches channel up to the block timeout, then drops batch.
+func (s *segmentsBatch) block(batch []string) {
+	telemetry.T.Count(overflow.Block.Counter(counterName), int64(len(batch)))
+	timer := time.NewTimer(s.overflow.BlockTimeout)
+	defer timer.Stop()
+	select {
+	case s.batches <- batch:
+	case <-timer.C:
+		s.drop(batch, overflow.Block.Counter(counterName)+"-timeout")
+	}
+}
+
+// spill writes batch to secondary storage, and drops it if that fails.
+func (s *segmentsBatch) spill(batch []string) {
+	if err := s.overflow.Spiller.Spill(batch); err != nil {
+		log.Errorf("Unable to spill segment batch: %v", err)
+		s.drop(batch, overflow.Spill.Counter(counterName)+"-failed")
+		return
+	}
+	log.Debugf("Segment batch queue is full. Spilled newest %v segments", len(batch))
+	telemetry.T.Count(overflow.Spill.Counter(counterName), int64(len(batch)))
+}
+
+// poll sends batches until the batches channel is closed. Batches left once ctx is cancelled are abandoned.
+func (s *segmentsBatch) poll(ctx context.Context) {
 	for {
 		batch, ok := <-s.batches
 		if ok {
-			params := &xray.PutTraceSegmentsInput{
-				TraceSegmentDocuments: batch,
+			if ctx.Err() != nil {
+				s.abandon(batch)
+				continue
+			}
+			if s.breaker != nil && !s.breaker.Allow() {
+				s.fallback(batch)
+				continue
 			}
 			start := time.Now()
 			// send segment to X-Ray service.
-			r, err := s.xRay.PutTraceSegments(ctx, params)
+			unprocessed, err := s.export(ctx, batch)
+			if err != nil && ctx.Err() != nil {
+				s.abandon(batch)
+				continue
+			}
 			if err != nil {
 				telemetry.EvaluateConnectionError(err)
 				log.Errorf("Sending segment batch failed with: %v", err)
+				// Batches X-Ray rejected would be rejected again, others are kept through outages.
+				if s.spool != nil && !isRejected(err) {
+					s.spoolBatch(batch)
+				}
 				continue
 			} else {
 				telemetry.T.SegmentSent(int64(len(batch)))
+				s.signalReachable()
 			}
 			elapsed := time.Since(start)
 
-			if len(r.UnprocessedTraceSegments) != 0 {
+			if len(unprocessed) != 0 {
 				log.Infof("Sent batch of %d segments but had %d Unprocessed segments (%1.3f seconds)", len(batch),
-					len(r.UnprocessedTraceSegments), elapsed.Seconds())
+					len(unprocessed), elapsed.Seconds())
 				batchesMap := make(map[string]string)
 				for i := 0; i < len(batch); i++ {
 					segIdStrs := segIdRegexp.FindStringSubmatch(batch[i])
@@ -93,23 +223,41 @@ func (s *segmentsBatch) poll() {
 					}
 					batchesMap[segIdStrs[1]] = batch[i]
 				}
-				for _, unprocessedSegment := range r.UnprocessedTraceSegments {
-					telemetry.T.SegmentRejected(1)
+				var resubmit []string
+				resubmitted := make(map[string]bool)
+				for _, unprocessedSegment := range unprocessed {
 					// Print all segments since don't know which exact one is invalid.
 					if unprocessedSegment.Id == nil {
+						telemetry.T.SegmentRejected(1)
 						log.Debugf("Received nil unprocessed segment id from X-Ray service: %v", unprocessedSegment)
-						log.Debugf("Content in this batch: %v", params)
+						log.Debugf("Content in this batch: %v", batch)
 						break
 					}
-					traceIdStrs := traceIdRegexp.FindStringSubmatch(batchesMap[*unprocessedSegment.Id])
+					doc, found := batchesMap[*unprocessedSegment.Id]
+					if found && isTransient(unprocessedSegment.ErrorCode) {
+						if s.resubmittable(*unprocessedSegment.Id) {
+							log.Debugf("Resubmitting unprocessed segment %v failed with: %v", *unprocessedSegment.Id, *unprocessedSegment.ErrorCode)
+							resubmitted[*unprocessedSegment.Id] = true
+							resubmit = append(resubmit, doc)
+							continue
+						}
+						deadletter.D.RecordBatch(deadletter.ReasonResubmitExhausted, *unprocessedSegment.ErrorCode, []string{doc})
+					} else if found {
+						s.reject(doc, unprocessedSegment.ErrorCode)
+					}
+					telemetry.T.SegmentRejected(1)
+					traceIdStrs := traceIdRegexp.FindStringSubmatch(doc)
 					if len(traceIdStrs) != 2 {
 						log.Errorf("Unprocessed segment: %v", unprocessedSegment)
 					} else {
 						log.Errorf("Unprocessed trace %v, segment: %v", traceIdStrs[1], unprocessedSegment)
 					}
-					log.Debugf(batchesMap[*unprocessedSegment.Id])
+					log.Debugf(doc)
 				}
+				s.forget(batch, resubmitted)
+				s.resubmit(resubmit)
 			} else {
+				s.forget(batch, nil)
 				log.Infof("Successfully sent batch of %d segments (%1.3f seconds)", len(batch), elapsed.Seconds())
 			}
 		} else {
@@ -120,6 +268,215 @@ func (s *segmentsBatch) poll() {
 	}
 }
 
+// export sends batch to X-Ray service, retrying retryable errors
+// with exponential backoff and full jitter while the retry budget allows.
+func (s *segmentsBatch) export(ctx context.Context, batch []string) ([]types.UnprocessedTraceSegment, error) {
+	for attempt := 0; ; attempt++ {
+		r, err := s.call(ctx, batch)
+		if err == nil {
+			if s.budget != nil {
+				s.budget.deposit()
+			}
+			return r, nil
+		}
+		if s.budget == nil || !isRetryable(err) {
+			return r, err
+		}
+		if s.spool != nil && s.isClosed() {
+			// Spooled right away so shutdown is not delayed.
+			return r, err
+		}
+		if s.breaker != nil && !s.breaker.Allow() {
+			return r, err
+		}
+		if attempt >= s.retry.maxRetries {
+			telemetry.T.Count(counterName+".retry-exhausted", 1)
+			return r, err
+		}
+		if !s.budget.withdraw() {
+			telemetry.T.Count(counterName+".retry-budget-exhausted", 1)
+			return r, err
+		}
+		telemetry.EvaluateConnectionError(err)
+		telemetry.T.Count(counterName+".retry", 1)
+		delay := s.backoff(attempt)
+		log.Warnf("Sending segment batch failed with: %v. Retrying in %v", err, delay)
+		select {
+		case <-s.timer.After(delay):
+		case <-ctx.Done():
+			return r, ctx.Err()
+		}
+	}
+}
+
+// call sends batch to X-Ray service once, within the rate and concurrency limits, and reports the outcome.
+func (s *segmentsBatch) call(ctx context.Context, batch []string) ([]types.UnprocessedTraceSegment, error) {
+	if s.rateLimiter != nil {
+		// Batches over the limit wait here, so further batches queue up and follow the overflow policy.
+		if wait := s.rateLimiter.reserve(len(batch)); wait > 0 {
+			telemetry.T.Count(counterName+".rate-limited", 1)
+			select {
+			case <-s.timer.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	if s.limiter != nil {
+		if err := s.limiter.acquire(ctx); err != nil {
+			return nil, err
+		}
+	}
+	start := time.Now()
+	r, err := s.exporter.Export(ctx, batch)
+	if s.limiter != nil {
+		s.limiter.release(time.Since(start), err)
+	}
+	if s.rateLimiter != nil {
+		if err == nil {
+			s.rateLimiter.succeeded()
+		} else if isThrottled(err) {
+			s.rateLimiter.throttled()
+		}
+	}
+	s.report(err)
+	return r, err
+}
+
+// report records the outcome of a call to X-Ray service in the circuit breaker.
+// Calls which got no response, 5xx responses and throttling count as failures. Other errors X-Ray service
+// answered show it is reachable, and count as successes, as do cancelled calls.
+func (s *segmentsBatch) report(err error) {
+	if s.breaker == nil {
+		return
+	}
+	if err != nil && !errors.Is(err, context.Canceled) && !isRejected(err) {
+		s.breaker.Failure()
+	} else {
+		s.breaker.Success()
+	}
+}
+
+// fallback spools or drops batch while the circuit breaker is open.
+func (s *segmentsBatch) fallback(batch []string) {
+	if s.spoolOnOpen && s.spool != nil {
+		s.spoolBatch(batch)
+		return
+	}
+	log.Warnf("Circuit breaker is open. Dropping batch of %d segments", len(batch))
+	deadletter.D.RecordBatch(deadletter.ReasonBreakerOpen, "", batch)
+	telemetry.T.SegmentSpillover(int64(len(batch)))
+	telemetry.T.Count(counterName+".breaker-open", int64(len(batch)))
+}
+
+// abandon spools batch, left once uploads were cancelled at shutdown, or drops it if there is no spool.
+func (s *segmentsBatch) abandon(batch []string) {
+	if s.spool != nil {
+		s.spoolBatch(batch)
+		return
+	}
+	log.Warnf("Uploads are cancelled. Dropping batch of %d segments", len(batch))
+	deadletter.D.RecordBatch(deadletter.ReasonShutdown, "", batch)
+	telemetry.T.SegmentSpillover(int64(len(batch)))
+	telemetry.T.Count(counterName+".shutdown", int64(len(batch)))
+}
+
+// backoff returns a random delay between 0 and the exponential backoff of the given attempt, capped at the maximum delay.
+func (s *segmentsBatch) backoff(attempt int) time.Duration {
+	s.randLock.Lock()
+	defer s.randLock.Unlock()
+	return s.retry.backoff(attempt, s.randGen)
+}
+
+// resubmittable returns true if the unprocessed segment with id may be resubmitted, false once
+// it was resubmitted the maximum number of retries.
+func (s *segmentsBatch) resubmittable(id string) bool {
+	s.resubmitLock.Lock()
+	defer s.resubmitLock.Unlock()
+	if s.resubmits[id] >= s.retry.maxRetries {
+		delete(s.resubmits, id)
+		telemetry.T.Count(counterName+".unprocessed.resubmit-exhausted", 1)
+		return false
+	}
+	if s.resubmits == nil {
+		s.resubmits = make(map[string]int)
+	}
+	s.resubmits[id]++
+	return true
+}
+
+// forget clears the resubmission count of segments sent in batch, except those resubmitted again.
+func (s *segmentsBatch) forget(batch []string, resubmitted map[string]bool) {
+	s.resubmitLock.Lock()
+	defer s.resubmitLock.Unlock()
+	if len(s.resubmits) == 0 {
+		return
+	}
+	for _, doc := range batch {
+		segIdStrs := segIdRegexp.FindStringSubmatch(doc)
+		if len(segIdStrs) == 2 && !resubmitted[segIdStrs[1]] {
+			delete(s.resubmits, segIdStrs[1])
+		}
+	}
+}
+
+// resubmit queues docs to be sent in a later batch. It never waits for room in the batches channel,
+// as the caller is one of its consumers: docs which do not fit are spooled, or dropped without spool.
+func (s *segmentsBatch) resubmit(docs []string) {
+	if len(docs) == 0 {
+		return
+	}
+	if s.tryQueue(docs) {
+		telemetry.T.Count(counterName+".unprocessed.resubmit", int64(len(docs)))
+		return
+	}
+	if s.spool != nil {
+		s.spoolBatch(docs)
+		return
+	}
+	log.Warnf("Segment batch queue is full or closed. Dropping %v unprocessed segments", len(docs))
+	deadletter.D.RecordBatch(deadletter.ReasonBatchQueueFull, "", docs)
+	telemetry.T.SegmentRejected(int64(len(docs)))
+	telemetry.T.Count(counterName+".unprocessed.resubmit-dropped", int64(len(docs)))
+}
+
+// tryQueue sends batch to the batches channel if it is open and has room, without waiting.
+func (s *segmentsBatch) tryQueue(batch []string) bool {
+	s.closeLock.RLock()
+	defer s.closeLock.RUnlock()
+	if s.closed {
+		return false
+	}
+	select {
+	case s.batches <- batch:
+		return true
+	default:
+		return false
+	}
+}
+
+// reject dead-letters doc, rejected by X-Ray service with errorCode for a permanent reason.
+func (s *segmentsBatch) reject(doc string, errorCode *string) {
+	if deadletter.D == nil {
+		return
+	}
+	deadletter.D.RecordBatch(deadletter.ReasonUnprocessed, aws.ToString(errorCode), []string{doc})
+	telemetry.T.Count(counterName+".unprocessed.dead-letter", 1)
+}
+
+// isClosed returns true once the batches channel is closed.
+func (s *segmentsBatch) isClosed() bool {
+	s.closeLock.RLock()
+	defer s.closeLock.RUnlock()
+	return s.closed
+}
+
 func (s *segmentsBatch) close() {
+	s.closeLock.Lock()
+	defer s.closeLock.Unlock()
+	s.closed = true
 	close(s.batches)
+	if s.stop != nil {
+		close(s.stop)
+	}
 }
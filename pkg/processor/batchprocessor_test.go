@@ -13,12 +13,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/xray"
 	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	"github.com/aws/aws-xray-daemon/pkg/breaker"
+	"github.com/aws/aws-xray-daemon/pkg/conn"
+	"github.com/aws/aws-xray-daemon/pkg/deadletter"
+	"github.com/aws/aws-xray-daemon/pkg/exporter"
+	"github.com/aws/aws-xray-daemon/pkg/overflow"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
 	"github.com/aws/aws-xray-daemon/pkg/util/test"
+	"github.com/aws/aws-xray-daemon/pkg/util/timer"
+	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -41,8 +53,14 @@ func (c *MockXRayClient) PutTraceSegments(ctx context.Context, input *xray.PutTr
 	if errorStr == "Send unprocessed" {
 		segmentID := "Test-Segment-Id-1242113"
 		output.UnprocessedTraceSegments = append(output.UnprocessedTraceSegments, types.UnprocessedTraceSegment{Id: &segmentID})
+	} else if strings.HasPrefix(errorStr, "Send unprocessed ") {
+		segmentID := "9472"
+		errorCode := strings.TrimPrefix(errorStr, "Send unprocessed ")
+		output.UnprocessedTraceSegments = append(output.UnprocessedTraceSegments, types.UnprocessedTraceSegment{Id: &segmentID, ErrorCode: &errorCode})
 	} else if errorStr == "Send Invalid" {
 		output.UnprocessedTraceSegments = append(output.UnprocessedTraceSegments, types.UnprocessedTraceSegment{Id: nil})
+	} else if strings.HasSuffix(errorStr, "Exception") {
+		err = &smithy.GenericAPIError{Code: errorStr}
 	} else if errorStr != "" {
 		err = errors.New(errorStr)
 	}
@@ -90,20 +108,97 @@ func TestSendBatchChannelTruncate(t *testing.T) {
 	assert.True(t, strings.Contains(log.Logs[1], "retrying batch"))
 }
 
+type mockSpiller struct {
+	docs []string
+}
+
+func (m *mockSpiller) Spill(docs []string) error {
+	m.docs = append(m.docs, docs...)
+	return nil
+}
+
+func setupTestDeadLetter(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deadletter.Init(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		deadletter.D = nil
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSendBatchDropNewest(t *testing.T) {
+	log := test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	defer setupTestDeadLetter(t)()
+	s := segmentsBatch{
+		batches:  make(chan []string, 1),
+		overflow: overflow.Config{Policy: overflow.DropNewest},
+	}
+
+	s.send([]string{"Test Message"})
+	s.send([]string{"Test Message 2", "Test Message 3"})
+
+	returnedBatch := <-s.batches
+	assert.EqualValues(t, []string{"Test Message"}, returnedBatch)
+	assert.EqualValues(t, 2, telemetry.T.Counter("batch.overflow.drop-newest"))
+	assert.Equal(t, 2, len(deadletter.D.Recent(10)), "Dropped segments should be dead-lettered")
+	assert.True(t, strings.Contains(log.Logs[0], "Dropping newest 2 segments"))
+}
+
+func TestSendBatchBlockTimeout(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	s := segmentsBatch{
+		batches:  make(chan []string, 1),
+		overflow: overflow.Config{Policy: overflow.Block, BlockTimeout: time.Millisecond},
+	}
+
+	s.send([]string{"Test Message"})
+	s.send([]string{"Test Message 2"})
+
+	returnedBatch := <-s.batches
+	assert.EqualValues(t, []string{"Test Message"}, returnedBatch)
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.overflow.block"))
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.overflow.block-timeout"))
+}
+
+func TestSendBatchSpill(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	spiller := &mockSpiller{}
+	s := segmentsBatch{
+		batches:  make(chan []string, 1),
+		overflow: overflow.Config{Policy: overflow.Spill, Spiller: spiller},
+	}
+
+	s.send([]string{"Test Message"})
+	s.send([]string{"Test Message 2"})
+
+	returnedBatch := <-s.batches
+	assert.EqualValues(t, []string{"Test Message"}, returnedBatch)
+	assert.EqualValues(t, []string{"Test Message 2"}, spiller.docs)
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.overflow.spill"))
+}
+
 func TestPollSendSuccess(t *testing.T) {
 	log := test.LogSetup()
 	xRay := new(MockXRayClient)
 	xRay.On("PutTraceSegments", nil).Return("").Once()
 	s := segmentsBatch{
-		batches: make(chan []string, 1),
-		xRay:    xRay,
-		done:    make(chan bool),
+		batches:  make(chan []string, 1),
+		exporter: exporter.NewXRay(xRay),
+		done:     make(chan bool),
 	}
 	testMessage := "{\"id\":\"9472\""
 	batch := []string{testMessage}
 	s.send(batch)
 
-	go s.poll()
+	go s.poll(context.Background())
 	close(s.batches)
 	<-s.done
 
@@ -118,15 +213,15 @@ func TestPutTraceSegmentsParameters(t *testing.T) {
 	xRay.On("PutTraceSegments", nil).Return("").Once()
 
 	s := segmentsBatch{
-		batches: make(chan []string, 1),
-		xRay:    xRay,
-		done:    make(chan bool),
+		batches:  make(chan []string, 1),
+		exporter: exporter.NewXRay(xRay),
+		done:     make(chan bool),
 	}
 	testMessage := "{\"id\":\"9472\""
 	batch := []string{testMessage}
 	s.send(batch)
 
-	go s.poll()
+	go s.poll(context.Background())
 
 	close(s.batches)
 	<-s.done
@@ -147,15 +242,15 @@ func TestPollSendReturnUnprocessed(t *testing.T) {
 	xRay := new(MockXRayClient)
 	xRay.On("PutTraceSegments", nil).Return("Send unprocessed").Once()
 	s := segmentsBatch{
-		batches: make(chan []string, 1),
-		xRay:    xRay,
-		done:    make(chan bool),
+		batches:  make(chan []string, 1),
+		exporter: exporter.NewXRay(xRay),
+		done:     make(chan bool),
 	}
 	testMessage := "{\"id\":\"9472\""
 	batch := []string{testMessage}
 	s.send(batch)
 
-	go s.poll()
+	go s.poll(context.Background())
 	close(s.batches)
 	<-s.done
 
@@ -169,15 +264,15 @@ func TestPollSendReturnUnprocessedInvalid(t *testing.T) {
 	xRay := new(MockXRayClient)
 	xRay.On("PutTraceSegments", nil).Return("Send Invalid").Once()
 	s := segmentsBatch{
-		batches: make(chan []string, 1),
-		xRay:    xRay,
-		done:    make(chan bool),
+		batches:  make(chan []string, 1),
+		exporter: exporter.NewXRay(xRay),
+		done:     make(chan bool),
 	}
 	testMessage := "{\"id\":\"9472\""
 	batch := []string{testMessage}
 	s.send(batch)
 
-	go s.poll()
+	go s.poll(context.Background())
 	close(s.batches)
 	<-s.done
 
@@ -185,3 +280,293 @@ func TestPollSendReturnUnprocessedInvalid(t *testing.T) {
 	assert.True(t, strings.Contains(log.Logs[0], fmt.Sprintf("Sent batch of %v segments but had %v Unprocessed segments", 1, 1)))
 	assert.True(t, strings.Contains(log.Logs[1], "Received nil unprocessed segment id from X-Ray service"))
 }
+
+func getRetryTestSegmentsBatch(xRay conn.XRay, maxRetries int, budget *retryBudget) *segmentsBatch {
+	return &segmentsBatch{
+		batches:  make(chan []string, 1),
+		exporter: exporter.NewXRay(xRay),
+		done:     make(chan bool),
+		randGen:  rand.New(rand.NewSource(1)),
+		timer:    &timer.Client{},
+		retry: retryConfig{
+			maxRetries: maxRetries,
+			baseDelay:  time.Millisecond,
+			maxDelay:   time.Millisecond,
+		},
+		budget: budget,
+	}
+}
+
+func TestPollSendRetrySuccess(t *testing.T) {
+	log := test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	xRay.On("PutTraceSegments", nil).Return("connection timeout").Once()
+	xRay.On("PutTraceSegments", nil).Return("").Once()
+	s := getRetryTestSegmentsBatch(xRay, 3, newRetryBudget(10))
+	s.send([]string{"{\"id\":\"9472\""})
+
+	go s.poll(context.Background())
+	close(s.batches)
+	<-s.done
+
+	assert.EqualValues(t, 2, xRay.CallNoToPutTraceSegments)
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.retry"))
+	assert.True(t, strings.Contains(log.Logs[0], "Retrying in"))
+	assert.True(t, strings.Contains(log.Logs[1], fmt.Sprintf("Successfully sent batch of %v", 1)))
+}
+
+func TestPollSendRetryNotRetryable(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	xRay.On("PutTraceSegments", nil).Return("invalid segment").Once()
+	s := getRetryTestSegmentsBatch(xRay, 3, newRetryBudget(10))
+	s.send([]string{"{\"id\":\"9472\""})
+
+	go s.poll(context.Background())
+	close(s.batches)
+	<-s.done
+
+	assert.EqualValues(t, 1, xRay.CallNoToPutTraceSegments)
+	assert.EqualValues(t, 0, telemetry.T.Counter("batch.retry"))
+}
+
+func TestPollSendRetryExhausted(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	xRay.On("PutTraceSegments", nil).Return("connection timeout").Times(3)
+	s := getRetryTestSegmentsBatch(xRay, 2, newRetryBudget(10))
+	s.send([]string{"{\"id\":\"9472\""})
+
+	go s.poll(context.Background())
+	close(s.batches)
+	<-s.done
+
+	assert.EqualValues(t, 3, xRay.CallNoToPutTraceSegments)
+	assert.EqualValues(t, 2, telemetry.T.Counter("batch.retry"))
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.retry-exhausted"))
+}
+
+func TestPollSendRetryBudgetExhausted(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	xRay.On("PutTraceSegments", nil).Return("connection timeout").Once()
+	budget := newRetryBudget(10)
+	budget.tokens = 0
+	s := getRetryTestSegmentsBatch(xRay, 3, budget)
+	s.send([]string{"{\"id\":\"9472\""})
+
+	go s.poll(context.Background())
+	close(s.batches)
+	<-s.done
+
+	assert.EqualValues(t, 1, xRay.CallNoToPutTraceSegments)
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.retry-budget-exhausted"))
+}
+
+func TestBackoff(t *testing.T) {
+	s := getRetryTestSegmentsBatch(nil, 3, nil)
+	s.retry.baseDelay = 100 * time.Millisecond
+	s.retry.maxDelay = time.Second
+
+	for attempt := 0; attempt < 64; attempt++ {
+		delay := s.backoff(attempt)
+		ceiling := time.Second
+		if attempt < 4 {
+			ceiling = 100 * time.Millisecond << uint(attempt)
+		}
+		assert.True(t, delay >= 0 && delay <= ceiling, "Backoff of attempt %v is %v", attempt, delay)
+	}
+}
+
+func TestPollSendResubmitUnprocessedTransient(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	resent := make(chan bool, 1)
+	xRay.On("PutTraceSegments", nil).Return("Send unprocessed ThrottledException").Once()
+	xRay.On("PutTraceSegments", nil).Return("").Once().Run(func(mock.Arguments) { resent <- true })
+	s := getRetryTestSegmentsBatch(xRay, 3, newRetryBudget(10))
+	testMessage := "{\"id\":\"9472\"}"
+	s.send([]string{testMessage})
+
+	go s.poll(context.Background())
+	<-resent
+	s.close()
+	<-s.done
+
+	assert.EqualValues(t, 2, xRay.CallNoToPutTraceSegments)
+	assert.EqualValues(t, []string{testMessage}, xRay.input.TraceSegmentDocuments)
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.unprocessed.resubmit"))
+	assert.Empty(t, s.resubmits, "Resubmission count should be cleared once sent")
+}
+
+func TestPollSendResubmitUnprocessedExhausted(t *testing.T) {
+	log := test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	xRay.On("PutTraceSegments", nil).Return("Send unprocessed ThrottledException").Once()
+	defer setupTestDeadLetter(t)()
+	s := getRetryTestSegmentsBatch(xRay, 0, newRetryBudget(10))
+	s.send([]string{"{\"id\":\"9472\"}"})
+
+	go s.poll(context.Background())
+	close(s.batches)
+	<-s.done
+
+	assert.EqualValues(t, 1, xRay.CallNoToPutTraceSegments)
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.unprocessed.resubmit-exhausted"))
+	assert.EqualValues(t, 0, telemetry.T.Counter("batch.unprocessed.dead-letter"))
+	entries := deadletter.D.Recent(10)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, deadletter.ReasonResubmitExhausted, entries[0].Reason)
+	assert.True(t, strings.Contains(log.Logs[1], "Unprocessed segment"))
+}
+
+func TestResubmitDoesNotWaitForFullQueue(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	s := getRetryTestSegmentsBatch(nil, 3, nil)
+	s.overflow = overflow.Config{Policy: overflow.Block, BlockTimeout: time.Hour}
+	s.send([]string{"{\"id\":\"1\"}"})
+
+	s.resubmit([]string{"{\"id\":\"2\"}"})
+	s.close()
+
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.unprocessed.resubmit-dropped"))
+	assert.EqualValues(t, 0, telemetry.T.Counter("batch.unprocessed.resubmit"))
+}
+
+func TestResubmitSpoolsWhenQueueFull(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	sp := &mockSpool{}
+	s := getSpoolTestSegmentsBatch(nil, sp)
+	s.send([]string{"{\"id\":\"1\"}"})
+
+	s.resubmit([]string{"{\"id\":\"2\"}"})
+
+	assert.EqualValues(t, []string{"{\"id\":\"2\"}"}, sp.docs)
+	assert.EqualValues(t, 0, telemetry.T.Counter("batch.unprocessed.resubmit-dropped"))
+}
+
+func TestPollSendDeadLetterUnprocessedPermanent(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	xRay.On("PutTraceSegments", nil).Return("Send unprocessed InvalidTraceId").Once()
+	defer setupTestDeadLetter(t)()
+	s := getRetryTestSegmentsBatch(xRay, 3, newRetryBudget(10))
+	testMessage := "{\"id\":\"9472\"}"
+	s.send([]string{testMessage})
+
+	go s.poll(context.Background())
+	close(s.batches)
+	<-s.done
+
+	assert.EqualValues(t, 1, xRay.CallNoToPutTraceSegments)
+	entries := deadletter.D.Recent(10)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, deadletter.ReasonUnprocessed, entries[0].Reason)
+	assert.Equal(t, "InvalidTraceId", entries[0].ErrorCode)
+	assert.Equal(t, testMessage, entries[0].Document)
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.unprocessed.dead-letter"))
+	assert.EqualValues(t, 0, telemetry.T.Counter("batch.unprocessed.resubmit"))
+}
+
+func TestPollBreakerOpenDropsBatch(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	xRay.On("PutTraceSegments", nil).Return("connection timeout").Once()
+	s := getRetryTestSegmentsBatch(xRay, 3, newRetryBudget(10))
+	s.batches = make(chan []string, 2)
+	s.breaker = breaker.New("xray", breaker.Config{ConsecutiveFailures: 1, OpenTimeout: time.Hour})
+	s.send([]string{"{\"id\":\"1\"}"})
+	s.send([]string{"{\"id\":\"2\"}"})
+
+	go s.poll(context.Background())
+	close(s.batches)
+	<-s.done
+
+	assert.EqualValues(t, 1, xRay.CallNoToPutTraceSegments, "Retries and batches should stop once the breaker is open")
+	assert.Equal(t, breaker.Open, s.breaker.State())
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.breaker-open"))
+}
+
+func TestPollBreakerOpensOnRefusedConnection(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	s := getRetryTestSegmentsBatch(nil, 0, nil)
+	s.exporter = exporter.NewXRay(getRefusedXRay())
+	s.batches = make(chan []string, 2)
+	s.breaker = breaker.New("xray", breaker.Config{ConsecutiveFailures: 1, OpenTimeout: time.Hour})
+	s.send([]string{"{\"id\":\"1\"}"})
+	s.send([]string{"{\"id\":\"2\"}"})
+
+	go s.poll(context.Background())
+	close(s.batches)
+	<-s.done
+
+	assert.Equal(t, breaker.Open, s.breaker.State(), "A refused connection is a failure of X-Ray service")
+	assert.EqualValues(t, 1, telemetry.T.Counter("batch.breaker-open"))
+}
+
+func TestPollBreakerOpenSpoolsBatch(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	sp := &mockSpool{}
+	s := getSpoolTestSegmentsBatch(xRay, sp)
+	s.breaker = breaker.New("xray", breaker.Config{ConsecutiveFailures: 1, OpenTimeout: time.Hour})
+	s.breaker.Failure()
+	s.spoolOnOpen = true
+	testMessage := "{\"id\":\"1\"}"
+	s.send([]string{testMessage})
+
+	go s.poll(context.Background())
+	s.close()
+	<-s.done
+
+	assert.EqualValues(t, 0, xRay.CallNoToPutTraceSegments)
+	assert.EqualValues(t, []string{testMessage}, sp.docs)
+}
+
+func TestPollCancelledDropsBatch(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	s := getRetryTestSegmentsBatch(xRay, 3, newRetryBudget(10))
+	s.batches = make(chan []string, 1)
+	s.send([]string{"{\"id\":\"1\"}", "{\"id\":\"2\"}"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	go s.poll(ctx)
+	close(s.batches)
+	<-s.done
+
+	assert.EqualValues(t, 0, xRay.CallNoToPutTraceSegments)
+	assert.EqualValues(t, 2, telemetry.T.Counter("batch.shutdown"))
+	assert.EqualValues(t, 2, telemetry.T.Totals().Spillover)
+}
+
+func TestExportRetryCancelled(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	xRay.On("PutTraceSegments", nil).Return("connection timeout").Once()
+	s := getRetryTestSegmentsBatch(xRay, 3, newRetryBudget(10))
+	// The retry delay never elapses.
+	s.timer = &test.MockTimerClient{}
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+
+	_, err := s.export(ctx, []string{"{\"id\":\"1\"}"})
+
+	assert.Equal(t, context.Canceled, err)
+	assert.EqualValues(t, 1, xRay.CallNoToPutTraceSegments)
+}
@@ -0,0 +1,214 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	log "github.com/cihub/seelog"
+)
+
+// Maximum number of open segments tracked, in-progress segments beyond it are sent as received.
+const maxOpenSegments = 10000
+
+// Prefix of the telemetry counters of in-progress segments.
+const coalesceCounterName = "segment.inprogress"
+
+// Fields of an in-progress segment kept once it is sent, to close it if it stays open.
+var closeFields = []string{"trace_id", "id", "parent_id", "type", "name", "origin", "namespace", "start_time"}
+
+// coalescer holds in-progress segments for a window, so that a completed version received
+// in the meantime replaces them, and closes segments still open after a time to live.
+type coalescer struct {
+	// Time an in-progress segment is held before it is sent.
+	window time.Duration
+
+	// Time after its first in-progress version a segment still open is closed with a fault.
+	ttl time.Duration
+
+	// Maximum size in bytes of the documents held, in-progress segments beyond it are sent as received.
+	maxBytes int
+
+	// Size in bytes of the documents held.
+	bytes int
+
+	// Open segments by trace and segment id.
+	open map[string]*openSegment
+
+	// Returns current time, replaced in tests.
+	now func() time.Time
+}
+
+type openSegment struct {
+	// Key of the segment in open.
+	key string
+
+	// Latest in-progress version of the segment, copied out of the buffer pool. Once sent, only
+	// the fields needed to close the segment are kept.
+	ts *tracesegment.TraceSegment
+
+	// Time the first and the latest in-progress versions were received.
+	received time.Time
+	updated  time.Time
+
+	// True once the latest version was sent.
+	sent bool
+}
+
+func newCoalescer(window time.Duration, ttl time.Duration, maxBytes int) *coalescer {
+	return &coalescer{
+		window:   window,
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		open:     make(map[string]*openSegment),
+		now:      time.Now,
+	}
+}
+
+// hold returns true if segment ts is in progress and is held, in which case the buffer of ts
+// is no longer used. A completed segment replaces its in-progress version still held.
+func (c *coalescer) hold(ts *tracesegment.TraceSegment) bool {
+	doc, err := ts.Document()
+	if err != nil || doc.ID == "" {
+		return false
+	}
+	key := doc.TraceID + "/" + doc.ID
+	s, ok := c.open[key]
+	if !doc.InProgress {
+		if ok {
+			c.remove(s)
+			if !s.sent {
+				telemetry.T.Count(coalesceCounterName+".coalesced", 1)
+			}
+		}
+		return false
+	}
+	held := 0
+	if ok {
+		held = len(*s.ts.Raw)
+	}
+	if (!ok && len(c.open) >= maxOpenSegments) || c.bytes-held+len(*ts.Raw) > c.maxBytes {
+		log.Debugf("processor: %d segments of %d bytes in progress, sending segment %v as received", len(c.open), c.bytes, doc.ID)
+		telemetry.T.Count(coalesceCounterName+".overflow", 1)
+		// The version sent supersedes the one held, which would otherwise be sent after it.
+		if ok {
+			c.remove(s)
+			if !s.sent {
+				telemetry.T.Count(coalesceCounterName+".coalesced", 1)
+			}
+		}
+		return false
+	}
+	now := c.now()
+	if !ok {
+		s = &openSegment{key: key, received: now}
+		c.open[key] = s
+	} else if !s.sent {
+		telemetry.T.Count(coalesceCounterName+".coalesced", 1)
+	}
+	raw := make([]byte, len(*ts.Raw))
+	copy(raw, *ts.Raw)
+	c.replace(s, &tracesegment.TraceSegment{Raw: &raw, Route: ts.Route})
+	s.updated = now
+	s.sent = false
+	return true
+}
+
+// replace holds ts as the version of open segment s.
+func (c *coalescer) replace(s *openSegment, ts *tracesegment.TraceSegment) {
+	if s.ts != nil {
+		c.bytes -= len(*s.ts.Raw)
+	}
+	s.ts = ts
+	c.bytes += len(*ts.Raw)
+}
+
+// remove stops tracking open segment s.
+func (c *coalescer) remove(s *openSegment) {
+	delete(c.open, s.key)
+	c.bytes -= len(*s.ts.Raw)
+}
+
+// release returns the in-progress segments held for the window, and the segments open for the
+// time to live, closed with a fault. If closing is true, every open segment is closed.
+func (c *coalescer) release(closing bool) []*tracesegment.TraceSegment {
+	now := c.now()
+	open := make([]*openSegment, 0, len(c.open))
+	for _, s := range c.open {
+		open = append(open, s)
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].received.Before(open[j].received) })
+
+	var released []*tracesegment.TraceSegment
+	for _, s := range open {
+		switch {
+		case closing || now.Sub(s.received) >= c.ttl:
+			c.remove(s)
+			if ts := autoClose(s.ts, now); ts != nil {
+				released = append(released, ts)
+			}
+		case !s.sent && now.Sub(s.updated) >= c.window:
+			released = append(released, s.ts)
+			s.sent = true
+			if ts := skeleton(s.ts); ts != nil {
+				c.replace(s, ts)
+			} else {
+				c.remove(s)
+			}
+		}
+	}
+	return released
+}
+
+// skeleton returns segment ts reduced to the fields needed to close it, nil if ts cannot be parsed.
+func skeleton(ts *tracesegment.TraceSegment) *tracesegment.TraceSegment {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(*ts.Raw, &fields); err != nil {
+		log.Errorf("Unable to reduce in-progress segment: %v", err)
+		return nil
+	}
+	kept := make(map[string]json.RawMessage, len(closeFields))
+	for _, f := range closeFields {
+		if v, ok := fields[f]; ok {
+			kept[f] = v
+		}
+	}
+	raw, err := json.Marshal(kept)
+	if err != nil {
+		log.Errorf("Unable to reduce in-progress segment: %v", err)
+		return nil
+	}
+	return &tracesegment.TraceSegment{Raw: &raw, Route: ts.Route}
+}
+
+// autoClose returns a completed version of in-progress segment ts, ended at now with a fault,
+// nil if ts cannot be parsed.
+func autoClose(ts *tracesegment.TraceSegment, now time.Time) *tracesegment.TraceSegment {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(*ts.Raw, &fields); err != nil {
+		log.Errorf("Unable to close in-progress segment: %v", err)
+		return nil
+	}
+	delete(fields, "in_progress")
+	fields["end_time"] = json.RawMessage(strconv.FormatFloat(float64(now.UnixNano())/float64(time.Second), 'f', 6, 64))
+	fields["fault"] = json.RawMessage("true")
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		log.Errorf("Unable to close in-progress segment: %v", err)
+		return nil
+	}
+	telemetry.T.Count(coalesceCounterName+".autoclosed", 1)
+	return &tracesegment.TraceSegment{Raw: &raw, Route: ts.Route}
+}
@@ -0,0 +1,191 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/bufferpool"
+	"github.com/aws/aws-xray-daemon/pkg/ringbuffer"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	"github.com/aws/aws-xray-daemon/pkg/util/test"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	inProgress = `{"trace_id":"1-5759e988-bd862e3fe1be46a994272793","id":"defdfd9912dc5a56","name":"job","start_time":1461096053.37518,"in_progress":true}`
+	completed  = `{"trace_id":"1-5759e988-bd862e3fe1be46a994272793","id":"defdfd9912dc5a56","name":"job","start_time":1461096053.37518,"end_time":1461096063.4042}`
+)
+
+func getTestDocument(doc string) *tracesegment.TraceSegment {
+	s := tracesegment.GetTestTraceSegment()
+	raw := []byte(doc)
+	s.Raw = &raw
+	return &s
+}
+
+func getTestCoalescer() (*coalescer, *time.Time) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	c := newCoalescer(5*time.Second, time.Minute, 1024)
+	now := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestCoalesceCompletedReplacesInProgress(t *testing.T) {
+	c, now := getTestCoalescer()
+
+	assert.True(t, c.hold(getTestDocument(inProgress)))
+	assert.True(t, c.hold(getTestDocument(inProgress)))
+	assert.False(t, c.hold(getTestDocument(completed)))
+	*now = now.Add(time.Minute)
+
+	assert.Empty(t, c.release(false))
+	assert.Empty(t, c.release(true))
+	assert.EqualValues(t, 2, telemetry.T.Counter("segment.inprogress.coalesced"))
+}
+
+func TestCoalesceSendsInProgressAfterWindow(t *testing.T) {
+	c, now := getTestCoalescer()
+	assert.True(t, c.hold(getTestDocument(inProgress)))
+
+	*now = now.Add(4 * time.Second)
+	assert.Empty(t, c.release(false))
+	*now = now.Add(time.Second)
+	released := c.release(false)
+	assert.EqualValues(t, 1, len(released))
+	assert.EqualValues(t, inProgress, string(*released[0].Raw))
+	assert.Nil(t, released[0].PoolBuf)
+	assert.Empty(t, c.release(false), "An in-progress segment is sent once")
+
+	assert.False(t, c.hold(getTestDocument(completed)))
+	assert.EqualValues(t, 0, len(c.open))
+	assert.EqualValues(t, 0, c.bytes)
+	assert.EqualValues(t, 0, telemetry.T.Counter("segment.inprogress.coalesced"))
+}
+
+func TestCoalesceKeepsFieldsToCloseOnceSent(t *testing.T) {
+	c, now := getTestCoalescer()
+	assert.True(t, c.hold(getTestDocument(`{"trace_id":"1-5759e988-bd862e3fe1be46a994272793","id":"a","name":"job",`+
+		`"start_time":1461096053.37518,"in_progress":true,"metadata":{"payload":"large"}}`)))
+	*now = now.Add(5 * time.Second)
+	c.release(false)
+
+	held := c.open["1-5759e988-bd862e3fe1be46a994272793/a"].ts
+	assert.EqualValues(t, `{"id":"a","name":"job","start_time":1461096053.37518,"trace_id":"1-5759e988-bd862e3fe1be46a994272793"}`, string(*held.Raw))
+	assert.EqualValues(t, len(*held.Raw), c.bytes)
+}
+
+func TestCoalesceSendsAsReceivedOverMaxBytes(t *testing.T) {
+	c, _ := getTestCoalescer()
+	c.maxBytes = len(inProgress) + 10
+
+	assert.True(t, c.hold(getTestDocument(inProgress)))
+	assert.True(t, c.hold(getTestDocument(inProgress)), "A new version replaces the held one within the limit")
+	assert.False(t, c.hold(getTestDocument(`{"trace_id":"1-5759e988-bd862e3fe1be46a994272793","id":"b","in_progress":true}`)))
+	assert.EqualValues(t, 1, len(c.open))
+	assert.EqualValues(t, len(inProgress), c.bytes)
+	assert.EqualValues(t, 1, telemetry.T.Counter("segment.inprogress.overflow"))
+}
+
+func TestCoalesceOverflowingVersionSupersedesHeldOne(t *testing.T) {
+	c, now := getTestCoalescer()
+	c.maxBytes = len(inProgress) + 10
+	larger := strings.Replace(inProgress, `"name":"job"`, `"name":"job","metadata":{"payload":"larger"}`, 1)
+
+	assert.True(t, c.hold(getTestDocument(inProgress)))
+	assert.False(t, c.hold(getTestDocument(larger)), "A version over the limit is sent as received")
+	assert.EqualValues(t, 0, len(c.open))
+	assert.EqualValues(t, 0, c.bytes)
+	*now = now.Add(time.Minute)
+
+	assert.Empty(t, c.release(false), "The older version is not sent after the newer one")
+	assert.EqualValues(t, 1, telemetry.T.Counter("segment.inprogress.coalesced"))
+}
+
+func TestCoalesceIgnoresUnparsableSegment(t *testing.T) {
+	c, _ := getTestCoalescer()
+
+	assert.False(t, c.hold(getTestDocument("{")))
+	assert.False(t, c.hold(getTestDocument(`{"in_progress":true}`)))
+}
+
+func TestCoalesceAutoClosesAfterTTL(t *testing.T) {
+	c, now := getTestCoalescer()
+	assert.True(t, c.hold(getTestDocument(inProgress)))
+	*now = now.Add(5 * time.Second)
+	c.release(false)
+
+	*now = now.Add(55 * time.Second)
+	released := c.release(false)
+
+	assert.EqualValues(t, 1, len(released))
+	var doc map[string]interface{}
+	assert.Nil(t, json.Unmarshal(*released[0].Raw, &doc))
+	assert.Nil(t, doc["in_progress"])
+	assert.EqualValues(t, true, doc["fault"])
+	assert.EqualValues(t, 1700000060, doc["end_time"])
+	assert.EqualValues(t, "job", doc["name"])
+	assert.EqualValues(t, 0, len(c.open))
+	assert.EqualValues(t, 1, telemetry.T.Counter("segment.inprogress.autoclosed"))
+}
+
+func TestCoalesceAutoClosesAtShutdown(t *testing.T) {
+	c, _ := getTestCoalescer()
+	ts := getTestDocument(inProgress)
+	ts.Route = "payments"
+	assert.True(t, c.hold(ts))
+
+	released := c.release(true)
+
+	assert.EqualValues(t, 1, len(released))
+	assert.EqualValues(t, "payments", released[0].Route)
+	assert.Contains(t, string(*released[0].Raw), `"fault":true`)
+	assert.EqualValues(t, 0, len(c.open))
+}
+
+func TestPollingCoalescesInProgress(t *testing.T) {
+	pool := bufferpool.Init(10, 100)
+	stdChan := ringbuffer.New(20, pool)
+	priChan := ringbuffer.New(20, pool)
+	test.LogSetup()
+	c, _ := getTestCoalescer()
+	processor := &Processor{
+		timerClient: &test.MockTimerClient{},
+		std:         stdChan,
+		pri:         priChan,
+		Done:        make(chan bool),
+		pool:        pool,
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 2),
+		},
+		sendIdleTimeout: time.Second,
+		batchSize:       50,
+		coalesce:        c,
+	}
+	stdChan.Send(getTestDocument(inProgress))
+	stdChan.Send(getTestDocument(completed))
+	stdChan.Send(getTestDocument(`{"trace_id":"1-5759e988-bd862e3fe1be46a994272793","id":"b","in_progress":true}`))
+	priChan.Close()
+	stdChan.Close()
+
+	go processor.poll()
+	<-processor.Done
+
+	batch := <-processor.traceSegmentsBatch.batches
+	assert.EqualValues(t, 2, len(batch))
+	assert.EqualValues(t, completed, batch[0])
+	assert.Contains(t, batch[1], `"fault":true`)
+	assert.EqualValues(t, 3, processor.ProcessedCount())
+}
@@ -0,0 +1,133 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	log "github.com/cihub/seelog"
+)
+
+// Factor applied to the concurrency limit on throttling, 5xx responses or timeouts.
+const decreaseFactor = 0.5
+
+// Minimum time between two decreases, so failures of calls in flight together decrease the limit once.
+const decreaseInterval = time.Second
+
+// concurrencyLimiter bounds the number of calls in flight to X-Ray service, adapting the bound by
+// additive increase while latency is healthy and multiplicative decrease on congestion errors.
+type concurrencyLimiter struct {
+	lock sync.Mutex
+	cond *sync.Cond
+
+	// Current limit, fractional so it grows by one once limit calls succeeded.
+	limit float64
+
+	// Bounds of the limit.
+	min int
+	max int
+
+	// Number of calls in flight.
+	inFlight int
+
+	// Latency above which successful calls do not increase the limit.
+	latencyThreshold time.Duration
+
+	// Time the limit last decreased.
+	lastDecrease time.Time
+
+	// Returns current time, replaced in tests.
+	now func() time.Time
+}
+
+// newConcurrencyLimiter returns a limiter starting at initial calls in flight, bounded by min and max.
+func newConcurrencyLimiter(initial int, min int, max int, latencyThreshold time.Duration) *concurrencyLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if initial < min {
+		initial = min
+	}
+	if initial > max {
+		initial = max
+	}
+	l := &concurrencyLimiter{
+		limit:            float64(initial),
+		min:              min,
+		max:              max,
+		latencyThreshold: latencyThreshold,
+		now:              time.Now,
+	}
+	l.cond = sync.NewCond(&l.lock)
+	return l
+}
+
+// acquire waits until a call may be sent, returns the error of ctx if it is done first.
+func (l *concurrencyLimiter) acquire(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for l.inFlight >= int(l.limit) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cond.Wait()
+	}
+	l.inFlight++
+	return nil
+}
+
+// release records the outcome of a call which took latency and failed with err, nil on success.
+func (l *concurrencyLimiter) release(latency time.Duration, err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.inFlight--
+	previous := int(l.limit)
+	switch {
+	case err != nil && isRetryable(err):
+		now := l.now()
+		if now.Sub(l.lastDecrease) >= decreaseInterval {
+			l.lastDecrease = now
+			l.limit *= decreaseFactor
+			if l.limit < float64(l.min) {
+				l.limit = float64(l.min)
+			}
+		}
+	case err == nil && latency <= l.latencyThreshold:
+		l.limit += 1 / l.limit
+		if l.limit > float64(l.max) {
+			l.limit = float64(l.max)
+		}
+	}
+	if current := int(l.limit); current != previous {
+		log.Debugf("Upload concurrency limit changed from %v to %v", previous, current)
+		if current > previous {
+			telemetry.T.Count(counterName+".concurrency.increase", 1)
+		} else {
+			telemetry.T.Count(counterName+".concurrency.decrease", 1)
+		}
+	}
+	l.cond.Broadcast()
+}
+
+// current returns the current limit.
+func (l *concurrencyLimiter) current() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int(l.limit)
+}
@@ -0,0 +1,125 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestConcurrencyLimiter(initial int, min int, max int) (*concurrencyLimiter, *time.Time) {
+	now := time.Unix(1500000000, 0)
+	l := newConcurrencyLimiter(initial, min, max, 100*time.Millisecond)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestConcurrencyLimiterBounds(t *testing.T) {
+	l, _ := getTestConcurrencyLimiter(64, 2, 8)
+	assert.EqualValues(t, 8, l.current())
+
+	l, _ = getTestConcurrencyLimiter(0, 0, 8)
+	assert.EqualValues(t, 1, l.current())
+}
+
+func TestConcurrencyLimiterIncreasesWhileHealthy(t *testing.T) {
+	l, _ := getTestConcurrencyLimiter(2, 1, 3)
+
+	for i := 0; i < 3; i++ {
+		l.acquire(context.Background())
+		l.release(10*time.Millisecond, nil)
+	}
+	assert.EqualValues(t, 3, l.current(), "The limit grows by about one once limit calls succeeded")
+
+	for i := 0; i < 10; i++ {
+		l.acquire(context.Background())
+		l.release(10*time.Millisecond, nil)
+	}
+	assert.EqualValues(t, 3, l.current(), "The limit does not grow beyond max")
+}
+
+func TestConcurrencyLimiterKeepsOnSlowOrPermanentFailure(t *testing.T) {
+	l, _ := getTestConcurrencyLimiter(2, 1, 8)
+
+	for i := 0; i < 4; i++ {
+		l.acquire(context.Background())
+		l.release(time.Second, nil)
+	}
+	l.acquire(context.Background())
+	l.release(10*time.Millisecond, errors.New("invalid"))
+	assert.EqualValues(t, 2, l.current())
+}
+
+func TestConcurrencyLimiterDecreasesOnThrottling(t *testing.T) {
+	l, now := getTestConcurrencyLimiter(8, 3, 16)
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException"}
+
+	l.acquire(context.Background())
+	l.release(10*time.Millisecond, throttled)
+	assert.EqualValues(t, 4, l.current())
+
+	l.acquire(context.Background())
+	l.release(10*time.Millisecond, getResponseError(503))
+	assert.EqualValues(t, 4, l.current(), "Failures within the decrease interval decrease the limit once")
+
+	*now = now.Add(decreaseInterval)
+	l.acquire(context.Background())
+	l.release(10*time.Millisecond, throttled)
+	assert.EqualValues(t, 3, l.current(), "The limit does not drop below min")
+}
+
+func TestConcurrencyLimiterAcquireWaitsForRelease(t *testing.T) {
+	l, _ := getTestConcurrencyLimiter(1, 1, 1)
+	l.acquire(context.Background())
+
+	acquired := make(chan struct{})
+	go func() {
+		l.acquire(context.Background())
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Acquired beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release(10*time.Millisecond, nil)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Release did not wake up waiting call")
+	}
+}
+
+func TestConcurrencyLimiterAcquireReturnsOnCancel(t *testing.T) {
+	l, _ := getTestConcurrencyLimiter(1, 1, 1)
+	l.acquire(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- l.acquire(ctx)
+	}()
+	cancel()
+
+	select {
+	case err := <-acquired:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("Cancel did not release waiting call")
+	}
+	l.release(10*time.Millisecond, nil)
+	assert.Nil(t, l.acquire(context.Background()), "A cancelled call does not hold a slot")
+}
@@ -10,15 +10,16 @@
 package processor
 
 import (
+	"context"
 	"math/rand"
-	"os"
 	"sync/atomic"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-xray-daemon/pkg/breaker"
 	"github.com/aws/aws-xray-daemon/pkg/bufferpool"
 	"github.com/aws/aws-xray-daemon/pkg/cfg"
-	"github.com/aws/aws-xray-daemon/pkg/conn"
+	"github.com/aws/aws-xray-daemon/pkg/exporter"
+	"github.com/aws/aws-xray-daemon/pkg/overflow"
 	"github.com/aws/aws-xray-daemon/pkg/ringbuffer"
 	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
 	"github.com/aws/aws-xray-daemon/pkg/util/timer"
@@ -33,6 +34,12 @@ type Processor struct {
 	// Ring buffer to store trace segments.
 	std *ringbuffer.RingBuffer
 
+	// Ring buffer to store error, fault, throttle and header flagged trace segments, drained before std.
+	pri *ringbuffer.RingBuffer
+
+	// Per-service queues drained by weighted round-robin, nil if fair queuing is disabled.
+	fair *ringbuffer.FairQueue
+
 	// Buffer pool instance.
 	pool *bufferpool.BufferPool
 
@@ -48,6 +55,15 @@ type Processor struct {
 	// Number of go routines to spawn for traceSegmentsBatch.poll().
 	batchProcessorCount int
 
+	// Exporters the segments sent with exporter x are also sent to, each from its own queue.
+	sinks []*sink
+
+	// Destinations segments routed away from exporter x are sent to instead, by name.
+	routes map[string]*sink
+
+	// Returns the document sent with exporter x for a segment, nil to send its raw document.
+	wrap func(ts *tracesegment.TraceSegment) string
+
 	// Channel for Time.
 	idleTimer <-chan time.Time
 
@@ -56,30 +72,105 @@ type Processor struct {
 
 	// Idle timeout in milliseconds used while sending batch segments.
 	sendIdleTimeout time.Duration
+
+	// Maximum size in bytes of the PutTraceSegments request of a batch, 0 for no limit.
+	maxBatchBytes int
+
+	// Size in bytes of the PutTraceSegments request of the current batch, without the request overhead.
+	batchBytes int
+
+	// Maximum time the first segment of a batch waits before the batch is sent, 0 for no limit.
+	maxBatchAge time.Duration
+
+	// Channel for Time, fired once the current batch reaches its maximum age.
+	ageTimer <-chan time.Time
+
+	// Segments pending in the batching window grouped by trace, nil if trace affinity batching is disabled.
+	affinity *traceGroups
+
+	// Holds in-progress segments until completed, nil if coalescing is disabled.
+	coalesce *coalescer
+
+	// Channel for Time, fired when held in-progress segments are due.
+	coalesceTimer <-chan time.Time
 }
 
-// New creates new instance of Processor.
-func New(awsConfig aws.Config, segmentBatchProcessorCount int, std *ringbuffer.RingBuffer,
-	pool *bufferpool.BufferPool, c *cfg.ParameterConfig) *Processor {
+// Options are the optional parts of a Processor, disabled if zero.
+type Options struct {
+	// Per-service queues drained by weighted round-robin, along with std and pri, nil if fair queuing is disabled.
+	Fair *ringbuffer.FairQueue
+
+	// Behavior when the batch queue is full.
+	Overflow overflow.Config
+
+	// Spool of batches which cannot be delivered, replayed once X-Ray is reachable, nil if disabled.
+	Spool Spool
+
+	// Circuit breaker around uploads, nil if disabled.
+	Breaker *breaker.Breaker
+
+	// SpoolOnOpen, if true, spools batches while the breaker is open instead of dropping them.
+	SpoolOnOpen bool
+
+	// Exporters the segments not routed to another destination are also sent to, each from its own queue.
+	Sinks []SinkConfig
+
+	// Destinations segments routed away from the exporter of the processor are sent to instead.
+	Routes []SinkConfig
+
+	// Wrap returns the document sent to the exporter of the processor for segment ts, its raw document if nil.
+	Wrap func(ts *tracesegment.TraceSegment) string
+}
+
+// New creates new instance of Processor, sending batches with exporter x, except segments routed
+// to one of o.Routes, which are sent to that destination instead. Cancelling ctx cancels the calls
+// in flight, and batches left are spooled or dropped.
+func New(ctx context.Context, x exporter.Exporter, segmentBatchProcessorCount int, std *ringbuffer.RingBuffer, pri *ringbuffer.RingBuffer,
+	pool *bufferpool.BufferPool, c *cfg.ParameterConfig, o Options) *Processor {
 	batchesChan := make(chan []string, c.Processor.BatchProcessorQueueSize)
 	segmentBatchDoneChan := make(chan bool)
 	tsb := &segmentsBatch{
-		batches: batchesChan,
-		done:    segmentBatchDoneChan,
-		randGen: rand.New(rand.NewSource(time.Now().UnixNano())),
-		timer:   &timer.Client{},
+		batches:  batchesChan,
+		done:     segmentBatchDoneChan,
+		randGen:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		timer:    &timer.Client{},
+		overflow: o.Overflow,
+		retry: retryConfig{
+			maxRetries: c.Processor.MaxRetries,
+			baseDelay:  time.Millisecond * time.Duration(c.Processor.RetryBaseDelayMillisecond),
+			maxDelay:   time.Millisecond * time.Duration(c.Processor.RetryMaxDelayMillisecond),
+		},
+		budget:      newRetryBudget(c.Processor.RetryBudgetPercent),
+		breaker:     o.Breaker,
+		spoolOnOpen: o.SpoolOnOpen,
 	}
-	x := conn.NewXRay(awsConfig)
-	if x == nil {
-		log.Error("X-Ray client returned nil")
-		os.Exit(1)
+	if c.Processor.RequestsPerSecond > 0 || c.Processor.SegmentsPerSecond > 0 {
+		tsb.rateLimiter = newRateLimiter(c.Processor.RequestsPerSecond, c.Processor.SegmentsPerSecond)
+		log.Infof("Limiting uploads to %v requests and %v segments per second, 0 for no limit", c.Processor.RequestsPerSecond, c.Processor.SegmentsPerSecond)
 	}
-	tsb.xRay = x
+	if c.Processor.MaxConcurrency > 0 {
+		tsb.limiter = newConcurrencyLimiter(segmentBatchProcessorCount, c.Processor.MinConcurrency, c.Processor.MaxConcurrency,
+			time.Millisecond*time.Duration(c.Processor.LatencyThresholdMillisecond))
+		log.Infof("Using adaptive upload concurrency between %v and %v, starting at %v", tsb.limiter.min, tsb.limiter.max, tsb.limiter.current())
+		// Enough go routines to reach the maximum concurrency, the limiter bounds the calls in flight.
+		segmentBatchProcessorCount = c.Processor.MaxConcurrency
+	}
+	if o.Spool != nil {
+		tsb.spool = o.Spool
+		tsb.reachable = make(chan struct{}, 1)
+		tsb.stop = make(chan struct{})
+		tsb.replayInterval = time.Second * time.Duration(c.Processor.SpoolReplayIntervalSecond)
+		tsb.replayBatchSize = c.Processor.BatchSize
+	}
+	tsb.exporter = x
 	doneChan := make(chan bool)
 	log.Debugf("Batch size: %v", c.Processor.BatchSize)
 	p := &Processor{
 		Done:                doneChan,
 		std:                 std,
+		pri:                 pri,
+		fair:                o.Fair,
+		wrap:                o.Wrap,
 		pool:                pool,
 		count:               0,
 		timerClient:         &timer.Client{},
@@ -87,10 +178,35 @@ func New(awsConfig aws.Config, segmentBatchProcessorCount int, std *ringbuffer.R
 		traceSegmentsBatch:  tsb,
 		batchSize:           c.Processor.BatchSize,
 		sendIdleTimeout:     time.Millisecond * time.Duration(c.Processor.IdleTimeoutMillisecond),
+		maxBatchBytes:       c.Processor.BatchMaxBytes,
+		maxBatchAge:         time.Millisecond * time.Duration(c.Processor.BatchMaxAgeMillisecond),
+	}
+	if c.Processor.TraceAffinity {
+		log.Info("Batching segments of the same trace together")
+		p.affinity = newTraceGroups()
+	}
+	if c.Processor.CoalesceWindowMillisecond > 0 {
+		log.Infof("Holding in-progress segments for %v ms until completed, closing them after %v s", c.Processor.CoalesceWindowMillisecond, c.Processor.CoalesceTTLSecond)
+		p.coalesce = newCoalescer(time.Millisecond*time.Duration(c.Processor.CoalesceWindowMillisecond),
+			time.Second*time.Duration(c.Processor.CoalesceTTLSecond), c.Processor.CoalesceMaxBytes)
+	}
+	for _, sc := range o.Sinks {
+		log.Infof("Exporting segments to %v, in addition to X-Ray", sc.Name)
+		p.sinks = append(p.sinks, newSink(ctx, sc, tsb.retry, tsb.budget, tsb.timer))
+	}
+	if len(o.Routes) > 0 {
+		p.routes = make(map[string]*sink, len(o.Routes))
+	}
+	for _, rc := range o.Routes {
+		log.Infof("Routing segments to destination %v", rc.Name)
+		p.routes[rc.Name] = newSink(ctx, rc, tsb.retry, tsb.budget, tsb.timer)
 	}
 
 	for i := 0; i < p.batchProcessorCount; i++ {
-		go p.traceSegmentsBatch.poll()
+		go p.traceSegmentsBatch.poll(ctx)
+	}
+	if o.Spool != nil {
+		go p.traceSegmentsBatch.replay(ctx)
 	}
 
 	go p.poll()
@@ -101,43 +217,132 @@ func New(awsConfig aws.Config, segmentBatchProcessorCount int, std *ringbuffer.R
 func (p *Processor) poll() {
 	batch := make([]*tracesegment.TraceSegment, 0, p.batchSize)
 	p.SetIdleTimer()
+	if p.coalesce != nil {
+		p.coalesceTimer = p.timerClient.After(p.coalesce.window)
+	}
 
-	for {
+	for !p.std.Empty || !p.pri.Empty || (p.fair != nil && !p.fair.Empty) {
+		// Drain priority segments before waiting on any other channel.
 		select {
-		case segment, ok := <-p.std.Channel:
-			if ok {
-				batch = p.receiveTraceSegment(segment, batch)
-			} else {
-				p.std.Empty = true
-			}
+		case segment, ok := <-p.channel(p.pri):
+			batch = p.receive(p.pri, segment, ok, batch)
+			continue
+		default:
+		}
+
+		select {
+		case segment, ok := <-p.channel(p.pri):
+			batch = p.receive(p.pri, segment, ok, batch)
+		case segment, ok := <-p.channel(p.std):
+			batch = p.receive(p.std, segment, ok, batch)
+		case <-p.fairReady():
+			batch = p.receiveFair(batch)
 		case <-p.idleTimer:
-			if len(batch) > 0 {
+			if len(batch) > 0 || p.affinity.len() > 0 {
 				log.Debug("processor: sending partial batch")
-				batch = p.sendBatchAsync(batch)
+				batch = p.sendPending(batch)
 			} else {
 				p.SetIdleTimer()
 			}
-		}
-
-		if p.std.Empty {
-			break
+		case <-p.ageTimer:
+			if len(batch) > 0 || p.affinity.len() > 0 {
+				log.Debug("processor: sending batch at maximum age")
+				batch = p.sendPending(batch)
+			}
+		case <-p.coalesceTimer:
+			batch = p.releaseCoalesced(batch, false)
+			p.coalesceTimer = p.timerClient.After(p.coalesce.window)
 		}
 	}
 
-	if len(batch) > 0 {
-		batch = p.sendBatchAsync(batch)
+	if p.coalesce != nil {
+		batch = p.releaseCoalesced(batch, true)
+	}
+	if len(batch) > 0 || p.affinity.len() > 0 {
+		batch = p.sendPending(batch)
 	}
 	p.traceSegmentsBatch.close()
 	for i := 0; i < p.batchProcessorCount; i++ {
 		<-p.traceSegmentsBatch.done
 	}
+	if p.traceSegmentsBatch.spool != nil {
+		<-p.traceSegmentsBatch.done
+	}
+	for _, k := range p.sinks {
+		k.close()
+	}
+	for _, k := range p.routes {
+		k.close()
+	}
 	log.Debug("processor: done!")
 	p.Done <- true
 }
 
+// channel returns the channel of ring buffer r, nil once r is closed and drained.
+func (p *Processor) channel(r *ringbuffer.RingBuffer) <-chan *tracesegment.TraceSegment {
+	if r.Empty {
+		return nil
+	}
+	return r.Channel
+}
+
+func (p *Processor) receive(r *ringbuffer.RingBuffer, ts *tracesegment.TraceSegment, ok bool, batch []*tracesegment.TraceSegment) []*tracesegment.TraceSegment {
+	if !ok {
+		r.Empty = true
+		return batch
+	}
+	return p.receiveTraceSegment(ts, batch)
+}
+
+// fairReady returns the ready channel of the fair queue, nil if disabled or drained.
+func (p *Processor) fairReady() <-chan struct{} {
+	if p.fair == nil || p.fair.Empty {
+		return nil
+	}
+	return p.fair.Ready
+}
+
+func (p *Processor) receiveFair(batch []*tracesegment.TraceSegment) []*tracesegment.TraceSegment {
+	ts, ok := p.fair.Next()
+	if !ok {
+		p.fair.Empty = p.fair.Closed()
+		return batch
+	}
+	return p.receiveTraceSegment(ts, batch)
+}
+
 func (p *Processor) receiveTraceSegment(ts *tracesegment.TraceSegment, batch []*tracesegment.TraceSegment) []*tracesegment.TraceSegment {
 	atomic.AddUint64(&p.count, 1)
+	if p.coalesce != nil && p.coalesce.hold(ts) {
+		p.pool.Return(ts.PoolBuf)
+		return batch
+	}
+	return p.batchSegment(ts, batch)
+}
+
+// releaseCoalesced adds the in-progress segments due to batch, or every open segment closed if closing is true.
+func (p *Processor) releaseCoalesced(batch []*tracesegment.TraceSegment, closing bool) []*tracesegment.TraceSegment {
+	for _, ts := range p.coalesce.release(closing) {
+		batch = p.batchSegment(ts, batch)
+	}
+	return batch
+}
+
+// batchSegment adds segment ts to batch, sending batch once full.
+func (p *Processor) batchSegment(ts *tracesegment.TraceSegment, batch []*tracesegment.TraceSegment) []*tracesegment.TraceSegment {
+	if p.affinity != nil {
+		return p.receiveAffinity(ts, batch)
+	}
+	size := encodedSize(*ts.Raw)
+	if p.maxBatchBytes > 0 && len(batch) > 0 && requestOverhead+p.batchBytes+size > p.maxBatchBytes {
+		log.Debug("processor: sending batch at maximum size in bytes")
+		batch = p.sendBatchAsync(batch)
+	}
+	if len(batch) == 0 && p.maxBatchAge > 0 {
+		p.ageTimer = p.timerClient.After(p.maxBatchAge)
+	}
 	batch = append(batch, ts)
+	p.batchBytes += size
 
 	if len(batch) >= p.batchSize {
 		log.Debug("processor: sending complete batch")
@@ -150,6 +355,32 @@ func (p *Processor) receiveTraceSegment(ts *tracesegment.TraceSegment, batch []*
 	return batch
 }
 
+// receiveAffinity holds segment ts with the other segments of its trace until the batching window
+// ends, sending them sooner if the buffer pool runs out or enough segments for several batches are held.
+func (p *Processor) receiveAffinity(ts *tracesegment.TraceSegment, batch []*tracesegment.TraceSegment) []*tracesegment.TraceSegment {
+	if p.affinity.len() == 0 && p.maxBatchAge > 0 {
+		p.ageTimer = p.timerClient.After(p.maxBatchAge)
+	}
+	p.affinity.add(ts)
+
+	if p.affinity.len() >= p.batchSize*affinityBatches {
+		log.Debug("processor: sending segments held for trace affinity")
+		batch = p.sendTraceGroups(batch)
+	} else if p.pool.CurrentBuffersLen() == 0 {
+		log.Debug("processor: sending partial batch due to load on buffer pool")
+		batch = p.sendTraceGroups(batch)
+	}
+	return batch
+}
+
+// sendPending sends the current batch, or the segments held by trace affinity batching.
+func (p *Processor) sendPending(batch []*tracesegment.TraceSegment) []*tracesegment.TraceSegment {
+	if p.affinity != nil {
+		return p.sendTraceGroups(batch)
+	}
+	return p.sendBatchAsync(batch)
+}
+
 // Resizing slice doesn't make a copy of the underlying array and hence memory is not
 // garbage collected. (http://blog.golang.org/go-slices-usage-and-internals)
 func (p *Processor) flushBatch(batch []*tracesegment.TraceSegment) []*tracesegment.TraceSegment {
@@ -164,24 +395,85 @@ func (p *Processor) flushBatch(batch []*tracesegment.TraceSegment) []*tracesegme
 func (p *Processor) sendBatchAsync(batch []*tracesegment.TraceSegment) []*tracesegment.TraceSegment {
 	log.Debugf("processor: segment batch size: %d. capacity: %d", len(batch), cap(batch))
 
+	// Documents of segments not routed to another destination, sent to the sinks.
 	segmentDocuments := []string{}
+	// Documents of segments not routed to another destination, sent with exporter x.
+	unrouted := []string{}
+	var routed map[string][]string
 	for _, segment := range batch {
 		rawBytes := *segment.Raw
 		x := string(rawBytes[:])
-		segmentDocuments = append(segmentDocuments, x)
-		p.pool.Return(segment.PoolBuf)
+		_, isRouted := p.routes[segment.Route]
+		if !isRouted {
+			segmentDocuments = append(segmentDocuments, x)
+		}
+		if !isRouted && p.wrap != nil {
+			unrouted = append(unrouted, p.wrap(segment))
+		} else if !isRouted {
+			unrouted = append(unrouted, x)
+		}
+		// Segments held in progress are copied out of the buffer pool.
+		if segment.PoolBuf != nil {
+			p.pool.Return(segment.PoolBuf)
+		}
+		if !isRouted {
+			continue
+		}
+		if routed == nil {
+			routed = make(map[string][]string)
+		}
+		routed[segment.Route] = append(routed[segment.Route], x)
+	}
+	if len(unrouted) > 0 || routed == nil {
+		p.traceSegmentsBatch.send(unrouted)
+	}
+	for name, docs := range routed {
+		p.routes[name].send(docs)
+	}
+	for _, k := range p.sinks {
+		k.send(segmentDocuments)
 	}
-	p.traceSegmentsBatch.send(segmentDocuments)
 	// Reset Idle Timer
 	p.SetIdleTimer()
+	p.ageTimer = nil
+	p.batchBytes = 0
 	return p.flushBatch(batch)
 }
 
+// Size in bytes of a PutTraceSegments request without documents: {"TraceSegmentDocuments":[]}.
+const requestOverhead = 28
+
+// encodedSize returns the size in bytes of segment document raw once encoded as
+// a JSON string in the array of a PutTraceSegments request, separator included.
+func encodedSize(raw []byte) int {
+	size := len(raw) + 3
+	for _, c := range raw {
+		switch {
+		case c == '"' || c == '\\':
+			size++
+		case c < 0x20:
+			// Escaped as \u00XX at most.
+			size += 5
+		}
+	}
+	return size
+}
+
 // ProcessedCount returns number of trace segment received.
 func (p *Processor) ProcessedCount() uint64 {
 	return atomic.LoadUint64(&p.count)
 }
 
+// ExporterLostCount returns number of trace segments exporters, other than routed destinations,
+// dropped or failed to export.
+func (p *Processor) ExporterLostCount() int64 {
+	var lost int64
+	for _, k := range p.sinks {
+		lost += atomic.LoadInt64(&k.lost)
+	}
+	return lost
+}
+
 // SetIdleTimer sets idle timer for the processor instance.
 func (p *Processor) SetIdleTimer() {
 	p.idleTimer = p.timerClient.After(p.sendIdleTimeout)
@@ -16,6 +16,7 @@ import (
 	"github.com/aws/aws-xray-daemon/pkg/telemetry"
 	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
 	"github.com/aws/aws-xray-daemon/pkg/util/test"
+	"math/rand"
 	"strings"
 	"testing"
 	"time"
@@ -92,12 +93,14 @@ func TestSendBatchSuccess(t *testing.T) {
 func TestPollingFewSegmentsExit(t *testing.T) {
 	pool := bufferpool.Init(1, 100)
 	stdChan := ringbuffer.New(20, pool)
+	priChan := ringbuffer.New(20, pool)
 	doneChan := make(chan bool)
 	timer := &test.MockTimerClient{}
 	writer := test.LogSetup()
 	processor := &Processor{
 		timerClient: timer,
 		std:         stdChan,
+		pri:         priChan,
 		count:       0,
 		Done:        doneChan,
 		pool:        pool,
@@ -114,6 +117,7 @@ func TestPollingFewSegmentsExit(t *testing.T) {
 	timer.Advance(time.Duration(10))
 	segment := tracesegment.GetTestTraceSegment()
 	stdChan.Send(&segment)
+	priChan.Close()
 	stdChan.Close()
 
 	<-processor.Done
@@ -126,6 +130,7 @@ func TestPollingFewSegmentsExit(t *testing.T) {
 func TestPollingFewSegmentsIdleTimeout(t *testing.T) {
 	pool := bufferpool.Init(1, 100)
 	stdChan := ringbuffer.New(20, pool)
+	priChan := ringbuffer.New(20, pool)
 	doneChan := make(chan bool)
 	timer := &test.MockTimerClient{}
 
@@ -133,6 +138,7 @@ func TestPollingFewSegmentsIdleTimeout(t *testing.T) {
 	processor := &Processor{
 		timerClient: timer,
 		std:         stdChan,
+		pri:         priChan,
 		count:       0,
 		Done:        doneChan,
 		pool:        pool,
@@ -156,6 +162,7 @@ func TestPollingFewSegmentsIdleTimeout(t *testing.T) {
 	timer.Advance(processor.sendIdleTimeout)
 	time.Sleep(time.Millisecond)
 	// Sleep so that time.After trigger batch send and not closing of the channel
+	priChan.Close()
 	stdChan.Close()
 
 	<-doneChan
@@ -170,6 +177,7 @@ func TestPollingBatchBufferFull(t *testing.T) {
 	pool := bufferpool.Init(1, 100)
 	// Setting stdChan to batchSize so that it does not spill over
 	stdChan := ringbuffer.New(batchSize, pool)
+	priChan := ringbuffer.New(batchSize, pool)
 	doneChan := make(chan bool)
 	timer := &test.MockTimerClient{}
 
@@ -178,6 +186,7 @@ func TestPollingBatchBufferFull(t *testing.T) {
 	processor := &Processor{
 		timerClient:         timer,
 		std:                 stdChan,
+		pri:                 priChan,
 		count:               0,
 		Done:                doneChan,
 		batchProcessorCount: segmentProcessorCount,
@@ -197,6 +206,7 @@ func TestPollingBatchBufferFull(t *testing.T) {
 		stdChan.Send(&segment)
 
 	}
+	priChan.Close()
 	stdChan.Close()
 	processor.traceSegmentsBatch.done <- true
 
@@ -215,6 +225,7 @@ func TestPollingBufferPoolExhaustedForcingSent(t *testing.T) {
 	pool.Get()
 	assert.EqualValues(t, pool.CurrentBuffersLen(), 0)
 	stdChan := ringbuffer.New(batchSize, pool)
+	priChan := ringbuffer.New(batchSize, pool)
 	doneChan := make(chan bool)
 	timer := &test.MockTimerClient{}
 
@@ -223,6 +234,7 @@ func TestPollingBufferPoolExhaustedForcingSent(t *testing.T) {
 	processor := &Processor{
 		timerClient:         timer,
 		std:                 stdChan,
+		pri:                 priChan,
 		count:               0,
 		Done:                doneChan,
 		batchProcessorCount: segmentProcessorCount,
@@ -239,6 +251,7 @@ func TestPollingBufferPoolExhaustedForcingSent(t *testing.T) {
 
 	segment := tracesegment.GetTestTraceSegment()
 	stdChan.Send(&segment)
+	priChan.Close()
 	stdChan.Close()
 	processor.traceSegmentsBatch.done <- true
 
@@ -255,10 +268,12 @@ func TestPollingIdleTimerIsInitiatedAfterElapseWithNoSegments(t *testing.T) {
 	pool := bufferpool.Init(1, 100)
 	batchSize := 50
 	stdChan := ringbuffer.New(batchSize, pool)
+	priChan := ringbuffer.New(batchSize, pool)
 	processor := &Processor{
 		Done:        make(chan bool),
 		timerClient: timer,
 		std:         stdChan,
+		pri:         priChan,
 		pool:        pool,
 		traceSegmentsBatch: &segmentsBatch{
 			batches: make(chan []string, 1),
@@ -275,9 +290,273 @@ func TestPollingIdleTimerIsInitiatedAfterElapseWithNoSegments(t *testing.T) {
 	timer.Advance(processor.sendIdleTimeout)
 	// sleep so that routine exist after timeout is tiggered
 	time.Sleep(time.Millisecond)
+	priChan.Close()
 	stdChan.Close()
 	<-processor.Done
 
 	// Called twice once at poll start and then after the timeout was triggered
 	assert.EqualValues(t, timer.AfterCalledTimes(), 2)
 }
+
+func TestPollingPrioritySegmentsFirst(t *testing.T) {
+	pool := bufferpool.Init(1, 100)
+	stdChan := ringbuffer.New(20, pool)
+	priChan := ringbuffer.New(20, pool)
+	test.LogSetup()
+	processor := &Processor{
+		timerClient: &test.MockTimerClient{},
+		std:         stdChan,
+		pri:         priChan,
+		Done:        make(chan bool),
+		pool:        pool,
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 1),
+		},
+		sendIdleTimeout: time.Second,
+		batchSize:       50,
+	}
+	stdSegment := tracesegment.GetTestTraceSegment()
+	priSegment := tracesegment.GetTestTraceSegment()
+	stdChan.Send(&stdSegment)
+	priChan.Send(&priSegment)
+	priChan.Close()
+	stdChan.Close()
+
+	go processor.poll()
+	<-processor.Done
+
+	batch := <-processor.traceSegmentsBatch.batches
+	assert.EqualValues(t, 2, len(batch))
+	assert.EqualValues(t, string(*priSegment.Raw), batch[0])
+	assert.EqualValues(t, string(*stdSegment.Raw), batch[1])
+	assert.True(t, stdChan.Empty)
+	assert.True(t, priChan.Empty)
+}
+
+func TestPollingFairQueue(t *testing.T) {
+	pool := bufferpool.Init(1, 100)
+	stdChan := ringbuffer.New(20, pool)
+	priChan := ringbuffer.New(20, pool)
+	fair := ringbuffer.NewFairQueue(20, pool, nil, 10)
+	test.LogSetup()
+	processor := &Processor{
+		timerClient: &test.MockTimerClient{},
+		std:         stdChan,
+		pri:         priChan,
+		fair:        fair,
+		Done:        make(chan bool),
+		pool:        pool,
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 1),
+		},
+		sendIdleTimeout: time.Second,
+		batchSize:       50,
+	}
+	a1 := tracesegment.GetTestTraceSegment()
+	a2 := tracesegment.GetTestTraceSegment()
+	b1 := tracesegment.GetTestTraceSegment()
+	fair.Send("a", &a1)
+	fair.Send("a", &a2)
+	fair.Send("b", &b1)
+	priChan.Close()
+	stdChan.Close()
+	fair.Close()
+
+	go processor.poll()
+	<-processor.Done
+
+	batch := <-processor.traceSegmentsBatch.batches
+	assert.EqualValues(t, []string{string(*a1.Raw), string(*b1.Raw), string(*a2.Raw)}, batch)
+	assert.True(t, fair.Empty)
+}
+
+func TestPollingBatchMaxBytes(t *testing.T) {
+	pool := bufferpool.Init(10, 100)
+	stdChan := ringbuffer.New(20, pool)
+	priChan := ringbuffer.New(20, pool)
+	test.LogSetup()
+	segment := tracesegment.GetTestTraceSegment()
+	processor := &Processor{
+		timerClient: &test.MockTimerClient{},
+		std:         stdChan,
+		pri:         priChan,
+		Done:        make(chan bool),
+		pool:        pool,
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 3),
+		},
+		sendIdleTimeout: time.Second,
+		batchSize:       50,
+		// Room for two segments per request.
+		maxBatchBytes: requestOverhead + 2*encodedSize(*segment.Raw),
+	}
+	for i := 0; i < 5; i++ {
+		s := tracesegment.GetTestTraceSegment()
+		// Same size for every segment, ids of test segments vary in length.
+		s.Raw = segment.Raw
+		stdChan.Send(&s)
+	}
+	priChan.Close()
+	stdChan.Close()
+
+	go processor.poll()
+	<-processor.Done
+
+	assert.EqualValues(t, 2, len(<-processor.traceSegmentsBatch.batches))
+	assert.EqualValues(t, 2, len(<-processor.traceSegmentsBatch.batches))
+	assert.EqualValues(t, 1, len(<-processor.traceSegmentsBatch.batches))
+}
+
+func TestPollingBatchMaxAge(t *testing.T) {
+	pool := bufferpool.Init(10, 100)
+	stdChan := ringbuffer.New(20, pool)
+	priChan := ringbuffer.New(20, pool)
+	timer := &test.MockTimerClient{}
+	writer := test.LogSetup()
+	processor := &Processor{
+		timerClient: timer,
+		std:         stdChan,
+		pri:         priChan,
+		Done:        make(chan bool),
+		pool:        pool,
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 1),
+		},
+		sendIdleTimeout: time.Minute,
+		batchSize:       50,
+		maxBatchAge:     time.Second,
+	}
+
+	go processor.poll()
+
+	time.Sleep(time.Millisecond)
+	segment := tracesegment.GetTestTraceSegment()
+	stdChan.Send(&segment)
+	time.Sleep(time.Millisecond)
+	// Fires the age timer only, the idle timer is a minute long.
+	timer.Advance(processor.maxBatchAge)
+	time.Sleep(time.Millisecond)
+	priChan.Close()
+	stdChan.Close()
+
+	<-processor.Done
+
+	assert.True(t, strings.Contains(writer.Logs[0], "sending batch at maximum age"))
+	assert.EqualValues(t, 1, len(<-processor.traceSegmentsBatch.batches))
+}
+
+func TestEncodedSize(t *testing.T) {
+	assert.Equal(t, len(`"{}",`), encodedSize([]byte(`{}`)))
+	assert.Equal(t, len(`"{\"a\":\"\\n\"}",`), encodedSize([]byte(`{"a":"\n"}`)))
+	assert.True(t, encodedSize([]byte("{\n}")) >= len(`"{\n}",`))
+}
+
+func getTestTraceSegment(traceID string) *tracesegment.TraceSegment {
+	s := tracesegment.GetTestTraceSegment()
+	raw := []byte(fmt.Sprintf(`{"trace_id":"%v","id":"%v"}`, traceID, rand.Int()))
+	s.Raw = &raw
+	return &s
+}
+
+func TestPollingTraceAffinity(t *testing.T) {
+	pool := bufferpool.Init(10, 100)
+	stdChan := ringbuffer.New(20, pool)
+	priChan := ringbuffer.New(20, pool)
+	test.LogSetup()
+	processor := &Processor{
+		timerClient: &test.MockTimerClient{},
+		std:         stdChan,
+		pri:         priChan,
+		Done:        make(chan bool),
+		pool:        pool,
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 3),
+		},
+		sendIdleTimeout: time.Second,
+		batchSize:       3,
+		affinity:        newTraceGroups(),
+	}
+	for _, traceID := range []string{"a", "b", "a", "c", "b", "a"} {
+		stdChan.Send(getTestTraceSegment(traceID))
+	}
+	priChan.Close()
+	stdChan.Close()
+
+	go processor.poll()
+	<-processor.Done
+
+	traceIDs := func(batch []string) []string {
+		ids := []string{}
+		for _, doc := range batch {
+			ids = append(ids, traceIdRegexp.FindStringSubmatch(doc)[1])
+		}
+		return ids
+	}
+	assert.EqualValues(t, []string{"a", "a", "a"}, traceIDs(<-processor.traceSegmentsBatch.batches))
+	assert.EqualValues(t, []string{"b", "b", "c"}, traceIDs(<-processor.traceSegmentsBatch.batches))
+	assert.EqualValues(t, 6, processor.ProcessedCount())
+}
+
+func TestPollingTraceAffinityHeldUntilMaxAge(t *testing.T) {
+	pool := bufferpool.Init(10, 100)
+	stdChan := ringbuffer.New(20, pool)
+	priChan := ringbuffer.New(20, pool)
+	timer := &test.MockTimerClient{}
+	writer := test.LogSetup()
+	processor := &Processor{
+		timerClient: timer,
+		std:         stdChan,
+		pri:         priChan,
+		Done:        make(chan bool),
+		pool:        pool,
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 2),
+		},
+		sendIdleTimeout: time.Minute,
+		batchSize:       1,
+		maxBatchAge:     time.Second,
+		affinity:        newTraceGroups(),
+	}
+
+	go processor.poll()
+
+	time.Sleep(time.Millisecond)
+	stdChan.Send(getTestTraceSegment("a"))
+	stdChan.Send(getTestTraceSegment("b"))
+	time.Sleep(time.Millisecond)
+	assert.EqualValues(t, 0, len(processor.traceSegmentsBatch.batches), "Full batches are held in the batching window")
+	timer.Advance(processor.maxBatchAge)
+	time.Sleep(time.Millisecond)
+	priChan.Close()
+	stdChan.Close()
+
+	<-processor.Done
+
+	assert.True(t, strings.Contains(writer.Logs[0], "sending batch at maximum age"))
+	assert.EqualValues(t, 1, len(<-processor.traceSegmentsBatch.batches))
+	assert.EqualValues(t, 1, len(<-processor.traceSegmentsBatch.batches))
+}
+
+func TestSendTraceGroupsSplitsLargeTrace(t *testing.T) {
+	test.LogSetup()
+	processor := &Processor{
+		timerClient: &test.MockTimerClient{},
+		pool:        bufferpool.Init(10, 100),
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 3),
+		},
+		batchSize: 2,
+		affinity:  newTraceGroups(),
+	}
+	for _, traceID := range []string{"a", "b", "b", "b"} {
+		processor.affinity.add(getTestTraceSegment(traceID))
+	}
+
+	batch := processor.sendTraceGroups(make([]*tracesegment.TraceSegment, 0, 2))
+
+	assert.EqualValues(t, 0, len(batch))
+	assert.EqualValues(t, 0, processor.affinity.len())
+	assert.EqualValues(t, 1, len(<-processor.traceSegmentsBatch.batches))
+	assert.EqualValues(t, 2, len(<-processor.traceSegmentsBatch.batches))
+	assert.EqualValues(t, 1, len(<-processor.traceSegmentsBatch.batches))
+}
@@ -0,0 +1,126 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	log "github.com/cihub/seelog"
+)
+
+// Lowest share of the configured rates the limiter falls back to on throttling.
+const minRateScale = 0.1
+
+// Share of the configured rates recovered by every successful request.
+const rateScaleRecovery = 0.05
+
+// tokenBucket holds tokens refilled at rate per second, up to one second worth of tokens.
+type tokenBucket struct {
+	// Configured tokens per second, 0 for no limit.
+	rate float64
+
+	// Tokens available, negative once reserved ahead of time.
+	tokens float64
+
+	// Time tokens were last refilled.
+	last time.Time
+}
+
+// reserve takes n tokens at time now, filling at scale times the configured rate, and returns how long to wait for them.
+func (b *tokenBucket) reserve(now time.Time, n float64, scale float64) time.Duration {
+	if b.rate == 0 {
+		return 0
+	}
+	rate := b.rate * scale
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// rateLimiter bounds the requests and segments per second sent to X-Ray service,
+// lowering both rates while requests are throttled.
+type rateLimiter struct {
+	lock sync.Mutex
+
+	requests tokenBucket
+	segments tokenBucket
+
+	// Share of the configured rates currently allowed.
+	scale float64
+
+	// Time the rates were last lowered.
+	lastDecrease time.Time
+
+	// Returns current time, replaced in tests.
+	now func() time.Time
+}
+
+// newRateLimiter returns a limiter allowing requestsPerSecond and segmentsPerSecond, 0 for no limit.
+func newRateLimiter(requestsPerSecond int, segmentsPerSecond int) *rateLimiter {
+	now := time.Now()
+	return &rateLimiter{
+		requests: tokenBucket{rate: float64(requestsPerSecond), tokens: float64(requestsPerSecond), last: now},
+		segments: tokenBucket{rate: float64(segmentsPerSecond), tokens: float64(segmentsPerSecond), last: now},
+		scale:    1,
+		now:      time.Now,
+	}
+}
+
+// reserve takes the tokens of a request of n segments, and returns how long to wait before sending it.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	wait := l.requests.reserve(now, 1, l.scale)
+	if w := l.segments.reserve(now, float64(n), l.scale); w > wait {
+		wait = w
+	}
+	return wait
+}
+
+// throttled lowers the rates after a throttled request, at most once per decrease interval.
+func (l *rateLimiter) throttled() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	if now.Sub(l.lastDecrease) < decreaseInterval || l.scale == minRateScale {
+		return
+	}
+	l.lastDecrease = now
+	l.scale *= decreaseFactor
+	if l.scale < minRateScale {
+		l.scale = minRateScale
+	}
+	log.Warnf("Upload rate lowered to %.0f%% of the configured limits after throttling", l.scale*100)
+	telemetry.T.Count(counterName+".rate.decrease", 1)
+}
+
+// succeeded raises the rates back towards the configured limits after a successful request.
+func (l *rateLimiter) succeeded() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.scale == 1 {
+		return
+	}
+	l.scale += rateScaleRecovery
+	if l.scale >= 1 {
+		l.scale = 1
+		log.Infof("Upload rate restored to the configured limits")
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestRateLimiter(requestsPerSecond int, segmentsPerSecond int) (*rateLimiter, *time.Time) {
+	now := time.Unix(1500000000, 0)
+	l := newRateLimiter(requestsPerSecond, segmentsPerSecond)
+	l.now = func() time.Time { return now }
+	l.requests.last = now
+	l.segments.last = now
+	return l, &now
+}
+
+func TestRateLimiterRequests(t *testing.T) {
+	l, now := getTestRateLimiter(2, 0)
+
+	assert.EqualValues(t, 0, l.reserve(50))
+	assert.EqualValues(t, 0, l.reserve(50))
+	assert.EqualValues(t, 500*time.Millisecond, l.reserve(50), "The third request in a second waits for a token")
+
+	*now = now.Add(time.Second)
+	assert.EqualValues(t, 0, l.reserve(50))
+}
+
+func TestRateLimiterSegments(t *testing.T) {
+	l, now := getTestRateLimiter(0, 100)
+
+	assert.EqualValues(t, 0, l.reserve(50))
+	assert.EqualValues(t, 0, l.reserve(50))
+	assert.EqualValues(t, 250*time.Millisecond, l.reserve(25))
+
+	*now = now.Add(10 * time.Second)
+	assert.EqualValues(t, 0, l.reserve(100), "Tokens do not accumulate beyond one second worth")
+	assert.EqualValues(t, 10*time.Millisecond, l.reserve(1))
+}
+
+func TestRateLimiterThrottled(t *testing.T) {
+	l, now := getTestRateLimiter(10, 0)
+
+	l.throttled()
+	l.throttled()
+	assert.EqualValues(t, 0.5, l.scale, "Throttling within the decrease interval lowers the rates once")
+
+	for i := 0; i < 5; i++ {
+		*now = now.Add(decreaseInterval)
+		l.throttled()
+	}
+	assert.EqualValues(t, minRateScale, l.scale)
+
+	for i := 0; i < 100; i++ {
+		l.succeeded()
+	}
+	assert.EqualValues(t, 1, l.scale, "Successful requests restore the configured rates")
+}
+
+func TestRateLimiterThrottledRate(t *testing.T) {
+	l, _ := getTestRateLimiter(10, 0)
+	l.throttled()
+
+	for i := 0; i < 5; i++ {
+		assert.EqualValues(t, 0, l.reserve(1))
+	}
+	assert.EqualValues(t, 200*time.Millisecond, l.reserve(1), "Tokens refill at half the rate")
+}
@@ -0,0 +1,112 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/aws/aws-xray-daemon/pkg/overflow"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	log "github.com/cihub/seelog"
+)
+
+// Name of the spool used in telemetry counters.
+const spoolCounterName = "spool"
+
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+// Spool stores segment batches which cannot be delivered, to be replayed once X-Ray service is reachable.
+type Spool interface {
+	overflow.Spiller
+
+	// Replay sends spooled documents to send in batches of at most batchSize, and returns the number sent.
+	Replay(batchSize int, send func(docs []string) error) (int, error)
+}
+
+// spoolBatch writes batch, which could not be delivered, to the spool.
+func (s *segmentsBatch) spoolBatch(batch []string) {
+	if err := s.spool.Spill(batch); err != nil {
+		log.Errorf("Unable to spool segment batch: %v", err)
+		telemetry.T.Count(spoolCounterName+".write-failed", int64(len(batch)))
+		return
+	}
+	atomic.StoreInt32(&s.spoolPending, 1)
+	log.Warnf("Spooled batch of %d segments to disk", len(batch))
+	telemetry.T.Count(spoolCounterName+".write", int64(len(batch)))
+}
+
+// replay sends spooled segments on every replay interval, or as soon as a batch is delivered,
+// until the batches channel is closed.
+func (s *segmentsBatch) replay(ctx context.Context) {
+	// Segments may be left by a previous run.
+	atomic.StoreInt32(&s.spoolPending, 1)
+	ticker := s.timer.Tick(s.replayInterval)
+	for {
+		select {
+		case <-s.stop:
+			log.Trace("Segment spool replay: done!")
+			s.done <- true
+			return
+		case <-s.reachable:
+		case <-ticker:
+		}
+		if atomic.LoadInt32(&s.spoolPending) == 0 {
+			continue
+		}
+		n, err := s.spool.Replay(s.replayBatchSize, func(docs []string) error {
+			return s.sendSpooled(ctx, docs)
+		})
+		if n > 0 {
+			log.Infof("Replayed %d spooled segments", n)
+		}
+		if err != nil {
+			log.Warnf("Replaying spooled segments stopped with: %v", err)
+			continue
+		}
+		atomic.StoreInt32(&s.spoolPending, 0)
+	}
+}
+
+// sendSpooled sends spooled docs to X-Ray service, without retries.
+func (s *segmentsBatch) sendSpooled(ctx context.Context, docs []string) error {
+	if s.breaker != nil && !s.breaker.Allow() {
+		return errBreakerOpen
+	}
+	unprocessed, err := s.call(ctx, docs)
+	if err != nil {
+		telemetry.EvaluateConnectionError(err)
+		return err
+	}
+	telemetry.T.SegmentSent(int64(len(docs)))
+	telemetry.T.Count(spoolCounterName+".replay", int64(len(docs)))
+	if len(unprocessed) != 0 {
+		log.Errorf("Replayed batch of %d spooled segments had %d Unprocessed segments", len(docs), len(unprocessed))
+		telemetry.T.SegmentRejected(int64(len(unprocessed)))
+	}
+	return nil
+}
+
+// SpooledCount returns number of segments written to the spool, or spilled by the spill overflow policy of the batch queue.
+func SpooledCount() int64 {
+	return telemetry.T.Counter(spoolCounterName+".write") + telemetry.T.Counter(overflow.Spill.Counter(counterName))
+}
+
+// signalReachable wakes up the replay of spooled segments after a batch was delivered.
+func (s *segmentsBatch) signalReachable() {
+	if s.spool == nil || atomic.LoadInt32(&s.spoolPending) == 0 {
+		return
+	}
+	select {
+	case s.reachable <- struct{}{}:
+	default:
+	}
+}
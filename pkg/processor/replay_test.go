@@ -0,0 +1,140 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/aws/aws-xray-daemon/pkg/util/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockSpool struct {
+	mockSpiller
+}
+
+func (m *mockSpool) Replay(batchSize int, send func(docs []string) error) (int, error) {
+	if len(m.docs) == 0 {
+		return 0, nil
+	}
+	if err := send(m.docs); err != nil {
+		return 0, err
+	}
+	n := len(m.docs)
+	m.docs = nil
+	return n, nil
+}
+
+func getSpoolTestSegmentsBatch(xRay *MockXRayClient, sp *mockSpool) *segmentsBatch {
+	s := getRetryTestSegmentsBatch(xRay, 0, nil)
+	s.timer = &test.MockTimerClient{}
+	s.spool = sp
+	s.reachable = make(chan struct{}, 1)
+	s.stop = make(chan struct{})
+	s.replayBatchSize = 50
+	return s
+}
+
+func TestPollSendFailedSpooled(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	xRay.On("PutTraceSegments", nil).Return("connection timeout").Once()
+	sp := &mockSpool{}
+	s := getSpoolTestSegmentsBatch(xRay, sp)
+	testMessage := "{\"id\":\"9472\"}"
+	s.send([]string{testMessage})
+
+	go s.poll(context.Background())
+	s.close()
+	<-s.done
+
+	assert.EqualValues(t, []string{testMessage}, sp.docs)
+	assert.EqualValues(t, 1, telemetry.T.Counter("spool.write"))
+	assert.EqualValues(t, 1, s.spoolPending)
+}
+
+func TestPollSendFailedNotRetryableNotSpooled(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	xRay.On("PutTraceSegments", nil).Return("InvalidRequestException").Once()
+	sp := &mockSpool{}
+	s := getSpoolTestSegmentsBatch(xRay, sp)
+	s.send([]string{"{\"id\":\"9472\"}"})
+
+	go s.poll(context.Background())
+	s.close()
+	<-s.done
+
+	assert.Empty(t, sp.docs, "Batches rejected by X-Ray are not spooled")
+}
+
+func TestPollSendFailedWithoutResponseSpooled(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	xRay.On("PutTraceSegments", nil).Return("dial tcp: lookup xray.us-east-1.amazonaws.com: no such host").Once()
+	sp := &mockSpool{}
+	s := getSpoolTestSegmentsBatch(xRay, sp)
+	testMessage := "{\"id\":\"9472\"}"
+	s.send([]string{testMessage})
+
+	go s.poll(context.Background())
+	s.close()
+	<-s.done
+
+	assert.EqualValues(t, []string{testMessage}, sp.docs, "Failures without answer of X-Ray are spooled")
+}
+
+func TestReplayWhenReachable(t *testing.T) {
+	log := test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	replayed := make(chan bool, 1)
+	xRay.On("PutTraceSegments", nil).Return("").Once().Run(func(mock.Arguments) { replayed <- true })
+	testMessage := "{\"id\":\"9472\"}"
+	sp := &mockSpool{mockSpiller{docs: []string{testMessage}}}
+	s := getSpoolTestSegmentsBatch(xRay, sp)
+
+	go s.replay(context.Background())
+	s.reachable <- struct{}{}
+	<-replayed
+	s.close()
+	<-s.done
+
+	assert.EqualValues(t, []string{testMessage}, xRay.input.TraceSegmentDocuments)
+	assert.Empty(t, sp.docs)
+	assert.EqualValues(t, 1, telemetry.T.Counter("spool.replay"))
+	assert.Contains(t, log.Logs[0], "Replayed 1 spooled segments")
+}
+
+func TestPollCancelledSpoolsBatch(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	xRay := new(MockXRayClient)
+	sp := &mockSpool{}
+	s := getSpoolTestSegmentsBatch(xRay, sp)
+	testMessage := "{\"id\":\"1\"}"
+	s.send([]string{testMessage})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	go s.poll(ctx)
+	s.close()
+	<-s.done
+
+	assert.EqualValues(t, 0, xRay.CallNoToPutTraceSegments)
+	assert.EqualValues(t, []string{testMessage}, sp.docs)
+	assert.EqualValues(t, 1, SpooledCount())
+}
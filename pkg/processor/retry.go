@@ -0,0 +1,191 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/conn"
+	"github.com/aws/aws-xray-daemon/pkg/exporter"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Maximum number of retries the budget holds.
+const maxRetryTokens = 10
+
+// Error codes returned by X-Ray when requests are throttled.
+var throttleErrorCodes = map[string]bool{
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"TooManyRequests":                        true,
+	"RequestLimitExceeded":                   true,
+	"ProvisionedThroughputExceededException": true,
+}
+
+// Error codes of unprocessed segments which may be accepted when resubmitted.
+var transientErrorCodes = map[string]bool{
+	"InternalFailure":             true,
+	"InternalError":               true,
+	"InternalServerError":         true,
+	"ServiceUnavailable":          true,
+	"ServiceUnavailableException": true,
+}
+
+// retryConfig bounds the retries of a failed batch.
+type retryConfig struct {
+	// Maximum number of retries of a batch, 0 disables retries.
+	maxRetries int
+
+	// Upper bound of the first backoff, doubled on every retry.
+	baseDelay time.Duration
+
+	// Upper bound of any backoff.
+	maxDelay time.Duration
+}
+
+// backoff returns a random delay drawn from randGen between 0 and the exponential backoff of the given attempt,
+// capped at the maximum delay.
+func (c retryConfig) backoff(attempt int, randGen *rand.Rand) time.Duration {
+	ceiling := c.maxDelay
+	if attempt < 32 && c.baseDelay<<uint(attempt) < ceiling {
+		ceiling = c.baseDelay << uint(attempt)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(randGen.Int63n(int64(ceiling) + 1))
+}
+
+// retryBudget limits retries to a share of successful requests, so retries cannot starve fresh data.
+type retryBudget struct {
+	lock sync.Mutex
+
+	// Retries currently available.
+	tokens float64
+
+	// Retries earned by every successful request.
+	ratio float64
+}
+
+// newRetryBudget returns a full retry budget earning percent retries per hundred successful requests.
+func newRetryBudget(percent int) *retryBudget {
+	return &retryBudget{
+		tokens: maxRetryTokens,
+		ratio:  float64(percent) / 100,
+	}
+}
+
+// withdraw takes a retry from the budget, returns false if none is available.
+func (b *retryBudget) withdraw() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// deposit credits the budget for a successful request.
+func (b *retryBudget) deposit() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > maxRetryTokens {
+		b.tokens = maxRetryTokens
+	}
+}
+
+// isTransient returns true if an unprocessed segment failed with error code for a transient reason.
+func isTransient(code *string) bool {
+	if code == nil {
+		return false
+	}
+	return throttleErrorCodes[*code] || transientErrorCodes[*code]
+}
+
+// isThrottled returns true for errors of requests throttled by X-Ray service.
+func isThrottled(err error) bool {
+	var re *smithyhttp.ResponseError
+	if errors.As(err, &re) && re.Response != nil && re.Response.StatusCode == 429 {
+		return true
+	}
+	var se *exporter.StatusError
+	if errors.As(err, &se) && se.StatusCode == 429 {
+		return true
+	}
+	var ae smithy.APIError
+	return errors.As(err, &ae) && throttleErrorCodes[ae.ErrorCode()]
+}
+
+// isRetryable returns true for errors which may succeed when retried:
+// 5xx responses, throttling, timeouts and transport failures such as refused or reset connections.
+func isRetryable(err error) bool {
+	if isTransportError(err) {
+		return true
+	}
+	var re *smithyhttp.ResponseError
+	if errors.As(err, &re) && re.Response != nil && re.Response.Response != nil {
+		statusCode := re.Response.StatusCode
+		if statusCode >= 500 || statusCode == 429 {
+			return true
+		}
+	}
+	var se *exporter.StatusError
+	if errors.As(err, &se) && (se.StatusCode >= 500 || se.StatusCode == 429) {
+		return true
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) && throttleErrorCodes[ae.ErrorCode()] {
+		return true
+	}
+	if conn.IsTimeoutError(err) {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || strings.Contains(err.Error(), "connection reset")
+}
+
+// isTransportError returns true for errors of requests which got no response: connections refused,
+// reset or unreachable, and host names which could not be resolved.
+func isTransportError(err error) bool {
+	var re *smithyhttp.ResponseError
+	if errors.As(err, &re) && (re.Response == nil || re.Response.Response == nil || re.Response.StatusCode == 0) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// isRejected returns true for errors of requests the destination answered with an error which is not
+// retryable, such as API errors of X-Ray service or 4xx responses, as they would fail again.
+func isRejected(err error) bool {
+	return isAnswered(err) && !isRetryable(err)
+}
+
+// isAnswered returns true for errors of requests the destination sent a response to.
+func isAnswered(err error) bool {
+	var re *smithyhttp.ResponseError
+	if errors.As(err, &re) && re.Response != nil && re.Response.Response != nil && re.Response.StatusCode != 0 {
+		return true
+	}
+	var se *exporter.StatusError
+	if errors.As(err, &se) {
+		return true
+	}
+	var ae smithy.APIError
+	return errors.As(err, &ae)
+}
@@ -0,0 +1,123 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-xray-daemon/pkg/conn"
+	"github.com/aws/aws-xray-daemon/pkg/exporter"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func getResponseError(statusCode int) error {
+	return &smithy.OperationError{
+		ServiceID:     "XRay",
+		OperationName: "PutTraceSegments",
+		Err: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: statusCode}},
+			Err:      errors.New("response error"),
+		},
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, isRetryable(getResponseError(503)))
+	assert.True(t, isRetryable(getResponseError(429)))
+	assert.True(t, isRetryable(&smithy.GenericAPIError{Code: "ThrottlingException"}))
+	assert.True(t, isRetryable(errors.New("context deadline exceeded")))
+	assert.True(t, isRetryable(fmt.Errorf("read: %w", syscall.ECONNRESET)))
+	assert.True(t, isRetryable(&exporter.StatusError{StatusCode: 502}))
+	assert.True(t, isRetryable(&exporter.StatusError{StatusCode: 429}))
+	assert.False(t, isRetryable(&exporter.StatusError{StatusCode: 401}))
+	assert.False(t, isRetryable(getResponseError(400)))
+	assert.False(t, isRetryable(&smithy.GenericAPIError{Code: "InvalidRequestException"}))
+	assert.False(t, isRetryable(errors.New("invalid segment")))
+}
+
+// getRefusedXRay returns an X-Ray client whose connections are refused, as nothing listens on port 1.
+func getRefusedXRay() conn.XRay {
+	return conn.NewXRay(aws.Config{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String("http://127.0.0.1:1"),
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})
+}
+
+func TestIsRetryableTransportError(t *testing.T) {
+	// Nothing listens on port 1.
+	_, err := http.Get("http://127.0.0.1:1")
+	assert.True(t, isRetryable(err), "Refused connection: %v", err)
+	assert.True(t, isRetryable(&smithy.OperationError{
+		ServiceID:     "XRay",
+		OperationName: "PutTraceSegments",
+		Err: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{}},
+			Err:      &smithyhttp.RequestSendError{Err: err},
+		},
+	}), "Request the SDK failed to send")
+	assert.True(t, isRetryable(&net.DNSError{Err: "no such host", Name: "xray.invalid", IsNotFound: true}))
+
+	x := exporter.NewXRay(getRefusedXRay())
+	_, err = x.Export(context.Background(), []string{"{}"})
+	assert.True(t, isRetryable(err), "Refused connection of X-Ray client: %v", err)
+}
+
+func TestIsThrottled(t *testing.T) {
+	assert.True(t, isThrottled(getResponseError(429)))
+	assert.True(t, isThrottled(&smithy.GenericAPIError{Code: "ThrottlingException"}))
+	assert.False(t, isThrottled(getResponseError(503)))
+	assert.True(t, isThrottled(&exporter.StatusError{StatusCode: 429}))
+	assert.False(t, isThrottled(errors.New("context deadline exceeded")))
+}
+
+func TestIsRejected(t *testing.T) {
+	assert.True(t, isRejected(&exporter.StatusError{StatusCode: 400}))
+	assert.False(t, isRejected(&exporter.StatusError{StatusCode: 503}))
+	assert.False(t, isRejected(errors.New("connection refused")))
+	assert.True(t, isRejected(getResponseError(400)))
+	assert.True(t, isRejected(&smithy.GenericAPIError{Code: "InvalidRequestException"}))
+	assert.False(t, isRejected(&smithy.GenericAPIError{Code: "ThrottlingException"}))
+	assert.False(t, isRejected(context.Canceled))
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(50)
+	for i := 0; i < maxRetryTokens; i++ {
+		assert.True(t, b.withdraw())
+	}
+	assert.False(t, b.withdraw(), "The budget should be exhausted")
+
+	b.deposit()
+	assert.False(t, b.withdraw(), "Half a retry should not be available")
+	b.deposit()
+	assert.True(t, b.withdraw())
+}
+
+func TestIsTransient(t *testing.T) {
+	throttled := "ThrottledException"
+	internal := "InternalFailure"
+	invalid := "InvalidTraceId"
+
+	assert.True(t, isTransient(&throttled))
+	assert.True(t, isTransient(&internal))
+	assert.False(t, isTransient(&invalid))
+	assert.False(t, isTransient(nil))
+}
@@ -0,0 +1,251 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	"github.com/aws/aws-xray-daemon/pkg/breaker"
+	"github.com/aws/aws-xray-daemon/pkg/deadletter"
+	"github.com/aws/aws-xray-daemon/pkg/exporter"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/aws/aws-xray-daemon/pkg/util/timer"
+	log "github.com/cihub/seelog"
+)
+
+// Prefix of the telemetry counters of sinks, followed by the sink name.
+const sinkCounterName = "export."
+
+// SinkConfig describes an exporter every batch is sent to, in addition to X-Ray service.
+type SinkConfig struct {
+	// Name identifying the sink in logs and telemetry counters.
+	Name string
+
+	// Exporter sending the batches.
+	Exporter exporter.Exporter
+
+	// Number of batches queued for the sink, the oldest batch is dropped when full.
+	QueueSize int
+
+	// Number of go routines sending batches.
+	Concurrency int
+
+	// Maximum number of retries of a failed batch, 0 disables retries.
+	MaxRetries int
+
+	// ShareRetryBudget, if true, limits retries to the retry budget of X-Ray uploads.
+	ShareRetryBudget bool
+
+	// Circuit breaker around exports, nil if disabled. Batches are dropped while it is open.
+	Breaker *breaker.Breaker
+
+	// Routed, if true, marks a destination segments are routed to instead of X-Ray service. Unlike X-Ray
+	// service, a destination has no spool: segments it drops or fails to receive are dead-lettered,
+	// and counted in the segment totals like those of X-Ray service.
+	Routed bool
+}
+
+// sink sends batches to an exporter from its own queue, so a slow or failing exporter
+// holds back neither X-Ray service nor other sinks.
+type sink struct {
+	name string
+
+	exporter exporter.Exporter
+
+	// Batches waiting to be exported.
+	batches chan []string
+
+	// Boolean channel set to true by every go routine once the batches channel is drained.
+	done chan bool
+
+	// Number of go routines sending batches.
+	concurrency int
+
+	// Bounds of the retries of a failed batch.
+	retry retryConfig
+
+	// Budget limiting retries, nil for no limit.
+	budget *retryBudget
+
+	// Circuit breaker around exports, nil if disabled.
+	breaker *breaker.Breaker
+
+	// Boolean, set to true for destinations segments are routed to instead of X-Ray service.
+	routed bool
+
+	// Number of segments dropped or failed to be exported.
+	lost int64
+
+	// Random generator, used for back off between retries.
+	randGen *rand.Rand
+
+	// Lock guarding randGen, shared by the poll go routines.
+	randLock sync.Mutex
+
+	// Instance of timer.
+	timer timer.Timer
+}
+
+// newSink returns a sink for c, retrying failed batches with the delays of retry, within budget if c shares it,
+// and starts its go routines, which drop the batches left once ctx is cancelled.
+func newSink(ctx context.Context, c SinkConfig, retry retryConfig, budget *retryBudget, t timer.Timer) *sink {
+	if c.Concurrency < 1 {
+		c.Concurrency = 1
+	}
+	retry.maxRetries = c.MaxRetries
+	k := &sink{
+		name:        c.Name,
+		exporter:    c.Exporter,
+		batches:     make(chan []string, c.QueueSize),
+		done:        make(chan bool),
+		concurrency: c.Concurrency,
+		retry:       retry,
+		breaker:     c.Breaker,
+		routed:      c.Routed,
+		randGen:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		timer:       t,
+	}
+	if c.ShareRetryBudget {
+		k.budget = budget
+	}
+	for i := 0; i < k.concurrency; i++ {
+		go k.poll(ctx)
+	}
+	return k
+}
+
+// send queues batch, dropping the oldest queued batch if the queue is full.
+func (k *sink) send(batch []string) {
+	for {
+		select {
+		case k.batches <- batch:
+			return
+		default:
+		}
+		select {
+		case dropped := <-k.batches:
+			log.Warnf("Queue of exporter %v is full. Dropping oldest %v segments", k.name, len(dropped))
+			k.drop(deadletter.ReasonBatchQueueFull, dropped)
+		default:
+		}
+	}
+}
+
+func (k *sink) poll(ctx context.Context) {
+	for batch := range k.batches {
+		if ctx.Err() != nil {
+			log.Warnf("Exports are cancelled. Dropping batch of %d segments to %v", len(batch), k.name)
+			k.drop(deadletter.ReasonShutdown, batch)
+			continue
+		}
+		if k.breaker != nil && !k.breaker.Allow() {
+			log.Warnf("Circuit breaker of exporter %v is open. Dropping batch of %d segments", k.name, len(batch))
+			telemetry.T.Count(sinkCounterName+k.name+".breaker-open", int64(len(batch)))
+			k.drop(deadletter.ReasonBreakerOpen, batch)
+			continue
+		}
+		unprocessed, err := k.export(ctx, batch)
+		if err != nil {
+			log.Errorf("Exporting segment batch to %v failed with: %v", k.name, err)
+			telemetry.T.Count(sinkCounterName+k.name+".failed", int64(len(batch)))
+			atomic.AddInt64(&k.lost, int64(len(batch)))
+			if k.routed {
+				deadletter.D.RecordBatch(deadletter.ReasonExportFailed, "", batch)
+				telemetry.T.SegmentSpillover(int64(len(batch)))
+			}
+			continue
+		}
+		if len(unprocessed) != 0 {
+			log.Warnf("Exported batch of %d segments to %v but had %d unprocessed segments", len(batch), k.name, len(unprocessed))
+			telemetry.T.Count(sinkCounterName+k.name+".rejected", int64(len(unprocessed)))
+		}
+		telemetry.T.Count(sinkCounterName+k.name+".sent", int64(len(batch)-len(unprocessed)))
+		if k.routed {
+			telemetry.T.SegmentSent(int64(len(batch) - len(unprocessed)))
+			telemetry.T.SegmentRejected(int64(len(unprocessed)))
+		}
+	}
+	log.Tracef("Exporter %v: done!", k.name)
+	k.done <- true
+}
+
+// export sends batch to the exporter, retrying errors with exponential backoff and full jitter while
+// the retry budget and circuit breaker allow, except responses the destination rejected the batch with.
+func (k *sink) export(ctx context.Context, batch []string) ([]types.UnprocessedTraceSegment, error) {
+	for attempt := 0; ; attempt++ {
+		unprocessed, err := k.exporter.Export(ctx, batch)
+		k.report(err)
+		if err == nil {
+			if k.budget != nil {
+				k.budget.deposit()
+			}
+			return unprocessed, nil
+		}
+		if attempt >= k.retry.maxRetries || isRejected(err) {
+			return unprocessed, err
+		}
+		if k.breaker != nil && !k.breaker.Allow() {
+			return unprocessed, err
+		}
+		if k.budget != nil && !k.budget.withdraw() {
+			telemetry.T.Count(sinkCounterName+k.name+".retry-budget-exhausted", 1)
+			return unprocessed, err
+		}
+		telemetry.T.Count(sinkCounterName+k.name+".retry", 1)
+		k.randLock.Lock()
+		delay := k.retry.backoff(attempt, k.randGen)
+		k.randLock.Unlock()
+		log.Warnf("Exporting segment batch to %v failed with: %v. Retrying in %v", k.name, err, delay)
+		select {
+		case <-k.timer.After(delay):
+		case <-ctx.Done():
+			return unprocessed, ctx.Err()
+		}
+	}
+}
+
+// drop counts the segments of batch dropped for reason, and dead-letters them if the sink is a routed destination.
+func (k *sink) drop(reason string, batch []string) {
+	telemetry.T.Count(sinkCounterName+k.name+".dropped", int64(len(batch)))
+	atomic.AddInt64(&k.lost, int64(len(batch)))
+	if k.routed {
+		deadletter.D.RecordBatch(reason, "", batch)
+		telemetry.T.SegmentSpillover(int64(len(batch)))
+	}
+}
+
+// report records the outcome of an export in the circuit breaker, classified as those of X-Ray uploads.
+func (k *sink) report(err error) {
+	if k.breaker == nil {
+		return
+	}
+	if err != nil && !errors.Is(err, context.Canceled) && !isRejected(err) {
+		k.breaker.Failure()
+	} else {
+		k.breaker.Success()
+	}
+}
+
+// close waits for the queued batches to be exported, and closes the exporter.
+func (k *sink) close() {
+	close(k.batches)
+	for i := 0; i < k.concurrency; i++ {
+		<-k.done
+	}
+	if err := k.exporter.Close(); err != nil {
+		log.Errorf("Unable to close exporter %v: %v", k.name, err)
+	}
+}
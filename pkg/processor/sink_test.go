@@ -0,0 +1,286 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/xray/types"
+	"github.com/aws/aws-xray-daemon/pkg/breaker"
+	"github.com/aws/aws-xray-daemon/pkg/bufferpool"
+	"github.com/aws/aws-xray-daemon/pkg/deadletter"
+	"github.com/aws/aws-xray-daemon/pkg/exporter"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	"github.com/aws/aws-xray-daemon/pkg/util/test"
+	"github.com/aws/aws-xray-daemon/pkg/util/timer"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockExporter struct {
+	lock     sync.Mutex
+	exported [][]string
+	// Errors returned by the next calls to Export.
+	errs []error
+	// Channel Export waits on before returning, nil to return right away.
+	release chan struct{}
+	closed  bool
+}
+
+func (e *mockExporter) Export(ctx context.Context, docs []string) ([]types.UnprocessedTraceSegment, error) {
+	if e.release != nil {
+		<-e.release
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.exported = append(e.exported, docs)
+	if len(e.errs) > 0 {
+		err := e.errs[0]
+		e.errs = e.errs[1:]
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (e *mockExporter) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.closed = true
+	return nil
+}
+
+func (e *mockExporter) calls() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return len(e.exported)
+}
+
+func getTestSink(e *mockExporter, queueSize int, maxRetries int) *sink {
+	test.LogSetup()
+	return newSink(context.Background(), SinkConfig{Name: "test", Exporter: e, QueueSize: queueSize, MaxRetries: maxRetries},
+		retryConfig{baseDelay: time.Millisecond, maxDelay: time.Millisecond}, nil, &timer.Client{})
+}
+
+func TestSinkExportsAndCloses(t *testing.T) {
+	e := &mockExporter{}
+	k := getTestSink(e, 2, 0)
+
+	k.send([]string{"{}"})
+	k.send([]string{"{}", "{}"})
+	k.close()
+
+	assert.EqualValues(t, [][]string{{"{}"}, {"{}", "{}"}}, e.exported)
+	assert.True(t, e.closed)
+}
+
+func TestSinkRetries(t *testing.T) {
+	e := &mockExporter{errs: []error{errors.New("connection refused"), errors.New("connection refused")}}
+	k := getTestSink(e, 1, 1)
+
+	k.send([]string{"{}"})
+	k.close()
+
+	assert.EqualValues(t, 2, e.calls(), "The batch is sent once and retried once")
+}
+
+func TestSinkDoesNotRetryRejectedBatch(t *testing.T) {
+	e := &mockExporter{errs: []error{&exporter.StatusError{Exporter: "webhook", StatusCode: 400, Status: "400 Bad Request"}}}
+	k := getTestSink(e, 1, 3)
+
+	k.send([]string{"{}"})
+	k.close()
+
+	assert.EqualValues(t, 1, e.calls(), "A rejected batch is not retried")
+}
+
+func TestSinkDropsOldestWhenFull(t *testing.T) {
+	e := &mockExporter{release: make(chan struct{})}
+	k := getTestSink(e, 1, 0)
+
+	k.send([]string{"first"})
+	// Wait for the poll go routine to take the first batch.
+	for len(k.batches) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	k.send([]string{"second"})
+	k.send([]string{"third"})
+	close(e.release)
+	k.close()
+
+	assert.EqualValues(t, [][]string{{"first"}, {"third"}}, e.exported)
+	assert.EqualValues(t, 1, (&Processor{sinks: []*sink{k}}).ExporterLostCount())
+}
+
+func TestSendBatchFansOutToSinks(t *testing.T) {
+	slow := &mockExporter{release: make(chan struct{})}
+	fast := &mockExporter{}
+	pool := bufferpool.Init(2, 100)
+	processor := Processor{
+		pool:        pool,
+		timerClient: &test.MockTimerClient{},
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 3),
+		},
+		sinks: []*sink{getTestSink(slow, 1, 0), getTestSink(fast, 3, 0)},
+	}
+	for i := 0; i < 3; i++ {
+		segment := tracesegment.GetTestTraceSegment()
+		processor.sendBatchAsync([]*tracesegment.TraceSegment{&segment})
+	}
+
+	assert.EqualValues(t, 3, len(processor.traceSegmentsBatch.batches), "A slow sink does not hold back X-Ray")
+	close(slow.release)
+	for _, k := range processor.sinks {
+		k.close()
+	}
+	assert.EqualValues(t, 3, fast.calls())
+	assert.True(t, slow.calls() >= 1)
+}
+
+func TestSendBatchRoutesSegments(t *testing.T) {
+	routed := &mockExporter{}
+	pool := bufferpool.Init(3, 100)
+	processor := Processor{
+		pool:        pool,
+		timerClient: &test.MockTimerClient{},
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 1),
+		},
+		routes: map[string]*sink{"tenant": getTestSink(routed, 1, 0)},
+	}
+	first := tracesegment.GetTestTraceSegment()
+	second := tracesegment.GetTestTraceSegment()
+	second.Route = "tenant"
+	unknown := tracesegment.GetTestTraceSegment()
+	unknown.Route = "unknown"
+	processor.sendBatchAsync([]*tracesegment.TraceSegment{&first, &second, &unknown})
+	processor.routes["tenant"].close()
+
+	assert.EqualValues(t, []string{string(*first.Raw), string(*unknown.Raw)}, <-processor.traceSegmentsBatch.batches,
+		"Segments without known route are sent to the default destination")
+	assert.EqualValues(t, [][]string{{string(*second.Raw)}}, routed.exported)
+}
+
+func TestSendBatchRoutesWholeBatch(t *testing.T) {
+	routed := &mockExporter{}
+	pool := bufferpool.Init(1, 100)
+	processor := Processor{
+		pool:        pool,
+		timerClient: &test.MockTimerClient{},
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 1),
+		},
+		routes: map[string]*sink{"tenant": getTestSink(routed, 1, 0)},
+	}
+	segment := tracesegment.GetTestTraceSegment()
+	segment.Route = "tenant"
+	processor.sendBatchAsync([]*tracesegment.TraceSegment{&segment})
+	processor.routes["tenant"].close()
+
+	assert.EqualValues(t, 0, len(processor.traceSegmentsBatch.batches), "No empty batch is sent to the default destination")
+	assert.EqualValues(t, 1, routed.calls())
+}
+
+func TestSendBatchWrapsUnroutedSegments(t *testing.T) {
+	routed := &mockExporter{}
+	sinkExporter := &mockExporter{}
+	processor := Processor{
+		pool:        bufferpool.Init(2, 100),
+		timerClient: &test.MockTimerClient{},
+		traceSegmentsBatch: &segmentsBatch{
+			batches: make(chan []string, 1),
+		},
+		routes: map[string]*sink{"tenant": getTestSink(routed, 1, 0)},
+		sinks:  []*sink{getTestSink(sinkExporter, 1, 0)},
+		wrap: func(ts *tracesegment.TraceSegment) string {
+			return "wrapped " + string(*ts.Raw)
+		},
+	}
+	first := tracesegment.GetTestTraceSegment()
+	second := tracesegment.GetTestTraceSegment()
+	second.Route = "tenant"
+	processor.sendBatchAsync([]*tracesegment.TraceSegment{&first, &second})
+	processor.routes["tenant"].close()
+	processor.sinks[0].close()
+
+	assert.EqualValues(t, []string{"wrapped " + string(*first.Raw)}, <-processor.traceSegmentsBatch.batches)
+	assert.EqualValues(t, [][]string{{string(*second.Raw)}}, routed.exported)
+	assert.EqualValues(t, [][]string{{string(*first.Raw)}}, sinkExporter.exported, "Sinks only receive segments of the default destination")
+}
+
+func TestSinkRetriesWithinBudget(t *testing.T) {
+	e := &mockExporter{errs: []error{errors.New("connection refused"), errors.New("connection refused")}}
+	test.LogSetup()
+	budget := newRetryBudget(0)
+	budget.tokens = 1
+	k := newSink(context.Background(), SinkConfig{Name: "test", Exporter: e, QueueSize: 1, MaxRetries: 3, ShareRetryBudget: true},
+		retryConfig{baseDelay: time.Millisecond, maxDelay: time.Millisecond}, budget, &timer.Client{})
+
+	k.send([]string{"{}"})
+	k.close()
+
+	assert.EqualValues(t, 2, e.calls(), "A single retry is left in the budget")
+}
+
+func TestSinkDropsBatchWhileBreakerIsOpen(t *testing.T) {
+	e := &mockExporter{errs: []error{&exporter.StatusError{Exporter: "webhook", StatusCode: 503, Status: "503 Service Unavailable"}}}
+	test.LogSetup()
+	b := breaker.New("export.test", breaker.Config{ConsecutiveFailures: 1, OpenTimeout: time.Hour})
+	k := newSink(context.Background(), SinkConfig{Name: "test", Exporter: e, QueueSize: 2, MaxRetries: 3, Breaker: b},
+		retryConfig{baseDelay: time.Millisecond, maxDelay: time.Millisecond}, nil, &timer.Client{})
+
+	k.send([]string{"first"})
+	k.send([]string{"second"})
+	k.close()
+
+	assert.EqualValues(t, [][]string{{"first"}}, e.exported, "The failure opens the breaker, which stops retries and drops the next batch")
+	assert.EqualValues(t, breaker.Open, b.State())
+}
+
+func TestRoutedSinkDeadLettersDroppedSegments(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	defer setupTestDeadLetter(t)()
+	e := &mockExporter{errs: []error{errors.New("connection refused")}, release: make(chan struct{})}
+	test.LogSetup()
+	k := newSink(context.Background(), SinkConfig{Name: "tenant", Exporter: e, QueueSize: 1, Routed: true},
+		retryConfig{baseDelay: time.Millisecond, maxDelay: time.Millisecond}, nil, &timer.Client{})
+
+	k.send([]string{"first"})
+	// Wait for the poll go routine to take the first batch.
+	for len(k.batches) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	k.send([]string{"second"})
+	k.send([]string{"third"})
+	close(e.release)
+	k.close()
+
+	assert.EqualValues(t, 1, telemetry.T.Counter("export.tenant.dropped"))
+	assert.EqualValues(t, 1, telemetry.T.Counter("export.tenant.failed"))
+	assert.EqualValues(t, 2, telemetry.T.Totals().Spillover, "The dropped and the failed batch are lost")
+	assert.EqualValues(t, 1, telemetry.T.Totals().Sent)
+	assert.Equal(t, 2, len(deadletter.D.Recent(10)))
+}
+
+func TestSinkBreakerOpensOnRefusedConnection(t *testing.T) {
+	test.LogSetup()
+	b := breaker.New("export.test", breaker.Config{ConsecutiveFailures: 1, OpenTimeout: time.Hour})
+	k := newSink(context.Background(), SinkConfig{Name: "test", Exporter: exporter.NewXRay(getRefusedXRay()), QueueSize: 1, Breaker: b},
+		retryConfig{baseDelay: time.Millisecond, maxDelay: time.Millisecond}, nil, &timer.Client{})
+
+	k.send([]string{"{}"})
+	k.close()
+
+	assert.EqualValues(t, breaker.Open, b.State())
+}
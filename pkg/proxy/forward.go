@@ -0,0 +1,71 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-xray-daemon/pkg/cfg"
+	"github.com/aws/aws-xray-daemon/pkg/gateway"
+	log "github.com/cihub/seelog"
+)
+
+// NewForwardingServer returns a proxy server listening on the TCP address of cfg, which forwards
+// requests unsigned to the gateway daemons, authorized with token, for them to sign and proxy to X-Ray.
+// A gateway failing to answer a request hands over to the next one for the following requests.
+func NewForwardingServer(cfg *cfg.Config, gateways []string, token string) (*Server, error) {
+	if _, err := net.ResolveTCPAddr("tcp", cfg.Socket.TCPAddress); err != nil {
+		return nil, err
+	}
+	urls := make([]*url.URL, 0, len(gateways))
+	for _, g := range gateways {
+		u, err := url.Parse(strings.TrimSuffix(g, "/") + gateway.ProxyPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse gateway url: %v", err)
+		}
+		urls = append(urls, u)
+	}
+	log.Infof("HTTP Proxy server forwarding requests to gateways %v", gateways)
+
+	// Index of the gateway requests are forwarded to.
+	var active uint32
+	handler := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			u := urls[atomic.LoadUint32(&active)]
+			log.Debugf("Received request on HTTP Proxy server : %s", req.URL.String())
+			req.Header.Del(connHeader)
+			req.URL.Scheme = u.Scheme
+			req.URL.Host = u.Host
+			req.URL.Path = u.Path + req.URL.Path
+			req.Host = u.Host
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			i := atomic.LoadUint32(&active)
+			log.Errorf("Unable to forward request to gateway %v: %v", urls[i].Host, err)
+			if req.Context().Err() == nil && urls[i].Host == req.URL.Host {
+				atomic.CompareAndSwapUint32(&active, i, (i+1)%uint32(len(urls)))
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return &Server{&http.Server{
+		Addr:    cfg.Socket.TCPAddress,
+		Handler: handler,
+	}}, nil
+}
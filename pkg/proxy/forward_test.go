@@ -0,0 +1,60 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-xray-daemon/pkg/cfg"
+	"github.com/aws/aws-xray-daemon/pkg/gateway"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardingServerProxiesToGateway(t *testing.T) {
+	var path, auth string
+	g := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte(`{"SamplingRuleRecords":[]}`))
+	}))
+	defer g.Close()
+	s, err := NewForwardingServer(cfg.DefaultConfig(), []string{g.URL + "/"}, "secret")
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/GetSamplingRules", strings.NewReader("{}")))
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, `{"SamplingRuleRecords":[]}`, w.Body.String())
+	assert.EqualValues(t, gateway.ProxyPath+"/GetSamplingRules", path)
+	assert.EqualValues(t, "Bearer secret", auth)
+}
+
+func TestForwardingServerHandsOverToNextGateway(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer up.Close()
+	s, err := NewForwardingServer(cfg.DefaultConfig(), []string{down.URL, up.URL}, "")
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/SamplingTargets", strings.NewReader("{}")))
+	assert.EqualValues(t, http.StatusBadGateway, w.Code)
+
+	w = httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/SamplingTargets", strings.NewReader("{}")))
+	assert.EqualValues(t, http.StatusOK, w.Code, "The following request is forwarded to the next gateway")
+}
@@ -37,23 +37,45 @@ type Server struct {
 // Requests are forwarded to the endpoint in the given config.
 // Requests are signed using credentials from the given config.
 func NewServer(cfg *cfg.Config, awsCfg aws.Config) (*Server, error) {
+	return NewServerWithFailover(cfg, awsCfg, nil)
+}
+
+// NewServerWithFailover returns a proxy server like NewServer, forwarding requests to the active
+// endpoint of failover f instead if not nil, signed using credentials from the config of that endpoint.
+// Outcomes of the forwarded requests are reported to f.
+func NewServerWithFailover(cfg *cfg.Config, awsCfg aws.Config, f *conn.Failover) (*Server, error) {
 	_, err := net.ResolveTCPAddr("tcp", cfg.Socket.TCPAddress)
 	if err != nil {
 		log.Errorf("%v", err)
 		os.Exit(1)
 	}
-	endPoint, er := getServiceEndpoint(&awsCfg)
-
-	if er != nil {
-		return nil, fmt.Errorf("%v", er)
+	configs := []aws.Config{awsCfg}
+	if f != nil {
+		configs = configs[:0]
+		for i := 0; i < f.Len(); i++ {
+			configs = append(configs, f.Config(i))
+		}
 	}
+	urls := make([]*url.URL, 0, len(configs))
+	for i := range configs {
+		endPoint, er := getServiceEndpoint(&configs[i])
 
-	log.Infof("HTTP Proxy server using X-Ray Endpoint : %v", endPoint)
+		if er != nil {
+			return nil, fmt.Errorf("%v", er)
+		}
 
-	// Parse url from endpoint
-	url, err := url.Parse(endPoint)
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse xray endpoint: %v", err)
+		if i == 0 {
+			log.Infof("HTTP Proxy server using X-Ray Endpoint : %v", endPoint)
+		} else {
+			log.Infof("HTTP Proxy server failing over to X-Ray Endpoint : %v", endPoint)
+		}
+
+		// Parse url from endpoint
+		url, err := url.Parse(endPoint)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse xray endpoint: %v", err)
+		}
+		urls = append(urls, url)
 	}
 
 	signer := v4.NewSigner()
@@ -77,6 +99,14 @@ func NewServer(cfg *cfg.Config, awsCfg aws.Config) (*Server, error) {
 			// resulting in a signed header being missing from the request.
 			req.Header.Del(connHeader)
 
+			// Forward to the active endpoint
+			active := 0
+			if f != nil {
+				active = f.Active()
+			}
+			url := urls[active]
+			awsCfg := configs[active]
+
 			// Set req url to xray endpoint
 			req.URL.Scheme = url.Scheme
 			req.URL.Host = url.Host
@@ -124,6 +154,22 @@ func NewServer(cfg *cfg.Config, awsCfg aws.Config) (*Server, error) {
 		},
 	}
 
+	if f != nil {
+		handler.ModifyResponse = func(resp *http.Response) error {
+			if i := endpointIndex(urls, resp.Request.URL.Host); i >= 0 {
+				f.Report(i, resp.StatusCode >= 500)
+			}
+			return nil
+		}
+		handler.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Errorf("Unable to forward request to X-Ray: %v", err)
+			if i := endpointIndex(urls, req.URL.Host); i >= 0 {
+				f.Report(i, !errors.Is(err, context.Canceled))
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}
+
 	server := &http.Server{
 		Addr:    cfg.Socket.TCPAddress,
 		Handler: handler,
@@ -153,6 +199,16 @@ func consume(body io.ReadCloser) (io.ReadSeeker, error) {
 	return bytes.NewReader(buf), nil
 }
 
+// endpointIndex returns the index of the endpoint in urls with the given host, -1 if none.
+func endpointIndex(urls []*url.URL, host string) int {
+	for i, u := range urls {
+		if u.Host == host {
+			return i
+		}
+	}
+	return -1
+}
+
 // Serve starts server.
 func (s *Server) Serve() {
 	log.Infof("Starting proxy http server on %s", s.Addr)
@@ -169,6 +225,14 @@ func (s *Server) Close() {
 	}
 }
 
+// Drain stops accepting requests and waits for those in flight until ctx is done, then closes their connections.
+func (s *Server) Drain(ctx context.Context) {
+	if err := s.Server.Shutdown(ctx); err != nil {
+		log.Warnf("Closing proxy http server with requests in flight: %v", err)
+		s.Close()
+	}
+}
+
 // getServiceEndpoint returns X-Ray service endpoint.
 // It is guaranteed that awsCfg config instance is non-nil and the region value is non empty in awsCfg object.
 // Currently the caller takes care of it.
@@ -9,9 +9,11 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-xray-daemon/pkg/cfg"
+	"github.com/aws/aws-xray-daemon/pkg/conn"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -98,6 +100,36 @@ func TestDirector(t *testing.T) {
 	assert.NotContains(t, req.Header, "Connection")
 }
 
+// Directing requests to the active endpoint of the failover
+func TestDirectorFailover(t *testing.T) {
+	creds := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "id", SecretAccessKey: "secret"}, nil
+	})
+	f := conn.NewFailover([]aws.Config{
+		{Region: "us-east-1", Credentials: creds},
+		{Region: "us-west-2", Credentials: creds},
+	}, conn.FailoverConfig{ProbeInterval: time.Minute})
+	s, err := NewServerWithFailover(cfg.DefaultConfig(), aws.Config{}, f)
+	assert.Nil(t, err)
+	d := s.Handler.(*httputil.ReverseProxy).Director
+	newRequest := func() *http.Request {
+		url, _ := url.Parse("http://127.0.0.1:2000")
+		return &http.Request{URL: url, Host: "127.0.0.1", Header: map[string][]string{}, Body: ioutil.NopCloser(strings.NewReader("Body"))}
+	}
+
+	req := newRequest()
+	d(req)
+	assert.Equal(t, "xray.us-east-1.amazonaws.com", req.URL.Host)
+
+	// Fail the primary twice, failing over at once.
+	f.Report(0, true)
+	f.Report(0, true)
+	req = newRequest()
+	d(req)
+	assert.Equal(t, "xray.us-west-2.amazonaws.com", req.URL.Host)
+	assert.Contains(t, req.Header.Get("Authorization"), "/us-west-2/xray/")
+}
+
 // Fetching endpoint from aws config instance
 func TestEndpoint1(t *testing.T) {
 	e := "https://xray.us-east-1.amazonaws.com"
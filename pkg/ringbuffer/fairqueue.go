@@ -0,0 +1,399 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package ringbuffer
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/bufferpool"
+	"github.com/aws/aws-xray-daemon/pkg/deadletter"
+	"github.com/aws/aws-xray-daemon/pkg/overflow"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	log "github.com/cihub/seelog"
+)
+
+// Name of the fair queue used in telemetry counters.
+const fairCounterName = "fairqueue"
+
+// FairQueue stores trace segments in one queue per service, drained by weighted round-robin.
+// When full, the overflow policy applies to the service furthest above its guaranteed share,
+// so a single chatty service cannot evict the segments of every other service.
+type FairQueue struct {
+	// Channel signalled when segments are available or the queue is closed.
+	Ready <-chan struct{}
+	ready chan struct{}
+
+	// Channel signalled when a segment leaves the queue, for the block policy to wait on.
+	room chan struct{}
+
+	// Boolean, set to true once the queue is closed and drained.
+	Empty bool
+
+	lock sync.Mutex
+
+	// Queues keyed by service.
+	queues map[string][]*tracesegment.TraceSegment
+
+	// Round-robin order of services with queued segments.
+	order []string
+
+	// Index in order of the service being drained.
+	next int
+
+	// Segments the service being drained can still send in its turn.
+	credit int
+
+	// Total number of queued segments.
+	size int
+
+	// Maximum number of queued segments.
+	capacity int
+
+	// Percentage of capacity guaranteed to every service with queued segments.
+	minSharePercent int
+
+	// Number of segments a service sends in its turn, services not listed send one.
+	weights map[string]int
+
+	closed bool
+
+	// Counter for trace segments truncated.
+	count uint64
+
+	// Reference to BufferPool.
+	pool *bufferpool.BufferPool
+
+	// Overflow policy applied when the queue is full.
+	overflow overflow.Config
+}
+
+// NewFairQueue returns new instance of FairQueue configured with BufferPool pool.
+func NewFairQueue(bufferCount int, pool *bufferpool.BufferPool, weights map[string]int, minSharePercent int) *FairQueue {
+	return NewFairQueueWithOverflow(bufferCount, pool, weights, minSharePercent, overflow.Config{Policy: overflow.DropOldest})
+}
+
+// NewFairQueueWithOverflow returns new instance of FairQueue configured with BufferPool pool
+// and overflow policy o applied when the queue is full.
+func NewFairQueueWithOverflow(bufferCount int, pool *bufferpool.BufferPool, weights map[string]int, minSharePercent int,
+	o overflow.Config) *FairQueue {
+	if bufferCount == 0 {
+		log.Error("The initial size of a queue should be larger than 0")
+		os.Exit(1)
+	}
+	ready := make(chan struct{}, 1)
+	return &FairQueue{
+		Ready:           ready,
+		ready:           ready,
+		room:            make(chan struct{}, 1),
+		queues:          make(map[string][]*tracesegment.TraceSegment),
+		capacity:        getChannelSize(bufferCount),
+		minSharePercent: minSharePercent,
+		weights:         weights,
+		pool:            pool,
+		overflow:        o,
+	}
+}
+
+// Send adds trace segment s to the queue of service key. When the queue is full, the overflow policy applies
+// to the service furthest above its guaranteed share: drop-oldest drops its oldest segment, drop-newest its
+// newest one, spill writes that segment to secondary storage, and block waits for room up to the block timeout
+// before dropping it. The newest segment is s if no service is above its share.
+func (q *FairQueue) Send(key string, s *tracesegment.TraceSegment) {
+	if q.overflow.Policy == overflow.Block {
+		q.waitForRoom()
+	}
+	var removed *tracesegment.TraceSegment
+	q.lock.Lock()
+	if q.size >= q.capacity {
+		if q.overflow.Policy != overflow.DropOldest {
+			removed = q.removeNewest(key, s)
+		} else if !q.evict(key, true) && !q.evictFrom(key) {
+			q.evictFrom(q.largest())
+		}
+	}
+	if removed != s {
+		if _, ok := q.queues[key]; !ok {
+			q.order = append(q.order, key)
+		}
+		q.queues[key] = append(q.queues[key], s)
+		q.size++
+	}
+	q.lock.Unlock()
+	if removed != nil {
+		q.overflowNewest(removed)
+	}
+	if removed != s {
+		q.signal()
+	}
+}
+
+// Reclaim drops the oldest segment of the service furthest above its guaranteed share,
+// returning its buffer to the pool. Returns false if no service is above its share.
+func (q *FairQueue) Reclaim() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.evict("", false)
+}
+
+// Next returns the next trace segment in weighted round-robin order, false if the queue is empty.
+func (q *FairQueue) Next() (*tracesegment.TraceSegment, bool) {
+	q.lock.Lock()
+	if q.size == 0 {
+		q.lock.Unlock()
+		return nil, false
+	}
+	q.next = q.next % len(q.order)
+	key := q.order[q.next]
+	if q.credit <= 0 {
+		q.credit = q.weight(key)
+	}
+	segments := q.queues[key]
+	s := segments[0]
+	segments[0] = nil
+	q.queues[key] = segments[1:]
+	q.size--
+	q.credit--
+	if len(q.queues[key]) == 0 {
+		q.remove(q.next)
+		q.credit = 0
+	} else if q.credit == 0 {
+		q.next++
+	}
+	// Signal again while segments remain, or so a closed queue is seen as drained.
+	more := q.size > 0 || q.closed
+	q.lock.Unlock()
+	if more {
+		q.signal()
+	}
+	select {
+	case q.room <- struct{}{}:
+	default:
+	}
+	return s, true
+}
+
+// Closed returns true if the queue is closed.
+func (q *FairQueue) Closed() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.closed
+}
+
+// Close closes the FairQueue.
+func (q *FairQueue) Close() {
+	q.lock.Lock()
+	q.closed = true
+	q.lock.Unlock()
+	q.signal()
+}
+
+// TruncatedCount returns trace segment truncated count.
+func (q *FairQueue) TruncatedCount() uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.count
+}
+
+func (q *FairQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *FairQueue) weight(key string) int {
+	if w, ok := q.weights[key]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// guaranteedShare returns the number of segments every service with queued segments may keep.
+// The share never exceeds an equal split of the capacity.
+func (q *FairQueue) guaranteedShare(services int) int {
+	share := q.capacity * q.minSharePercent / 100
+	if services > 0 && share > q.capacity/services {
+		share = q.capacity / services
+	}
+	return share
+}
+
+// evict drops the oldest segment of the largest queue above its guaranteed share.
+// If growing is true, the queue of service key counts as holding one more segment,
+// as it is about to receive one. Must be called with the lock held.
+func (q *FairQueue) evict(key string, growing bool) bool {
+	victim, ok := q.overShare(key, growing)
+	if !ok {
+		return false
+	}
+	return q.evictFrom(victim)
+}
+
+// overShare returns the service with the largest queue above its guaranteed share, false if none.
+// If growing is true, the queue of service key counts as holding one more segment. Must be called with the lock held.
+func (q *FairQueue) overShare(key string, growing bool) (string, bool) {
+	services := len(q.order)
+	if _, ok := q.queues[key]; growing && !ok {
+		services++
+	}
+	victim := ""
+	largest := q.guaranteedShare(services)
+	found := false
+	for _, k := range q.order {
+		n := len(q.queues[k])
+		if growing && k == key {
+			n++
+		}
+		if n > largest {
+			victim = k
+			largest = n
+			found = true
+		}
+	}
+	return victim, found
+}
+
+// largest returns the service with the most queued segments. Must be called with the lock held.
+func (q *FairQueue) largest() string {
+	victim := ""
+	largest := 0
+	for _, k := range q.order {
+		if n := len(q.queues[k]); n > largest {
+			victim = k
+			largest = n
+		}
+	}
+	return victim
+}
+
+// evictFrom drops the oldest segment queued for service key. Must be called with the lock held.
+func (q *FairQueue) evictFrom(key string) bool {
+	segments := q.queues[key]
+	if len(segments) == 0 {
+		return false
+	}
+	deadletter.D.Record(deadletter.ReasonBufferFull, "", *segments[0].Raw)
+	q.pool.Return(segments[0].PoolBuf)
+	segments[0] = nil
+	q.queues[key] = segments[1:]
+	q.size--
+	q.count++
+	q.removeIfEmpty(key)
+	log.Warnf("Segment queue of service %q is over its share. Dropping oldest segment document.", key)
+	telemetry.T.SegmentSpillover(1)
+	telemetry.T.Count(fairCounterName+".overflow.drop-oldest", 1)
+	return true
+}
+
+// removeNewest removes the newest segment of the service furthest above its guaranteed share, and returns it.
+// That is s, about to be sent to service key, if service key is the one or no service is above its share.
+// Must be called with the lock held.
+func (q *FairQueue) removeNewest(key string, s *tracesegment.TraceSegment) *tracesegment.TraceSegment {
+	victim, ok := q.overShare(key, true)
+	if !ok || victim == key {
+		return s
+	}
+	segments := q.queues[victim]
+	newest := segments[len(segments)-1]
+	segments[len(segments)-1] = nil
+	q.queues[victim] = segments[:len(segments)-1]
+	q.size--
+	q.removeIfEmpty(victim)
+	return newest
+}
+
+// overflowNewest spills or drops segment s, removed from a full queue, as the overflow policy says.
+func (q *FairQueue) overflowNewest(s *tracesegment.TraceSegment) {
+	switch q.overflow.Policy {
+	case overflow.Spill:
+		if err := q.overflow.Spiller.Spill([]string{string(*s.Raw)}); err != nil {
+			log.Errorf("Unable to spill segment document: %v", err)
+			q.drop(s, overflow.Spill.Counter(fairCounterName)+"-failed")
+			return
+		}
+		q.pool.Return(s.PoolBuf)
+		log.Debug("Segment queue is full. Spilled newest segment document.")
+		telemetry.T.Count(overflow.Spill.Counter(fairCounterName), 1)
+	case overflow.Block:
+		q.drop(s, overflow.Block.Counter(fairCounterName)+"-timeout")
+	default:
+		q.drop(s, overflow.DropNewest.Counter(fairCounterName))
+	}
+}
+
+// drop drops segment s and increments telemetry counter.
+func (q *FairQueue) drop(s *tracesegment.TraceSegment, counter string) {
+	q.lock.Lock()
+	q.count++
+	q.lock.Unlock()
+	deadletter.D.Record(deadletter.ReasonBufferFull, "", *s.Raw)
+	q.pool.Return(s.PoolBuf)
+	log.Warn("Segment queue is full. Dropping newest segment document.")
+	telemetry.T.SegmentSpillover(1)
+	telemetry.T.Count(counter, 1)
+}
+
+// waitForRoom waits until the queue has room, at most the block timeout.
+func (q *FairQueue) waitForRoom() {
+	if q.hasRoom() {
+		return
+	}
+	telemetry.T.Count(overflow.Block.Counter(fairCounterName), 1)
+	timer := time.NewTimer(q.overflow.BlockTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-q.room:
+		case <-timer.C:
+			return
+		}
+		if q.hasRoom() {
+			return
+		}
+	}
+}
+
+func (q *FairQueue) hasRoom() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.size < q.capacity || q.closed
+}
+
+// removeIfEmpty removes service key from the round-robin order once its queue is empty. Must be called with the lock held.
+func (q *FairQueue) removeIfEmpty(key string) {
+	if len(q.queues[key]) != 0 {
+		return
+	}
+	for i, k := range q.order {
+		if k == key {
+			q.remove(i)
+			return
+		}
+	}
+}
+
+// remove removes the service at index i of the round-robin order. Must be called with the lock held.
+func (q *FairQueue) remove(i int) {
+	delete(q.queues, q.order[i])
+	q.order = append(q.order[:i], q.order[i+1:]...)
+	if i < q.next {
+		q.next--
+	} else if i == q.next {
+		q.credit = 0
+	}
+	if len(q.order) > 0 {
+		q.next = q.next % len(q.order)
+	} else {
+		q.next = 0
+	}
+}
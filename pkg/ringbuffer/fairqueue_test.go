@@ -0,0 +1,205 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package ringbuffer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-daemon/pkg/bufferpool"
+	"github.com/aws/aws-xray-daemon/pkg/overflow"
+	"github.com/aws/aws-xray-daemon/pkg/telemetry"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	"github.com/aws/aws-xray-daemon/pkg/util/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func sendTestSegments(q *FairQueue, key string, n int) []*tracesegment.TraceSegment {
+	var segments []*tracesegment.TraceSegment
+	for i := 0; i < n; i++ {
+		s := tracesegment.GetTestTraceSegment()
+		q.Send(key, &s)
+		segments = append(segments, &s)
+	}
+	return segments
+}
+
+func TestFairQueueNextWeightedRoundRobin(t *testing.T) {
+	bufferPool := bufferpool.Init(100, 256*1024)
+	q := NewFairQueue(defaultCapacity, bufferPool, map[string]int{"a": 2}, 10)
+	a := sendTestSegments(q, "a", 3)
+	b := sendTestSegments(q, "b", 2)
+
+	expected := []*tracesegment.TraceSegment{a[0], a[1], b[0], a[2], b[1]}
+	for i, e := range expected {
+		s, ok := q.Next()
+		assert.True(t, ok)
+		assert.Equal(t, e, s, "Unexpected segment at position %v", i)
+	}
+	_, ok := q.Next()
+	assert.False(t, ok, "The queue should be empty")
+}
+
+func TestFairQueueSendFullDropsFromLargestService(t *testing.T) {
+	log := test.LogSetup()
+	bufferPool := bufferpool.Init(100, 256*1024)
+	q := NewFairQueue(defaultCapacity, bufferPool, nil, 10)
+	chatty := sendTestSegments(q, "chatty", defaultCapacity)
+	quiet := sendTestSegments(q, "quiet", 1)
+
+	s, _ := q.Next()
+	assert.Equal(t, chatty[1], s, "The oldest segment of the chatty service should be dropped")
+	s, _ = q.Next()
+	assert.Equal(t, quiet[0], s, "The segment of the quiet service should be kept")
+	assert.Equal(t, uint64(1), q.TruncatedCount())
+	assert.True(t, strings.Contains(log.Logs[0], "Segment queue of service \"chatty\" is over its share."))
+}
+
+func TestFairQueueSendFullKeepsGuaranteedShare(t *testing.T) {
+	test.LogSetup()
+	bufferPool := bufferpool.Init(100, 256*1024)
+	q := NewFairQueue(defaultCapacity, bufferPool, nil, 50)
+	half := defaultCapacity / 2
+	a := sendTestSegments(q, "a", half)
+	b := sendTestSegments(q, "b", defaultCapacity-half)
+	sendTestSegments(q, "a", 1)
+
+	assert.Equal(t, uint64(1), q.TruncatedCount())
+	for i := 0; i < half; i++ {
+		s, _ := q.Next()
+		assert.NotEqual(t, a[0], s, "The oldest segment of the sending service should be dropped")
+		s, _ = q.Next()
+		assert.Equal(t, b[i], s, "Segments within the guaranteed share should be kept")
+	}
+}
+
+// drain returns the segments left in q in the order they are sent.
+func drain(q *FairQueue) []*tracesegment.TraceSegment {
+	var segments []*tracesegment.TraceSegment
+	for {
+		s, ok := q.Next()
+		if !ok {
+			return segments
+		}
+		segments = append(segments, s)
+	}
+}
+
+func TestFairQueueSendFullDropNewestFromLargestService(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	bufferPool := bufferpool.Init(100, 256*1024)
+	q := NewFairQueueWithOverflow(defaultCapacity, bufferPool, nil, 10, overflow.Config{Policy: overflow.DropNewest})
+	chatty := sendTestSegments(q, "chatty", defaultCapacity)
+	quiet := sendTestSegments(q, "quiet", 1)
+
+	segments := drain(q)
+	assert.Equal(t, defaultCapacity, len(segments))
+	assert.Contains(t, segments, quiet[0], "The segment of the quiet service should be kept")
+	assert.NotContains(t, segments, chatty[defaultCapacity-1], "The newest segment of the chatty service should be dropped")
+	assert.Equal(t, uint64(1), q.TruncatedCount())
+	assert.EqualValues(t, 1, telemetry.T.Counter("fairqueue.overflow.drop-newest"))
+}
+
+func TestFairQueueSendFullDropNewestOwnSegment(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	bufferPool := bufferpool.Init(100, 256*1024)
+	q := NewFairQueueWithOverflow(defaultCapacity, bufferPool, nil, 10, overflow.Config{Policy: overflow.DropNewest})
+	chatty := sendTestSegments(q, "chatty", defaultCapacity+1)
+
+	segments := drain(q)
+	assert.Equal(t, chatty[:defaultCapacity], segments, "The segment sent to the full queue should be dropped")
+	assert.Equal(t, uint64(1), q.TruncatedCount())
+}
+
+func TestFairQueueSendFullSpill(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	spiller := &mockSpiller{}
+	bufferPool := bufferpool.Init(100, 256*1024)
+	q := NewFairQueueWithOverflow(defaultCapacity, bufferPool, nil, 10, overflow.Config{Policy: overflow.Spill, Spiller: spiller})
+	chatty := sendTestSegments(q, "chatty", defaultCapacity)
+	quiet := sendTestSegments(q, "quiet", 1)
+
+	assert.Equal(t, []string{string(*chatty[defaultCapacity-1].Raw)}, spiller.docs)
+	assert.Contains(t, drain(q), quiet[0])
+	assert.Equal(t, uint64(0), q.TruncatedCount())
+	assert.EqualValues(t, 1, telemetry.T.Counter("fairqueue.overflow.spill"))
+	assert.EqualValues(t, 1, SpilledCount())
+}
+
+func TestFairQueueSendFullBlock(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	bufferPool := bufferpool.Init(100, 256*1024)
+	q := NewFairQueueWithOverflow(defaultCapacity, bufferPool, nil, 10, overflow.Config{Policy: overflow.Block, BlockTimeout: time.Hour})
+	sendTestSegments(q, "a", defaultCapacity)
+
+	sent := make(chan struct{})
+	go func() {
+		sendTestSegments(q, "b", 1)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("Send should wait for room in the queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+	q.Next()
+	<-sent
+
+	assert.Equal(t, defaultCapacity, len(drain(q)))
+	assert.Equal(t, uint64(0), q.TruncatedCount())
+	assert.EqualValues(t, 1, telemetry.T.Counter("fairqueue.overflow.block"))
+}
+
+func TestFairQueueSendFullBlockTimeout(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	bufferPool := bufferpool.Init(100, 256*1024)
+	q := NewFairQueueWithOverflow(defaultCapacity, bufferPool, nil, 10, overflow.Config{Policy: overflow.Block, BlockTimeout: time.Millisecond})
+	a := sendTestSegments(q, "a", defaultCapacity+1)
+
+	assert.Equal(t, a[:defaultCapacity], drain(q))
+	assert.Equal(t, uint64(1), q.TruncatedCount())
+	assert.EqualValues(t, 1, telemetry.T.Counter("fairqueue.overflow.block-timeout"))
+}
+
+func TestFairQueueReclaim(t *testing.T) {
+	test.LogSetup()
+	bufferPool := bufferpool.Init(100, 256*1024)
+	q := NewFairQueue(defaultCapacity, bufferPool, nil, 10)
+	sendTestSegments(q, "quiet", 1)
+
+	assert.False(t, q.Reclaim(), "No service is above its share")
+
+	sendTestSegments(q, "chatty", defaultCapacity/2)
+
+	assert.True(t, q.Reclaim())
+	assert.Equal(t, uint64(1), q.TruncatedCount())
+}
+
+func TestFairQueueClose(t *testing.T) {
+	bufferPool := bufferpool.Init(100, 256*1024)
+	q := NewFairQueue(defaultCapacity, bufferPool, nil, 10)
+	segments := sendTestSegments(q, "a", 1)
+	q.Close()
+
+	<-q.Ready
+	s, ok := q.Next()
+	assert.True(t, ok)
+	assert.Equal(t, segments[0], s)
+	<-q.Ready
+	_, ok = q.Next()
+	assert.False(t, ok)
+	assert.True(t, q.Closed())
+}
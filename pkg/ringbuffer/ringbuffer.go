@@ -13,14 +13,20 @@ import (
 	log "github.com/cihub/seelog"
 
 	"os"
+	"time"
 
 	"github.com/aws/aws-xray-daemon/pkg/bufferpool"
+	"github.com/aws/aws-xray-daemon/pkg/deadletter"
+	"github.com/aws/aws-xray-daemon/pkg/overflow"
 	"github.com/aws/aws-xray-daemon/pkg/telemetry"
 	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
 )
 
 var defaultCapacity = 250
 
+// Name of the ring buffer used in telemetry counters.
+const counterName = "ringbuffer"
+
 // RingBuffer is used to store trace segment received on X-Ray daemon address.
 type RingBuffer struct {
 	// Channel used to store trace segment received on X-Ray daemon address.
@@ -35,10 +41,22 @@ type RingBuffer struct {
 
 	// Reference to BufferPool.
 	pool *bufferpool.BufferPool
+
+	// Lower priority ring buffer receiving segments when this one is full, nil if none.
+	lower *RingBuffer
+
+	// Overflow policy applied when the buffer is full.
+	overflow overflow.Config
 }
 
 // New returns new instance of RingBuffer configured with  BufferPool pool.
 func New(bufferCount int, pool *bufferpool.BufferPool) *RingBuffer {
+	return NewWithOverflow(bufferCount, pool, overflow.Config{Policy: overflow.DropOldest})
+}
+
+// NewWithOverflow returns new instance of RingBuffer configured with BufferPool pool
+// and overflow policy o applied when the buffer is full.
+func NewWithOverflow(bufferCount int, pool *bufferpool.BufferPool, o overflow.Config) *RingBuffer {
 	if bufferCount == 0 {
 		log.Error("The initial size of a queue should be larger than 0")
 		os.Exit(1)
@@ -47,14 +65,24 @@ func New(bufferCount int, pool *bufferpool.BufferPool) *RingBuffer {
 	channel := make(chan *tracesegment.TraceSegment, capacity)
 
 	return &RingBuffer{
-		Channel: channel,
-		c:       channel,
-		Empty:   false,
-		count:   0,
-		pool:    pool,
+		Channel:  channel,
+		c:        channel,
+		Empty:    false,
+		count:    0,
+		pool:     pool,
+		overflow: o,
 	}
 }
 
+// NewPriority returns new instance of RingBuffer configured with BufferPool pool, which hands
+// segments over to the lower priority ring buffer instead of dropping its own when full.
+// Segments in the priority ring buffer are therefore the last to spill over.
+func NewPriority(bufferCount int, pool *bufferpool.BufferPool, lower *RingBuffer) *RingBuffer {
+	r := New(bufferCount, pool)
+	r.lower = lower
+	return r
+}
+
 // getChannelSize returns the size of the channel used by RingBuffer
 // Currently 1X times the total number of allocated buffers for the X-Ray daemon is returned.
 // This is proportional to number of buffers, since the segments are dropped if no new buffer can be allocated.
@@ -68,7 +96,31 @@ func getChannelSize(bufferCount int) int {
 }
 
 // Send sends trace segment s to trace segment channel.
+// If the channel is full, the segment is handled by the overflow policy of the ring buffer.
 func (r *RingBuffer) Send(s *tracesegment.TraceSegment) {
+	select {
+	case r.c <- s:
+		return
+	default:
+	}
+	if r.lower != nil {
+		log.Debug("Priority segment buffer is full. Sending segment to lower priority buffer.")
+		r.lower.Send(s)
+		return
+	}
+	switch r.overflow.Policy {
+	case overflow.DropNewest:
+		r.drop(s, overflow.DropNewest.Counter(counterName))
+	case overflow.Block:
+		r.block(s)
+	case overflow.Spill:
+		r.spill(s)
+	default:
+		r.dropOldest(s)
+	}
+}
+
+func (r *RingBuffer) dropOldest(s *tracesegment.TraceSegment) {
 	select {
 	case r.c <- s:
 	default:
@@ -76,16 +128,52 @@ func (r *RingBuffer) Send(s *tracesegment.TraceSegment) {
 		select {
 		case segmentTruncated = <-r.c:
 			r.count++
+			deadletter.D.Record(deadletter.ReasonBufferFull, "", *segmentTruncated.Raw)
 			r.pool.Return(segmentTruncated.PoolBuf)
 			log.Warn("Segment buffer is full. Dropping oldest segment document.")
 			telemetry.T.SegmentSpillover(1)
+			telemetry.T.Count(overflow.DropOldest.Counter(counterName), 1)
 		default:
 			log.Trace("Buffers: channel was de-queued")
 		}
-		r.Send(s)
+		r.dropOldest(s)
+	}
+}
+
+// drop drops segment s and increments telemetry counter.
+func (r *RingBuffer) drop(s *tracesegment.TraceSegment, counter string) {
+	r.count++
+	deadletter.D.Record(deadletter.ReasonBufferFull, "", *s.Raw)
+	r.pool.Return(s.PoolBuf)
+	log.Warn("Segment buffer is full. Dropping newest segment document.")
+	telemetry.T.SegmentSpillover(1)
+	telemetry.T.Count(counter, 1)
+}
+
+// block waits for room in the channel up to the block timeout, then drops segment s.
+func (r *RingBuffer) block(s *tracesegment.TraceSegment) {
+	telemetry.T.Count(overflow.Block.Counter(counterName), 1)
+	timer := time.NewTimer(r.overflow.BlockTimeout)
+	defer timer.Stop()
+	select {
+	case r.c <- s:
+	case <-timer.C:
+		r.drop(s, overflow.Block.Counter(counterName)+"-timeout")
 	}
 }
 
+// spill writes segment s to secondary storage, and drops it if that fails.
+func (r *RingBuffer) spill(s *tracesegment.TraceSegment) {
+	if err := r.overflow.Spiller.Spill([]string{string(*s.Raw)}); err != nil {
+		log.Errorf("Unable to spill segment document: %v", err)
+		r.drop(s, overflow.Spill.Counter(counterName)+"-failed")
+		return
+	}
+	r.pool.Return(s.PoolBuf)
+	log.Debug("Segment buffer is full. Spilled newest segment document.")
+	telemetry.T.Count(overflow.Spill.Counter(counterName), 1)
+}
+
 // Close closes the RingBuffer.
 func (r *RingBuffer) Close() {
 	close(r.c)
@@ -95,3 +183,8 @@ func (r *RingBuffer) Close() {
 func (r *RingBuffer) TruncatedCount() uint64 {
 	return r.count
 }
+
+// SpilledCount returns number of trace segments spilled by the spill overflow policy of all ring buffers and fair queues.
+func SpilledCount() int64 {
+	return telemetry.T.Counter(overflow.Spill.Counter(counterName)) + telemetry.T.Counter(overflow.Spill.Counter(fairCounterName))
+}
@@ -10,13 +10,16 @@
 package ringbuffer
 
 import (
+	"errors"
 	"math/rand"
 	"os"
 	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-xray-daemon/pkg/bufferpool"
+	"github.com/aws/aws-xray-daemon/pkg/overflow"
 	"github.com/aws/aws-xray-daemon/pkg/telemetry"
 	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
 	"github.com/aws/aws-xray-daemon/pkg/util/test"
@@ -161,6 +164,122 @@ func TestRingBufferSendTruncated(t *testing.T) {
 	assert.True(t, strings.Contains(log.Logs[0], "Segment buffer is full. Dropping oldest segment document."))
 }
 
+func TestPriorityRingBufferSendFullToLower(t *testing.T) {
+	log := test.LogSetup()
+	bufferLimit := 100
+	bufferSize := 256 * 1024
+	bufferPool := bufferpool.Init(bufferLimit, bufferSize)
+	std := New(defaultCapacity, bufferPool)
+	pri := NewPriority(defaultCapacity, bufferPool, std)
+	var segment []tracesegment.TraceSegment
+	for i := 0; i < defaultCapacity; i++ {
+		segment = append(segment, tracesegment.GetTestTraceSegment())
+		pri.Send(&segment[i])
+	}
+	s1 := tracesegment.GetTestTraceSegment()
+	pri.Send(&s1)
+
+	assert.Equal(t, &segment[0], <-pri.c, "Priority segments should not be truncated")
+	assert.Equal(t, &s1, <-std.c, "Segment should be sent to lower priority buffer")
+	assert.Equal(t, uint64(0), pri.TruncatedCount(), "The truncated count should be 0")
+	assert.Equal(t, uint64(0), std.TruncatedCount(), "The truncated count should be 0")
+	assert.True(t, strings.Contains(log.Logs[0], "Priority segment buffer is full."))
+}
+
+type mockSpiller struct {
+	docs []string
+	err  error
+}
+
+func (m *mockSpiller) Spill(docs []string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.docs = append(m.docs, docs...)
+	return nil
+}
+
+func getFullTestRingBuffer(o overflow.Config) (*RingBuffer, []tracesegment.TraceSegment) {
+	bufferPool := bufferpool.Init(100, 256*1024)
+	ringBuffer := NewWithOverflow(defaultCapacity, bufferPool, o)
+	var segment []tracesegment.TraceSegment
+	for i := 0; i < defaultCapacity; i++ {
+		segment = append(segment, tracesegment.GetTestTraceSegment())
+		ringBuffer.Send(&segment[i])
+	}
+	return ringBuffer, segment
+}
+
+func TestRingBufferSendDropNewest(t *testing.T) {
+	log := test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	ringBuffer, segment := getFullTestRingBuffer(overflow.Config{Policy: overflow.DropNewest})
+	s1 := tracesegment.GetTestTraceSegment()
+
+	ringBuffer.Send(&s1)
+
+	assert.Equal(t, &segment[0], <-ringBuffer.c, "Oldest segment should be kept")
+	assert.Equal(t, uint64(1), ringBuffer.TruncatedCount())
+	assert.EqualValues(t, 1, telemetry.T.Counter("ringbuffer.overflow.drop-newest"))
+	assert.True(t, strings.Contains(log.Logs[0], "Segment buffer is full. Dropping newest segment document."))
+}
+
+func TestRingBufferSendBlock(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	ringBuffer, segment := getFullTestRingBuffer(overflow.Config{Policy: overflow.Block, BlockTimeout: time.Second})
+	s1 := tracesegment.GetTestTraceSegment()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-ringBuffer.c
+	}()
+	ringBuffer.Send(&s1)
+
+	assert.Equal(t, &segment[1], <-ringBuffer.c)
+	assert.Equal(t, uint64(0), ringBuffer.TruncatedCount())
+	assert.EqualValues(t, 1, telemetry.T.Counter("ringbuffer.overflow.block"))
+	assert.EqualValues(t, 0, telemetry.T.Counter("ringbuffer.overflow.block-timeout"))
+}
+
+func TestRingBufferSendBlockTimeout(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	ringBuffer, segment := getFullTestRingBuffer(overflow.Config{Policy: overflow.Block, BlockTimeout: time.Millisecond})
+	s1 := tracesegment.GetTestTraceSegment()
+
+	ringBuffer.Send(&s1)
+
+	assert.Equal(t, &segment[0], <-ringBuffer.c, "Oldest segment should be kept")
+	assert.Equal(t, uint64(1), ringBuffer.TruncatedCount())
+	assert.EqualValues(t, 1, telemetry.T.Counter("ringbuffer.overflow.block-timeout"))
+}
+
+func TestRingBufferSendSpill(t *testing.T) {
+	telemetry.T = telemetry.GetTestTelemetry()
+	spiller := &mockSpiller{}
+	ringBuffer, segment := getFullTestRingBuffer(overflow.Config{Policy: overflow.Spill, Spiller: spiller})
+	s1 := tracesegment.GetTestTraceSegment()
+
+	ringBuffer.Send(&s1)
+
+	assert.Equal(t, &segment[0], <-ringBuffer.c, "Oldest segment should be kept")
+	assert.Equal(t, []string{string(*s1.Raw)}, spiller.docs)
+	assert.Equal(t, uint64(0), ringBuffer.TruncatedCount())
+	assert.EqualValues(t, 1, telemetry.T.Counter("ringbuffer.overflow.spill"))
+}
+
+func TestRingBufferSendSpillFailed(t *testing.T) {
+	test.LogSetup()
+	telemetry.T = telemetry.GetTestTelemetry()
+	spiller := &mockSpiller{err: errors.New("disk full")}
+	ringBuffer, _ := getFullTestRingBuffer(overflow.Config{Policy: overflow.Spill, Spiller: spiller})
+	s1 := tracesegment.GetTestTraceSegment()
+
+	ringBuffer.Send(&s1)
+
+	assert.Equal(t, uint64(1), ringBuffer.TruncatedCount())
+	assert.EqualValues(t, 1, telemetry.T.Counter("ringbuffer.overflow.spill-failed"))
+}
+
 // getTestChannelSize returns a random number greater than or equal to defaultCapacity
 func getTestChannelSize() int {
 	return rand.Intn(50) + defaultCapacity
@@ -0,0 +1,124 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+// Package routing picks the destination segments are sent to by rules on their document, header or source address.
+package routing
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+
+	"github.com/aws/aws-xray-daemon/pkg/cfg"
+	"github.com/aws/aws-xray-daemon/pkg/filter"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	log "github.com/cihub/seelog"
+)
+
+// Fields of rules which are not part of the segment document.
+const (
+	// Tenant field of the segment header.
+	fieldTenant = "tenant"
+
+	// IP address the segment was received from.
+	fieldSource = "source"
+)
+
+// Router evaluates segments against rules, in order, the first matching rule giving the destination.
+type Router struct {
+	rules []*rule
+}
+
+type rule struct {
+	field       string
+	pattern     *regexp.Regexp
+	destination string
+}
+
+// New returns a Router for the given rules. An error is returned if a rule has an unknown field
+// or match type, its pattern does not compile, or its destination is not one of destinations.
+func New(rules []cfg.RoutingRule, destinations []string) (*Router, error) {
+	known := make(map[string]bool, len(destinations))
+	for _, d := range destinations {
+		known[d] = true
+	}
+	r := &Router{
+		rules: make([]*rule, 0, len(rules)),
+	}
+	for _, c := range rules {
+		if c.Field != fieldTenant && c.Field != fieldSource && !filter.IsValidField(c.Field) {
+			return nil, fmt.Errorf("routing: unknown field %q", c.Field)
+		}
+		if !known[c.Destination] {
+			return nil, fmt.Errorf("routing: unknown destination %q for field %q", c.Destination, c.Field)
+		}
+		expr, ok := filter.Expression(c.Pattern, c.Match)
+		if !ok {
+			return nil, fmt.Errorf("routing: unknown match type %q for field %q", c.Match, c.Field)
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("routing: invalid pattern for field %q: %v", c.Field, err)
+		}
+		r.rules = append(r.rules, &rule{field: c.Field, pattern: pattern, destination: c.Destination})
+	}
+	return r, nil
+}
+
+// Enabled returns true if the router has at least one rule.
+func (r *Router) Enabled() bool {
+	return r != nil && len(r.rules) > 0
+}
+
+// Route returns the destination of segment ts, received with header from address source,
+// empty for the default destination. Source is nil for segments not read from the socket.
+// Segments that cannot be parsed only match rules on the tenant or source.
+func (r *Router) Route(header tracesegment.Header, source net.Addr, ts *tracesegment.TraceSegment) string {
+	if !r.Enabled() {
+		return ""
+	}
+	for _, rl := range r.rules {
+		value, ok := rl.value(header, source, ts)
+		if ok && rl.pattern.MatchString(value) {
+			return rl.destination
+		}
+	}
+	return ""
+}
+
+// value returns the value of the rule field for segment ts, false if the field is not set.
+func (rl *rule) value(header tracesegment.Header, source net.Addr, ts *tracesegment.TraceSegment) (string, bool) {
+	switch rl.field {
+	case fieldTenant:
+		return header.Tenant, header.Tenant != ""
+	case fieldSource:
+		return sourceIP(source)
+	}
+	doc, err := ts.Document()
+	if err != nil {
+		log.Debugf("routing: unable to parse segment: %v", err)
+		return "", false
+	}
+	return filter.FieldValue(doc, rl.field)
+}
+
+// sourceIP returns the IP address of source, without port, false if source is nil.
+func sourceIP(source net.Addr) (string, bool) {
+	if source == nil {
+		return "", false
+	}
+	if udp, ok := source.(*net.UDPAddr); ok {
+		return udp.IP.String(), true
+	}
+	host, _, err := net.SplitHostPort(source.String())
+	if err != nil {
+		return source.String(), true
+	}
+	return host, true
+}
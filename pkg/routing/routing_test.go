@@ -0,0 +1,105 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package routing
+
+import (
+	"net"
+	"testing"
+
+	"github.com/aws/aws-xray-daemon/pkg/cfg"
+	"github.com/aws/aws-xray-daemon/pkg/tracesegment"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestSegment(doc string) *tracesegment.TraceSegment {
+	raw := []byte(doc)
+	return &tracesegment.TraceSegment{
+		Raw: &raw,
+	}
+}
+
+var checkout = `{"trace_id":"1-5759e988-bd862e3fe1be46a994272793","id":"defdfd9912dc5a56","name":"checkout",` +
+	`"annotations":{"account":"123456789012"}}`
+
+func TestNewWithInvalidField(t *testing.T) {
+	r, err := New([]cfg.RoutingRule{{Field: "http.response.status", Pattern: "200", Destination: "a"}}, []string{"a"})
+
+	assert.Nil(t, r)
+	assert.EqualError(t, err, "routing: unknown field \"http.response.status\"")
+}
+
+func TestNewWithUnknownDestination(t *testing.T) {
+	r, err := New([]cfg.RoutingRule{{Field: "tenant", Pattern: "a", Destination: "b"}}, []string{"a"})
+
+	assert.Nil(t, r)
+	assert.EqualError(t, err, "routing: unknown destination \"b\" for field \"tenant\"")
+}
+
+func TestNewWithInvalidPattern(t *testing.T) {
+	r, err := New([]cfg.RoutingRule{{Field: "name", Pattern: "web(", Match: "regex", Destination: "a"}}, []string{"a"})
+
+	assert.Nil(t, r)
+	assert.Contains(t, err.Error(), "routing: invalid pattern for field \"name\"")
+}
+
+func TestRoute(t *testing.T) {
+	source := &net.UDPAddr{IP: net.ParseIP("10.0.1.7"), Port: 41234}
+	testCases := []struct {
+		rule     cfg.RoutingRule
+		header   tracesegment.Header
+		source   net.Addr
+		expected string
+	}{
+		{cfg.RoutingRule{Field: "name", Pattern: "check*"}, tracesegment.Header{}, nil, "a"},
+		{cfg.RoutingRule{Field: "annotations.account", Pattern: "^1234.*$", Match: "regex"}, tracesegment.Header{}, nil, "a"},
+		{cfg.RoutingRule{Field: "annotations.account", Pattern: "999*"}, tracesegment.Header{}, nil, ""},
+		{cfg.RoutingRule{Field: "tenant", Pattern: "payments"}, tracesegment.Header{Tenant: "payments"}, nil, "a"},
+		{cfg.RoutingRule{Field: "tenant", Pattern: "payments"}, tracesegment.Header{}, nil, ""},
+		{cfg.RoutingRule{Field: "source", Pattern: "10.0.1.*"}, tracesegment.Header{}, source, "a"},
+		{cfg.RoutingRule{Field: "source", Pattern: "10.0.2.*"}, tracesegment.Header{}, source, ""},
+		{cfg.RoutingRule{Field: "source", Pattern: "*"}, tracesegment.Header{}, nil, ""},
+	}
+	for _, testCase := range testCases {
+		testCase.rule.Destination = "a"
+		r, err := New([]cfg.RoutingRule{testCase.rule}, []string{"a"})
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, testCase.expected, r.Route(testCase.header, testCase.source, getTestSegment(checkout)), "%+v", testCase.rule)
+	}
+}
+
+func TestRouteFirstMatchingRule(t *testing.T) {
+	r, err := New([]cfg.RoutingRule{
+		{Field: "tenant", Pattern: "payments", Destination: "payments"},
+		{Field: "name", Pattern: "*", Destination: "default"},
+	}, []string{"payments", "default"})
+	assert.Nil(t, err)
+
+	assert.EqualValues(t, "payments", r.Route(tracesegment.Header{Tenant: "payments"}, nil, getTestSegment(checkout)))
+	assert.EqualValues(t, "default", r.Route(tracesegment.Header{Tenant: "search"}, nil, getTestSegment(checkout)))
+}
+
+func TestRouteUnparsableSegment(t *testing.T) {
+	r, err := New([]cfg.RoutingRule{
+		{Field: "name", Pattern: "*", Destination: "a"},
+		{Field: "tenant", Pattern: "payments", Destination: "b"},
+	}, []string{"a", "b"})
+	assert.Nil(t, err)
+
+	assert.EqualValues(t, "", r.Route(tracesegment.Header{}, nil, getTestSegment("{")))
+	assert.EqualValues(t, "b", r.Route(tracesegment.Header{Tenant: "payments"}, nil, getTestSegment("{")))
+}
+
+func TestRouteDisabled(t *testing.T) {
+	var r *Router
+
+	assert.False(t, r.Enabled())
+	assert.EqualValues(t, "", r.Route(tracesegment.Header{Tenant: "payments"}, nil, getTestSegment(checkout)))
+}
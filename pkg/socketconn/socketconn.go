@@ -9,11 +9,16 @@
 
 package socketconn
 
+import "net"
+
 // SocketConn is an interface for socket connection.
 type SocketConn interface {
 	// Reads a packet from the connection, copying the payload into b. It returns number of bytes copied.
 	Read(b []byte) (int, error)
 
+	// Reads a packet from the connection like Read, also returning the address it was sent from.
+	ReadFrom(b []byte) (int, net.Addr, error)
+
 	// Closes the connection.
 	Close()
 }
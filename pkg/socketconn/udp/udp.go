@@ -46,6 +46,16 @@ func (conn UDP) Read(b []byte) (int, error) {
 	return rlen, err
 }
 
+// ReadFrom returns number of bytes read from the UDP connection, along with the address of the sender.
+func (conn UDP) ReadFrom(b []byte) (int, net.Addr, error) {
+	rlen, addr, err := conn.socket.ReadFromUDP(b)
+	if addr == nil {
+		// Avoid returning a nil *net.UDPAddr as a non-nil net.Addr.
+		return rlen, nil, err
+	}
+	return rlen, addr, err
+}
+
 // Close closes current UDP connection.
 func (conn UDP) Close() {
 	err := conn.socket.Close()
@@ -0,0 +1,86 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Extension of the file being written.
+const tempExtension = ".tmp"
+
+// Files names, seals and lists the files of a directory written one at a time. The file being written
+// ends with .tmp, which is removed once it is sealed. File names start with their zero padded creation
+// time, so they sort oldest first.
+type Files struct {
+	// Directory holding the files.
+	Dir string
+
+	// Prefix of the file names.
+	Prefix string
+
+	// Extension of sealed files.
+	Extension string
+
+	// Sequence number used to name files created within the same nanosecond.
+	seq uint64
+}
+
+// Create creates a file to write, named after now. Not safe for concurrent use.
+func (f *Files) Create(now time.Time) (*os.File, error) {
+	f.seq++
+	name := filepath.Join(f.Dir, fmt.Sprintf("%v%020d-%06d%v%v", f.Prefix, now.UnixNano(), f.seq, f.Extension, tempExtension))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %v: %v", name, err)
+	}
+	return file, nil
+}
+
+// Seal closes file, created by Create, and removes its temporary extension so it can be read.
+func Seal(file *os.File) error {
+	name := file.Name()
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("unable to close %v: %v", name, err)
+	}
+	if err := os.Rename(name, strings.TrimSuffix(name, tempExtension)); err != nil {
+		return fmt.Errorf("unable to seal %v: %v", name, err)
+	}
+	return nil
+}
+
+// Recover seals the files left being written by a previous run.
+func (f *Files) Recover() error {
+	names, err := filepath.Glob(filepath.Join(f.Dir, f.Prefix+"*"+tempExtension))
+	if err != nil {
+		return fmt.Errorf("unable to list %v: %v", f.Dir, err)
+	}
+	for _, name := range names {
+		if err := os.Rename(name, strings.TrimSuffix(name, tempExtension)); err != nil {
+			return fmt.Errorf("unable to seal %v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Sealed returns the names of sealed files, oldest first.
+func (f *Files) Sealed() ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(f.Dir, f.Prefix+"*"+f.Extension))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list %v: %v", f.Dir, err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
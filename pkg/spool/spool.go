@@ -0,0 +1,367 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+// Package spool stores segment documents on disk as newline delimited JSON files.
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// Extension of spool files ready to be read.
+const fileExtension = ".ndjson"
+
+// Size after which the file being written is closed and a new one is started.
+const maxFileBytes = 1024 * 1024
+
+// Spool writes segment documents to files in a directory, and replays them.
+type Spool struct {
+	// Files of the spool, in its directory.
+	files Files
+
+	// Maximum number of bytes held by spool files, 0 for no limit.
+	maxBytes int64
+
+	// Age after which spool files are removed without being replayed, 0 for no expiry.
+	ttl time.Duration
+
+	lock sync.Mutex
+
+	// Bytes held by spool files.
+	size int64
+
+	// File being written, nil if none.
+	current *os.File
+
+	// Bytes written to current file.
+	currentSize int
+
+	// Number of documents removed by the size limit or expiry without being replayed.
+	dropped int64
+}
+
+// New returns a Spool writing to directory dir, which is created if missing.
+func New(dir string) (*Spool, error) {
+	return NewWithLimits(dir, 0, 0)
+}
+
+// NewWithLimits returns a Spool writing to directory dir, which is created if missing.
+// Spool files hold at most maxBytes, the oldest files being removed to make room, and
+// files older than ttl are not replayed. Zero values disable the limits.
+// Files left unsealed by a previous run are sealed.
+func NewWithLimits(dir string, maxBytes int64, ttl time.Duration) (*Spool, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("spool: directory is empty")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("spool: unable to create directory %v: %v", dir, err)
+	}
+	s := &Spool{
+		files:    Files{Dir: dir, Extension: fileExtension},
+		maxBytes: maxBytes,
+		ttl:      ttl,
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Spill appends docs to the spool. Documents which are not valid JSON are skipped.
+func (s *Spool) Spill(docs []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.current == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		// Compacting guarantees a document spans a single line.
+		if err := json.Compact(&buf, []byte(doc)); err != nil {
+			log.Warnf("spool: skipping invalid segment document: %v", err)
+			continue
+		}
+		buf.WriteByte('\n')
+	}
+	if err := s.reserve(int64(buf.Len())); err != nil {
+		return err
+	}
+	n, err := s.current.Write(buf.Bytes())
+	s.currentSize += n
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("spool: unable to write %v: %v", s.current.Name(), err)
+	}
+	if s.currentSize >= maxFileBytes {
+		return s.seal()
+	}
+	return nil
+}
+
+// Close seals the file being written so it can be read.
+func (s *Spool) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	return s.seal()
+}
+
+// Len returns the number of documents held by the spool files.
+func (s *Spool) Len() (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names, err := s.sealed()
+	if err != nil {
+		return 0, err
+	}
+	if s.current != nil {
+		names = append(names, s.current.Name())
+	}
+	n := 0
+	for _, name := range names {
+		docs, err := readFile(name)
+		if err != nil {
+			return n, err
+		}
+		n += len(docs)
+	}
+	return n, nil
+}
+
+// DroppedCount returns the number of documents removed by the size limit or expiry without being replayed.
+func (s *Spool) DroppedCount() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Replay sends spooled documents to send in batches of at most batchSize, oldest first,
+// and removes them once sent. Expired files are removed without being sent. Replay stops
+// at the first failed send, keeping the documents not sent yet. Returns the number of
+// documents sent.
+func (s *Spool) Replay(batchSize int, send func(docs []string) error) (int, error) {
+	s.lock.Lock()
+	if s.current != nil {
+		// Documents being written are replayed as well.
+		if err := s.seal(); err != nil {
+			s.lock.Unlock()
+			return 0, err
+		}
+	}
+	names, err := s.sealed()
+	s.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, name := range names {
+		if s.expired(name) {
+			log.Warnf("spool: removing expired file %v", name)
+			s.countDropped(name)
+			s.remove(name)
+			continue
+		}
+		docs, err := readFile(name)
+		if os.IsNotExist(err) {
+			// Removed to make room since listed.
+			continue
+		}
+		if err != nil {
+			return sent, err
+		}
+		total := len(docs)
+		for len(docs) > 0 {
+			n := batchSize
+			if n > len(docs) {
+				n = len(docs)
+			}
+			if err := send(docs[:n]); err != nil {
+				if len(docs) < total {
+					s.rewrite(name, docs)
+				}
+				return sent, err
+			}
+			sent += n
+			docs = docs[n:]
+		}
+		s.remove(name)
+	}
+	return sent, nil
+}
+
+// recover seals files left unsealed by a previous run and computes the spool size.
+func (s *Spool) recover() error {
+	if err := s.files.Recover(); err != nil {
+		return fmt.Errorf("spool: %v", err)
+	}
+	names, err := s.sealed()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			return fmt.Errorf("spool: unable to read %v: %v", name, err)
+		}
+		s.size += info.Size()
+	}
+	return nil
+}
+
+// sealed returns the names of sealed files, oldest first. Must be called with the lock held.
+func (s *Spool) sealed() ([]string, error) {
+	names, err := s.files.Sealed()
+	if err != nil {
+		return nil, fmt.Errorf("spool: %v", err)
+	}
+	return names, nil
+}
+
+// reserve removes the oldest sealed files until n more bytes fit in the spool. Must be called with the lock held.
+func (s *Spool) reserve(n int64) error {
+	if s.maxBytes == 0 {
+		return nil
+	}
+	for s.size+n > s.maxBytes {
+		names, err := s.sealed()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("spool: size limit of %v bytes reached", s.maxBytes)
+		}
+		log.Warnf("spool: size limit reached, removing oldest file %v", names[0])
+		s.countDropped(names[0])
+		if err := s.removeLocked(names[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countDropped counts the documents of file name, about to be removed without being replayed.
+func (s *Spool) countDropped(name string) {
+	if docs, err := readFile(name); err == nil {
+		atomic.AddInt64(&s.dropped, int64(len(docs)))
+	}
+}
+
+// expired returns true if the file name was created more than ttl ago.
+func (s *Spool) expired(name string) bool {
+	if s.ttl == 0 {
+		return false
+	}
+	created, err := strconv.ParseInt(strings.SplitN(filepath.Base(name), "-", 2)[0], 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Since(time.Unix(0, created)) > s.ttl
+}
+
+// remove removes sealed file name.
+func (s *Spool) remove(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.removeLocked(name); err != nil {
+		log.Errorf("%v", err)
+	}
+}
+
+// removeLocked removes sealed file name. Must be called with the lock held.
+func (s *Spool) removeLocked(name string) error {
+	info, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("spool: unable to read %v: %v", name, err)
+	}
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("spool: unable to remove %v: %v", name, err)
+	}
+	s.size -= info.Size()
+	return nil
+}
+
+// rewrite replaces the content of sealed file name with docs, unless the file was removed.
+func (s *Spool) rewrite(name string, docs []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	info, err := os.Stat(name)
+	if err != nil {
+		return
+	}
+	content := strings.Join(docs, "\n") + "\n"
+	tempName := name + tempExtension
+	if err := os.WriteFile(tempName, []byte(content), 0600); err != nil {
+		log.Errorf("spool: unable to rewrite %v: %v", name, err)
+		return
+	}
+	if err := os.Rename(tempName, name); err != nil {
+		log.Errorf("spool: unable to rewrite %v: %v", name, err)
+		return
+	}
+	s.size += int64(len(content)) - info.Size()
+}
+
+// readFile returns the documents of spool file name.
+func readFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var docs []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxFileBytes*2)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			docs = append(docs, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("spool: unable to read %v: %v", name, err)
+	}
+	return docs, nil
+}
+
+func (s *Spool) open() error {
+	f, err := s.files.Create(time.Now())
+	if err != nil {
+		return fmt.Errorf("spool: %v", err)
+	}
+	s.current = f
+	s.currentSize = 0
+	return nil
+}
+
+// seal closes the file being written and renames it so it can be read.
+func (s *Spool) seal() error {
+	err := Seal(s.current)
+	s.current = nil
+	if err != nil {
+		return fmt.Errorf("spool: %v", err)
+	}
+	return nil
+}
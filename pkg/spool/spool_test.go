@@ -0,0 +1,193 @@
+// Copyright 2018-2026 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestSpool(t *testing.T) (*Spool, string) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(filepath.Join(dir, "segments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, s.files.Dir
+}
+
+func TestNewEmptyDirectory(t *testing.T) {
+	s, err := New("")
+
+	assert.Nil(t, s)
+	assert.EqualError(t, err, "spool: directory is empty")
+}
+
+func TestSpillAndClose(t *testing.T) {
+	s, dir := getTestSpool(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	err := s.Spill([]string{"{\"id\": \"1\",\n \"name\": \"web\"}", "{\"id\":\"2\"}"})
+	assert.Nil(t, err)
+	err = s.Spill([]string{"{\"id\":\"3\"}"})
+	assert.Nil(t, err)
+
+	pending, _ := filepath.Glob(filepath.Join(dir, "*"+fileExtension))
+	assert.Empty(t, pending, "File being written should not be readable")
+	n, err := s.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n, "Documents being written are held by the spool")
+
+	assert.Nil(t, s.Close())
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+fileExtension))
+	assert.Equal(t, 1, len(files))
+	content, _ := ioutil.ReadFile(files[0])
+	assert.Equal(t, "{\"id\":\"1\",\"name\":\"web\"}\n{\"id\":\"2\"}\n{\"id\":\"3\"}\n", string(content))
+}
+
+func TestSpillSkipsInvalidDocument(t *testing.T) {
+	s, dir := getTestSpool(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	err := s.Spill([]string{"{\"id\": 1", "{\"id\":\"2\"}"})
+	assert.Nil(t, err)
+	s.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+fileExtension))
+	content, _ := ioutil.ReadFile(files[0])
+	assert.Equal(t, "{\"id\":\"2\"}\n", string(content))
+}
+
+func TestSpillRotatesFile(t *testing.T) {
+	s, dir := getTestSpool(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	doc := "{\"name\":\"" + strings.Repeat("a", maxFileBytes/2) + "\"}"
+
+	s.Spill([]string{doc})
+	s.Spill([]string{doc})
+	s.Spill([]string{doc})
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+fileExtension))
+	assert.Equal(t, 1, len(files), "File should be sealed once it reaches maximum size")
+	s.Close()
+	files, _ = filepath.Glob(filepath.Join(dir, "*"+fileExtension))
+	assert.Equal(t, 2, len(files))
+}
+
+func TestReplay(t *testing.T) {
+	s, dir := getTestSpool(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	s.Spill([]string{"{\"id\":\"1\"}", "{\"id\":\"2\"}", "{\"id\":\"3\"}"})
+	var batches [][]string
+
+	sent, err := s.Replay(2, func(docs []string) error {
+		batches = append(batches, docs)
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, sent)
+	assert.Equal(t, [][]string{{"{\"id\":\"1\"}", "{\"id\":\"2\"}"}, {"{\"id\":\"3\"}"}}, batches)
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Empty(t, files, "Replayed files should be removed")
+	assert.EqualValues(t, 0, s.size)
+}
+
+func TestReplayFailureKeepsUnsentDocuments(t *testing.T) {
+	s, dir := getTestSpool(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	s.Spill([]string{"{\"id\":\"1\"}", "{\"id\":\"2\"}", "{\"id\":\"3\"}"})
+	calls := 0
+
+	sent, err := s.Replay(2, func(docs []string) error {
+		calls++
+		if calls == 2 {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+
+	assert.EqualError(t, err, "unreachable")
+	assert.Equal(t, 2, sent)
+	var replayed []string
+	s.Replay(2, func(docs []string) error {
+		replayed = append(replayed, docs...)
+		return nil
+	})
+	assert.Equal(t, []string{"{\"id\":\"3\"}"}, replayed)
+}
+
+func TestReplayRemovesExpiredFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(dir)
+	s, _ := NewWithLimits(dir, 0, time.Minute)
+	expired := filepath.Join(dir, fmt.Sprintf("%020d-%06d%v", time.Now().Add(-time.Hour).UnixNano(), 1, fileExtension))
+	ioutil.WriteFile(expired, []byte("{\"id\":\"1\"}\n"), 0600)
+	s.Spill([]string{"{\"id\":\"2\"}"})
+
+	var replayed []string
+	s.Replay(10, func(docs []string) error {
+		replayed = append(replayed, docs...)
+		return nil
+	})
+
+	assert.Equal(t, []string{"{\"id\":\"2\"}"}, replayed)
+	_, err := os.Stat(expired)
+	assert.True(t, os.IsNotExist(err))
+	assert.EqualValues(t, 1, s.DroppedCount())
+	n, _ := s.Len()
+	assert.Equal(t, 0, n)
+}
+
+func TestSpillSizeLimitRemovesOldestFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(dir)
+	doc := "{\"name\":\"" + strings.Repeat("a", 100) + "\"}"
+	s, _ := NewWithLimits(dir, int64(len(doc)+1)*2, 0)
+
+	assert.Nil(t, s.Spill([]string{doc}))
+	s.Close()
+	assert.Nil(t, s.Spill([]string{doc}))
+	s.Close()
+	assert.Nil(t, s.Spill([]string{doc}))
+	s.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+fileExtension))
+	assert.Equal(t, 2, len(files), "Oldest file should be removed to make room")
+	assert.EqualValues(t, 1, s.DroppedCount())
+	assert.Error(t, s.Spill([]string{doc, doc, doc}), "Spill larger than the limit should fail")
+}
+
+func TestNewSealsUnsealedFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(dir)
+	s, _ := New(dir)
+	s.Spill([]string{"{\"id\":\"1\"}"})
+
+	// Simulates a restart without Close.
+	s, err := New(dir)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, len("{\"id\":\"1\"}\n"), s.size)
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+fileExtension))
+	assert.Equal(t, 1, len(files))
+}
@@ -11,9 +11,12 @@ package telemetry
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
+	"net/http"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -37,8 +40,19 @@ const requestSize = 10
 // T is instance of Telemetry.
 var T *Telemetry
 
+// Totals holds the segment counts since the daemon started.
+type Totals struct {
+	Received  int64 `json:"received"`
+	Sent      int64 `json:"sent"`
+	Spillover int64 `json:"spillover"`
+	Rejected  int64 `json:"rejected"`
+}
+
 // Telemetry is used to record X-Ray daemon health.
 type Telemetry struct {
+	// Segment counts since the daemon started, first so they are 64-bit aligned for atomic operations.
+	totals Totals
+
 	// Instance of XRay.
 	client conn.XRay
 	timer  timer.Timer
@@ -70,14 +84,25 @@ type Telemetry struct {
 	// When segment is received, postTelemetry is set to true,
 	// indicating send telemetry data for the received segment.
 	postTelemetry bool
+
+	// Daemon counters keyed by name. These are not part of telemetry records sent to X-Ray,
+	// they are logged at the end of every data cutoff interval and served by the admin server.
+	counters     map[string]*int64
+	countersLock sync.Mutex
 }
 
 // Init instantiates a new instance of Telemetry.
 func Init(ctx context.Context, cfg aws.Config, resourceARN string, noMetadata bool) {
-	T = newT(ctx, cfg, resourceARN, noMetadata)
+	T = newT(ctx, conn.NewXRay(cfg), resourceARN, noMetadata)
 	log.Debug("Telemetry initiated")
 }
 
+// InitLocal instantiates a new instance of Telemetry which keeps records without sending them to X-Ray service.
+func InitLocal(ctx context.Context, resourceARN string) {
+	T = newT(ctx, nil, resourceARN, true)
+	log.Debug("Local telemetry initiated")
+}
+
 // EvaluateConnectionError processes error with respect to request failure status code.
 func EvaluateConnectionError(err error) {
 	var oe *smithy.OperationError
@@ -117,6 +142,7 @@ func GetTestTelemetry() *Telemetry {
 
 // SegmentReceived increments SegmentsReceivedCount for the Telemetry record.
 func (t *Telemetry) SegmentReceived(count int64) {
+	atomic.AddInt64(&t.totals.Received, count)
 	atomic.AddInt32(t.currentRecord.SegmentsReceivedCount, int32(count))
 	// Only send telemetry data when we receive any segment or else skip any telemetry data
 	t.postTelemetry = true
@@ -124,16 +150,19 @@ func (t *Telemetry) SegmentReceived(count int64) {
 
 // SegmentSent increments SegmentsSentCount for the Telemetry record.
 func (t *Telemetry) SegmentSent(count int64) {
+	atomic.AddInt64(&t.totals.Sent, count)
 	atomic.AddInt32(t.currentRecord.SegmentsSentCount, int32(count))
 }
 
 // SegmentSpillover increments SegmentsSpilloverCount for the Telemetry record.
 func (t *Telemetry) SegmentSpillover(count int64) {
+	atomic.AddInt64(&t.totals.Spillover, count)
 	atomic.AddInt32(t.currentRecord.SegmentsSpilloverCount, int32(count))
 }
 
 // SegmentRejected increments SegmentsRejectedCount for the Telemetry record.
 func (t *Telemetry) SegmentRejected(count int64) {
+	atomic.AddInt64(&t.totals.Rejected, count)
 	atomic.AddInt32(t.currentRecord.SegmentsRejectedCount, int32(count))
 }
 
@@ -167,7 +196,69 @@ func (t *Telemetry) ConnectionOther(count int64) {
 	atomic.AddInt32(t.currentRecord.BackendConnectionErrors.OtherCount, int32(count))
 }
 
-func newT(ctx context.Context, cfg aws.Config, resourceARN string, noMetadata bool) *Telemetry {
+// Totals returns the segment counts since the daemon started.
+func (t *Telemetry) Totals() Totals {
+	return Totals{
+		Received:  atomic.LoadInt64(&t.totals.Received),
+		Sent:      atomic.LoadInt64(&t.totals.Sent),
+		Spillover: atomic.LoadInt64(&t.totals.Spillover),
+		Rejected:  atomic.LoadInt64(&t.totals.Rejected),
+	}
+}
+
+// ServeHTTP lists the segment counts since the daemon started and the daemon counters as a JSON object.
+func (t *Telemetry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Segments Totals           `json:"segments"`
+		Counters map[string]int64 `json:"counters"`
+	}{
+		Segments: t.Totals(),
+		Counters: t.Counters(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("Unable to write daemon counters: %v", err)
+	}
+}
+
+// Count increments the daemon counter name by count.
+func (t *Telemetry) Count(name string, count int64) {
+	t.countersLock.Lock()
+	if t.counters == nil {
+		t.counters = make(map[string]*int64)
+	}
+	counter, ok := t.counters[name]
+	if !ok {
+		counter = new(int64)
+		t.counters[name] = counter
+	}
+	t.countersLock.Unlock()
+	atomic.AddInt64(counter, count)
+}
+
+// Counter returns the value of the daemon counter name.
+func (t *Telemetry) Counter(name string) int64 {
+	t.countersLock.Lock()
+	counter, ok := t.counters[name]
+	t.countersLock.Unlock()
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt64(counter)
+}
+
+// Counters returns a snapshot of all daemon counters.
+func (t *Telemetry) Counters() map[string]int64 {
+	t.countersLock.Lock()
+	defer t.countersLock.Unlock()
+	snapshot := make(map[string]int64, len(t.counters))
+	for name, counter := range t.counters {
+		snapshot[name] = atomic.LoadInt64(counter)
+	}
+	return snapshot
+}
+
+func newT(ctx context.Context, client conn.XRay, resourceARN string, noMetadata bool) *Telemetry {
 	timer := &timer.Client{}
 	hostname := ""
 	instanceID := ""
@@ -238,8 +329,7 @@ func newT(ctx context.Context, cfg aws.Config, resourceARN string, noMetadata bo
 		recordChan:    make(chan types.TelemetryRecord, bufferSize),
 		postTelemetry: false,
 	}
-	telemetryClient := conn.NewXRay(cfg)
-	t.client = telemetryClient
+	t.client = client
 	go t.pushData(ctx)
 	return t
 }
@@ -289,6 +379,9 @@ func (t *Telemetry) pushData(ctx context.Context) {
 		record.Timestamp = &currentTime
 		t.add(*record)
 		t.sendAll(ctx)
+		if counters := t.Counters(); len(counters) > 0 {
+			log.Debugf("Daemon counters: %v", counters)
+		}
 		if quit {
 			close(t.recordChan)
 			log.Debug("telemetry: done!")
@@ -321,6 +414,10 @@ func (t *Telemetry) add(record types.TelemetryRecord) {
 
 func (t *Telemetry) sendAll(ctx context.Context) {
 	records := t.collectAllRecords()
+	if t.client == nil {
+		log.Debugf("Dropped %v telemetry record(s) of local telemetry", len(records))
+		return
+	}
 	recordsNoSend, err := t.sendRecords(ctx, records)
 	if err != nil {
 		log.Debugf("Failed to send telemetry %v record(s). Re-queue records. %v", len(records), err)
@@ -13,6 +13,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"github.com/aws/aws-xray-daemon/pkg/util/test"
@@ -156,3 +158,47 @@ func TestAddRecordBeforeFirstSegmentAndAfter(t *testing.T) {
 
 	assert.True(t, strings.Contains(log.Logs[1], "Telemetry Buffers truncated"))
 }
+
+func TestCount(t *testing.T) {
+	telemetry := GetTestTelemetry()
+
+	telemetry.Count("ringbuffer.overflow.drop-newest", 1)
+	telemetry.Count("ringbuffer.overflow.drop-newest", 2)
+	telemetry.Count("batch.overflow.spill", 5)
+
+	assert.EqualValues(t, 3, telemetry.Counter("ringbuffer.overflow.drop-newest"))
+	assert.EqualValues(t, 5, telemetry.Counter("batch.overflow.spill"))
+	assert.EqualValues(t, 0, telemetry.Counter("batch.overflow.block"))
+	assert.EqualValues(t, map[string]int64{
+		"ringbuffer.overflow.drop-newest": 3,
+		"batch.overflow.spill":            5,
+	}, telemetry.Counters())
+}
+
+func TestTotals(t *testing.T) {
+	telemetry := GetTestTelemetry()
+
+	telemetry.SegmentReceived(5)
+	telemetry.SegmentSent(3)
+	telemetry.SegmentSpillover(1)
+	telemetry.SegmentRejected(1)
+	// Rotating the record keeps the totals.
+	telemetry.currentRecord = getEmptyTelemetryRecord()
+	telemetry.SegmentReceived(2)
+
+	assert.EqualValues(t, Totals{Received: 7, Sent: 3, Spillover: 1, Rejected: 1}, telemetry.Totals())
+}
+
+func TestServeHTTP(t *testing.T) {
+	telemetry := GetTestTelemetry()
+	telemetry.SegmentReceived(2)
+	telemetry.SegmentSent(1)
+	telemetry.Count("sink.archive.dropped", 4)
+
+	w := httptest.NewRecorder()
+	telemetry.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/counters", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"segments":{"received":2,"sent":1,"spillover":0,"rejected":0},"counters":{"sink.archive.dropped":4}}`, w.Body.String())
+}
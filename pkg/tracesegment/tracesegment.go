@@ -12,6 +12,7 @@ package tracesegment
 import (
 	"bytes"
 	"compress/zlib"
+	"encoding/json"
 	log "github.com/cihub/seelog"
 	"strings"
 )
@@ -20,6 +21,12 @@ import (
 type Header struct {
 	Format  string `json:"format"`
 	Version int    `json:"version"`
+
+	// Priority, if true, sends the segment through the priority ring buffer.
+	Priority bool `json:"priority,omitempty"`
+
+	// Tenant the segment belongs to.
+	Tenant string `json:"tenant,omitempty"`
 }
 
 // IsValid validates Header.
@@ -31,6 +38,85 @@ func (t Header) IsValid() bool {
 type TraceSegment struct {
 	Raw     *[]byte
 	PoolBuf *[]byte
+
+	// Destination the segment is routed to, empty for the default destination.
+	Route string
+
+	// Header the segment was received with.
+	Header Header
+
+	// Parsed fields of Raw, populated on first call to Document().
+	doc *Document
+}
+
+// Document stores the fields of a segment document inspected by the daemon.
+type Document struct {
+	TraceID string `json:"trace_id"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Origin  string `json:"origin"`
+	HTTP    struct {
+		Request struct {
+			URL    string `json:"url"`
+			Method string `json:"method"`
+		} `json:"request"`
+	} `json:"http"`
+	Annotations map[string]interface{} `json:"annotations"`
+	Error       bool                   `json:"error"`
+	Fault       bool                   `json:"fault"`
+	Throttle    bool                   `json:"throttle"`
+	InProgress  bool                   `json:"in_progress"`
+	Subsegments []Subsegment           `json:"subsegments"`
+}
+
+// Subsegment stores the flags of a subsegment embedded in a segment document.
+type Subsegment struct {
+	Error       bool         `json:"error"`
+	Fault       bool         `json:"fault"`
+	Throttle    bool         `json:"throttle"`
+	Subsegments []Subsegment `json:"subsegments"`
+}
+
+// HasError returns true if the segment is marked with error, fault or throttle.
+func (d *Document) HasError() bool {
+	return d.Error || d.Fault || d.Throttle
+}
+
+// HasErrorFlag returns true if the segment, or a subsegment embedded in it, is marked with error,
+// fault or throttle. Flags are read from the parsed segment, so annotations and metadata holding the
+// same keys are ignored. Segments which cannot be parsed have no flag.
+func (r *TraceSegment) HasErrorFlag() bool {
+	doc, err := r.Document()
+	if err != nil {
+		return false
+	}
+	return doc.HasError() || hasError(doc.Subsegments)
+}
+
+// hasError returns true if any of subsegments, or of their own subsegments, is marked with error,
+// fault or throttle.
+func hasError(subsegments []Subsegment) bool {
+	for i := range subsegments {
+		s := &subsegments[i]
+		if s.Error || s.Fault || s.Throttle || hasError(s.Subsegments) {
+			return true
+		}
+	}
+	return false
+}
+
+// Document parses the raw segment and returns its Document.
+// The result is cached, so the segment is parsed at most once.
+func (r *TraceSegment) Document() (*Document, error) {
+	if r.doc != nil {
+		return r.doc, nil
+	}
+	doc := &Document{}
+	if err := json.Unmarshal(*r.Raw, doc); err != nil {
+		return nil, err
+	}
+	r.doc = doc
+	return doc, nil
 }
 
 // Deflate converts TraceSegment to bytes
@@ -100,3 +100,80 @@ func TestTraceSegmentHeaderIsValidWrongFormatVersion(t *testing.T) {
 
 	assert.False(t, valid)
 }
+
+func TestDocument(t *testing.T) {
+	raw := []byte(`{"trace_id":"1-5759e988-bd862e3fe1be46a994272793","id":"defdfd9912dc5a56","name":"web",` +
+		`"origin":"AWS::EC2::Instance","http":{"request":{"url":"http://localhost/health","method":"GET"}},` +
+		`"annotations":{"team":"checkout"}}`)
+	segment := TraceSegment{Raw: &raw}
+
+	doc, err := segment.Document()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", doc.TraceID)
+	assert.Equal(t, "defdfd9912dc5a56", doc.ID)
+	assert.Equal(t, "web", doc.Name)
+	assert.Equal(t, "AWS::EC2::Instance", doc.Origin)
+	assert.Equal(t, "http://localhost/health", doc.HTTP.Request.URL)
+	assert.Equal(t, "GET", doc.HTTP.Request.Method)
+	assert.Equal(t, "checkout", doc.Annotations["team"])
+
+	cached, _ := segment.Document()
+	assert.True(t, doc == cached, "Document should be parsed once")
+}
+
+func TestDocumentInvalid(t *testing.T) {
+	raw := []byte(`{"name": web}`)
+	segment := TraceSegment{Raw: &raw}
+
+	doc, err := segment.Document()
+
+	assert.Nil(t, doc)
+	assert.NotNil(t, err)
+}
+
+func TestDocumentHasError(t *testing.T) {
+	testCases := map[string]bool{
+		`{"name":"web"}`:                 false,
+		`{"name":"web","error":true}`:    true,
+		`{"name":"web","fault":true}`:    true,
+		`{"name":"web","throttle":true}`: true,
+		`{"name":"web","fault":false}`:   false,
+	}
+	for raw, expected := range testCases {
+		rawBytes := []byte(raw)
+		segment := TraceSegment{Raw: &rawBytes}
+
+		doc, err := segment.Document()
+
+		assert.Nil(t, err)
+		assert.Equal(t, expected, doc.HasError(), raw)
+	}
+}
+
+func TestHasErrorFlag(t *testing.T) {
+	testCases := map[string]bool{
+		`{"name":"web"}`:                                                     false,
+		`{"name":"web","error":true}`:                                        true,
+		`{"name":"web", "fault" : true}`:                                     true,
+		`{"name":"web","throttle":true}`:                                     true,
+		`{"name":"web","fault":false}`:                                       false,
+		`{"name":"web","fault":false,"error":true}`:                          true,
+		`{"name":"fault","annotations":{"error":"true"}}`:                    false,
+		`{"name":"web","subsegments":[{"fault":true}]}`:                      true,
+		`{"name":"web","metadata":{"log":"\"fault\":true"}}`:                 false,
+		`{"name":"web","metadata":{"default":{"fault":true}}}`:               false,
+		`{"name":"web","subsegments":[{"subsegments":[{"throttle":true}]}]}`: true,
+		`{"name":"web","subsegments":[{"annotations":{"error":true}}]}`:      false,
+		`{"name":"web","error":true`:                                         false,
+	}
+	for raw, expected := range testCases {
+		rawBytes := []byte(raw)
+		segment := TraceSegment{Raw: &rawBytes}
+		assert.Equal(t, expected, segment.HasErrorFlag(), raw)
+
+		parsed := TraceSegment{Raw: &rawBytes}
+		parsed.Document()
+		assert.Equal(t, expected, parsed.HasErrorFlag(), "Parsed "+raw)
+	}
+}